@@ -1,11 +1,31 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
-	"os"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	"code.cloudfoundry.org/debugserver"
 	loggingclient "code.cloudfoundry.org/diego-logging-client"
+	"code.cloudfoundry.org/durationjson"
+	"code.cloudfoundry.org/fileserver"
+	"code.cloudfoundry.org/fileserver/handlers/accesslog"
+	"code.cloudfoundry.org/fileserver/handlers/admission"
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/fileserver/handlers/otlp"
+	"code.cloudfoundry.org/fileserver/handlers/prometheus"
+	"code.cloudfoundry.org/fileserver/handlers/ratelimit"
+	"code.cloudfoundry.org/fileserver/handlers/redirect"
+	"code.cloudfoundry.org/fileserver/handlers/throttle"
+	"code.cloudfoundry.org/fileserver/handlers/watchdog"
+	"code.cloudfoundry.org/fileserver/registration"
+	"code.cloudfoundry.org/fileserver/server"
+	"code.cloudfoundry.org/fileserver/storage"
 	"code.cloudfoundry.org/lager/lagerflags"
 )
 
@@ -15,31 +35,256 @@ type FileServerConfig struct {
 	ConsulCluster                   string `json:"consul_cluster,omitempty"`
 	EnableConsulServiceRegistration bool   `json:"enable_consul_service_registration,omitempty"`
 
+	Storage storage.Config `json:"storage"`
+
+	Registration registration.Config `json:"registration"`
+
 	HTTPSServerEnabled bool   `json:"https_server_enabled"`
 	HTTPSListenAddr    string `json:"https_listen_addr"`
 	CertFile           string `json:"cert_file"`
 	KeyFile            string `json:"key_file"`
+	ClientCAFile       string `json:"client_ca_file,omitempty"`
+
+	HTTPSRedirect redirect.Config `json:"https_redirect"`
+
+	Listeners []server.ListenerConfig `json:"listeners,omitempty"`
 
-	LoggregatorConfig loggingclient.Config `json:"loggregator"`
+	RateLimit       ratelimit.Config `json:"rate_limit"`
+	Bandwidth       throttle.Config  `json:"bandwidth"`
+	Admission       admission.Config `json:"admission"`
+	MinTransferRate watchdog.Config  `json:"min_transfer_rate"`
+	AccessLog       accesslog.Config `json:"access_log"`
+	Tracing         otlp.Config      `json:"tracing"`
+	Health          health.Config    `json:"health"`
+
+	WarmUpDigestCache bool `json:"warm_up_digest_cache,omitempty"`
+
+	LoggregatorConfig loggingclient.Config  `json:"loggregator"`
+	ReportInterval    durationjson.Duration `json:"report_interval,omitempty"`
+	server.ServerConfig
 	debugserver.DebugServerConfig
+	prometheus.MetricsServerConfig
 	lagerflags.LagerConfig
+
+	unmatchedEnvironment []string
 }
 
+// NewFileServerConfig reads the config file at configPath and applies the
+// overrides found in the environment, as described for EnvPrefix.
 func NewFileServerConfig(configPath string) (FileServerConfig, error) {
-	fileServerConfig := FileServerConfig{}
+	fileServerConfig := FileServerConfig{
+		ServerConfig:   server.DefaultServerConfig(),
+		ReportInterval: durationjson.Duration(time.Minute),
+	}
 
-	configFile, err := os.Open(configPath)
+	data, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return FileServerConfig{}, err
 	}
 
-	defer configFile.Close()
+	err = json.Unmarshal(data, &fileServerConfig)
+	if err != nil {
+		return FileServerConfig{}, err
+	}
+
+	err = applyEnvironment(&fileServerConfig)
+	if err != nil {
+		return FileServerConfig{}, err
+	}
 
-	decoder := json.NewDecoder(configFile)
-	err = decoder.Decode(&fileServerConfig)
+	unknown, err := unknownSettings(data, reflect.TypeOf(fileServerConfig), "")
 	if err != nil {
 		return FileServerConfig{}, err
 	}
+	if len(unknown) > 0 {
+		return fileServerConfig, &UnknownSettingsError{Settings: unknown}
+	}
 
 	return fileServerConfig, nil
 }
+
+// UnknownSettingsError names every setting of a config file that the file
+// server does not know, such as a misspelt one. NewFileServerConfig returns
+// it along with the rest of the configuration, so that the other settings
+// can still be validated.
+type UnknownSettingsError struct {
+	Settings []string
+}
+
+func (e *UnknownSettingsError) Error() string {
+	return "unknown settings: " + strings.Join(e.Settings, ", ")
+}
+
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// unknownSettings returns the names of the keys of the JSON object in data
+// that match no field of t, looking into nested objects and arrays of
+// objects. Keys are matched without regard to case, as encoding/json does.
+func unknownSettings(data []byte, t reflect.Type, prefix string) ([]string, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]reflect.StructField{}
+	collectFields(t, fields)
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var unknown []string
+	for _, key := range keys {
+		field, ok := fields[strings.ToLower(key)]
+		if !ok {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+
+		names, err := unknownNestedSettings(object[key], field.Type, prefix+key)
+		if err != nil {
+			return nil, err
+		}
+		unknown = append(unknown, names...)
+	}
+	return unknown, nil
+}
+
+func unknownNestedSettings(data json.RawMessage, t reflect.Type, name string) ([]string, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(jsonUnmarshaler) {
+		return nil, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if !isObject(data) {
+			return nil, nil
+		}
+		return unknownSettings(data, t, name+".")
+	case reflect.Slice, reflect.Array:
+		var elements []json.RawMessage
+		if err := json.Unmarshal(data, &elements); err != nil {
+			return nil, nil
+		}
+		var unknown []string
+		for i, element := range elements {
+			names, err := unknownNestedSettings(element, t.Elem(), fmt.Sprintf("%s[%d]", name, i))
+			if err != nil {
+				return nil, err
+			}
+			unknown = append(unknown, names...)
+		}
+		return unknown, nil
+	}
+	return nil, nil
+}
+
+// collectFields maps the lowercased JSON name of every exported field of t,
+// those of embedded structs included, to the field.
+func collectFields(t reflect.Type, fields map[string]reflect.StructField) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			collectFields(field.Type, fields)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := settingName(field)
+		if name == "-" {
+			continue
+		}
+		if _, ok := fields[strings.ToLower(name)]; !ok {
+			fields[strings.ToLower(name)] = field
+		}
+	}
+}
+
+func isObject(data json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(data)
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// ListenerConfigs returns the listeners to run: the configured ones, or else
+// the ones described by server_address, the https_* settings and
+// https_redirect.plain_listener. Listeners that neither name route groups nor
+// redirect serve every route group.
+func (c FileServerConfig) ListenerConfigs() []server.ListenerConfig {
+	listeners := c.Listeners
+	if len(listeners) == 0 {
+		listeners = c.legacyListeners()
+	}
+
+	configs := make([]server.ListenerConfig, 0, len(listeners))
+	for _, listener := range listeners {
+		if len(listener.Routes) == 0 && listener.RedirectTo == "" {
+			listener.Routes = fileserver.AllRouteGroups
+		}
+		configs = append(configs, listener)
+	}
+	return configs
+}
+
+func (c FileServerConfig) legacyListeners() []server.ListenerConfig {
+	if !c.HTTPSServerEnabled {
+		return []server.ListenerConfig{{Name: "http", Address: c.ServerAddress}}
+	}
+
+	listeners := []server.ListenerConfig{{
+		Name:         "https",
+		Address:      c.HTTPSListenAddr,
+		CertFile:     c.CertFile,
+		KeyFile:      c.KeyFile,
+		ClientCAFile: c.ClientCAFile,
+	}}
+	switch c.HTTPSRedirect.PlainListener {
+	case redirect.ModeHealthOnly:
+		listeners = append(listeners, server.ListenerConfig{Name: "http", Address: c.ServerAddress, Routes: []string{fileserver.HealthRouteGroup}})
+	case redirect.ModeDisabled:
+	default:
+		listeners = append(listeners, server.ListenerConfig{Name: "http", Address: c.ServerAddress, RedirectTo: "https"})
+	}
+	return listeners
+}
+
+// reloadable lists the settings that take effect without a restart when the
+// configuration file is read again.
+var reloadable = map[string]bool{
+	"rate_limit":        true,
+	"log_level":         true,
+	"bandwidth":         true,
+	"admission":         true,
+	"min_transfer_rate": true,
+}
+
+// RestartRequired returns the names of the settings that differ between c and
+// next but only take effect when the file server restarts.
+func (c FileServerConfig) RestartRequired(next FileServerConfig) []string {
+	return changedSettings(reflect.ValueOf(c), reflect.ValueOf(next), nil)
+}
+
+func changedSettings(current, next reflect.Value, changed []string) []string {
+	for i := 0; i < current.NumField(); i++ {
+		field := current.Type().Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			changed = changedSettings(current.Field(i), next.Field(i), changed)
+			continue
+		}
+
+		name := settingName(field)
+		if field.PkgPath != "" || reloadable[name] {
+			continue
+		}
+		if !reflect.DeepEqual(current.Field(i).Interface(), next.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
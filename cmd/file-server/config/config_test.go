@@ -1,11 +1,27 @@
 package config_test
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
+	"time"
 
 	"code.cloudfoundry.org/debugserver"
+	"code.cloudfoundry.org/durationjson"
+	"code.cloudfoundry.org/fileserver"
 	"code.cloudfoundry.org/fileserver/cmd/file-server/config"
+	"code.cloudfoundry.org/fileserver/handlers/accesslog"
+	"code.cloudfoundry.org/fileserver/handlers/admission"
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/fileserver/handlers/otlp"
+	"code.cloudfoundry.org/fileserver/handlers/prometheus"
+	"code.cloudfoundry.org/fileserver/handlers/ratelimit"
+	"code.cloudfoundry.org/fileserver/handlers/redirect"
+	"code.cloudfoundry.org/fileserver/handlers/throttle"
+	"code.cloudfoundry.org/fileserver/handlers/watchdog"
+	"code.cloudfoundry.org/fileserver/registration"
+	"code.cloudfoundry.org/fileserver/server"
+	"code.cloudfoundry.org/fileserver/storage"
 	"code.cloudfoundry.org/lager/lagerflags"
 
 	. "github.com/onsi/ginkgo"
@@ -21,13 +37,91 @@ var _ = Describe("Config", func() {
 			"static_directory": "/tmp/static",
 			"consul_cluster": "consul.example.com",
 			"enable_consul_service_registration": true,
+			"storage": {
+				"backend": "s3",
+				"s3": {
+					"endpoint": "https://blobstore.service.cf.internal",
+					"region": "eu-central-1",
+					"bucket": "cc-packages",
+					"prefix": "file-server",
+					"access_key_id": "file-server",
+					"secret_access_key": "secret"
+				}
+			},
+			"registration": {
+				"backend": "http",
+				"service_name": "blobstore",
+				"service_id": "blobstore-z1-0",
+				"tags": ["z1"],
+				"meta": {"zone": "z1"},
+				"ttl": "10s",
+				"file": {"path": "/var/vcap/data/file-server/registration.json"},
+				"http": {"url": "http://localhost:8090/instances"}
+			},
 
 			"https_server_enabled": true,
 			"https_listen_addr": "192.168.1.1:8443",
 			"cert_file": "/tmp/cert_file",
 			"key_file": "/tmp/key_file",
+			"client_ca_file": "/tmp/client_ca_file",
+
+			"rate_limit": {
+				"read_requests_per_second": 50,
+				"read_burst": 100,
+				"write_requests_per_second": 5,
+				"write_burst": 10
+			},
+			"bandwidth": {
+				"global_bytes_per_second": 104857600,
+				"per_connection_bytes_per_second": 10485760,
+				"path_prefix_bytes_per_second": {"/v1/static/rootfs/": 5242880}
+			},
+			"admission": {
+				"max_in_flight": 500,
+				"max_queued": 100,
+				"queue_timeout": "2s"
+			},
+			"min_transfer_rate": {
+				"min_bytes_per_second": 1024,
+				"window": "1m"
+			},
+
+			"access_log": {
+				"path": "/var/vcap/sys/log/file-server/access.log",
+				"format": "json",
+				"max_size_bytes": 10485760,
+				"max_backups": 5
+			},
+
+			"tracing": {
+				"endpoint": "http://localhost:4318",
+				"service_name": "file-server-z1",
+				"batch_size": 100,
+				"flush_interval": "10s"
+			},
+
+			"health": {
+				"min_free_disk_bytes": 1073741824
+			},
+			"warm_up_digest_cache": true,
+
+			"report_interval": "30s",
+
+			"read_header_timeout": "5s",
+			"read_timeout": "30s",
+			"idle_timeout": "90s",
+			"max_header_bytes": 65536,
+			"max_download_duration": "1h",
+			"drain_timeout": "2m",
+			"http2": {
+				"h2c": true,
+				"max_concurrent_streams": 250,
+				"connection_window_bytes": 4194304,
+				"stream_window_bytes": 1048576
+			},
 
 			"debug_address": "127.0.0.1:17017",
+			"metrics_address": "127.0.0.1:17018",
 			"log_level": "debug"
 		}`
 	})
@@ -57,15 +151,95 @@ var _ = Describe("Config", func() {
 			StaticDirectory:                 "/tmp/static",
 			ConsulCluster:                   "consul.example.com",
 			EnableConsulServiceRegistration: true,
+			Storage: storage.Config{
+				Backend: "s3",
+				S3: storage.S3Config{
+					Endpoint:        "https://blobstore.service.cf.internal",
+					Region:          "eu-central-1",
+					Bucket:          "cc-packages",
+					Prefix:          "file-server",
+					AccessKeyID:     "file-server",
+					SecretAccessKey: "secret",
+				},
+			},
+			Registration: registration.Config{
+				Backend:     "http",
+				ServiceName: "blobstore",
+				ServiceID:   "blobstore-z1-0",
+				Tags:        []string{"z1"},
+				Meta:        map[string]string{"zone": "z1"},
+				TTL:         durationjson.Duration(10 * time.Second),
+				File:        registration.FileConfig{Path: "/var/vcap/data/file-server/registration.json"},
+				HTTP:        registration.HTTPConfig{URL: "http://localhost:8090/instances"},
+			},
 
 			HTTPSServerEnabled: true,
 			HTTPSListenAddr:    "192.168.1.1:8443",
 			CertFile:           "/tmp/cert_file",
 			KeyFile:            "/tmp/key_file",
+			ClientCAFile:       "/tmp/client_ca_file",
+
+			RateLimit: ratelimit.Config{
+				ReadRequestsPerSecond:  50,
+				ReadBurst:              100,
+				WriteRequestsPerSecond: 5,
+				WriteBurst:             10,
+			},
+			Bandwidth: throttle.Config{
+				GlobalBytesPerSecond:        104857600,
+				PerConnectionBytesPerSecond: 10485760,
+				PathPrefixBytesPerSecond:    map[string]int64{"/v1/static/rootfs/": 5242880},
+			},
+			Admission: admission.Config{
+				MaxInFlight:  500,
+				MaxQueued:    100,
+				QueueTimeout: durationjson.Duration(2 * time.Second),
+			},
+			MinTransferRate: watchdog.Config{
+				MinBytesPerSecond: 1024,
+				Window:            durationjson.Duration(time.Minute),
+			},
+			AccessLog: accesslog.Config{
+				Path:         "/var/vcap/sys/log/file-server/access.log",
+				Format:       "json",
+				MaxSizeBytes: 10485760,
+				MaxBackups:   5,
+			},
+			Tracing: otlp.Config{
+				Endpoint:      "http://localhost:4318",
+				ServiceName:   "file-server-z1",
+				BatchSize:     100,
+				FlushInterval: durationjson.Duration(10 * time.Second),
+			},
+			Health: health.Config{
+				MinFreeDiskBytes: 1073741824,
+			},
+
+			WarmUpDigestCache: true,
+
+			ReportInterval: durationjson.Duration(30 * time.Second),
+
+			ServerConfig: server.ServerConfig{
+				ReadHeaderTimeout:   durationjson.Duration(5 * time.Second),
+				ReadTimeout:         durationjson.Duration(30 * time.Second),
+				IdleTimeout:         durationjson.Duration(90 * time.Second),
+				MaxHeaderBytes:      65536,
+				MaxDownloadDuration: durationjson.Duration(time.Hour),
+				DrainTimeout:        durationjson.Duration(2 * time.Minute),
+				HTTP2: server.HTTP2Config{
+					H2C:                   true,
+					MaxConcurrentStreams:  250,
+					ConnectionWindowBytes: 4194304,
+					StreamWindowBytes:     1048576,
+				},
+			},
 
 			DebugServerConfig: debugserver.DebugServerConfig{
 				DebugAddress: "127.0.0.1:17017",
 			},
+			MetricsServerConfig: prometheus.MetricsServerConfig{
+				MetricsAddress: "127.0.0.1:17018",
+			},
 			LagerConfig: lagerflags.LagerConfig{
 				LogLevel: "debug",
 			},
@@ -74,6 +248,19 @@ var _ = Describe("Config", func() {
 		Expect(fileserverConfig).To(Equal(expectedConfig))
 	})
 
+	Context("when the server limits and the report interval are omitted", func() {
+		BeforeEach(func() {
+			configData = `{"server_address": "192.168.1.1:8080"}`
+		})
+
+		It("uses the defaults", func() {
+			fileserverConfig, err := config.NewFileServerConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileserverConfig.ServerConfig).To(Equal(server.DefaultServerConfig()))
+			Expect(fileserverConfig.ReportInterval).To(Equal(durationjson.Duration(time.Minute)))
+		})
+	})
+
 	Context("when the file does not exist", func() {
 		It("returns an error", func() {
 			_, err := config.NewFileServerConfig("foobar")
@@ -91,4 +278,101 @@ var _ = Describe("Config", func() {
 			Expect(err).To(HaveOccurred())
 		})
 	})
+
+	Context("when the file contains settings the file server does not know", func() {
+		BeforeEach(func() {
+			configData = `{
+				"server_address": "192.168.1.1:8080",
+				"static_dirctory": "/tmp/static",
+				"Cert_File": "/tmp/cert.pem",
+				"read_timeout": "5s",
+				"registration": {"tagz": ["z1"], "file": {"pth": "/tmp/registration.json"}},
+				"listeners": [{"name": "http", "address": ":8080"}, {"name": "https", "adress": ":8443"}],
+				"bandwidth": {"path_prefix_bytes_per_second": {"/v1/static/": 1024}}
+			}`
+		})
+
+		It("names every one of them, and still returns the rest of the configuration", func() {
+			cfg, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError("unknown settings: listeners[1].adress, registration.file.pth, registration.tagz, static_dirctory"))
+
+			var unknown *config.UnknownSettingsError
+			Expect(errors.As(err, &unknown)).To(BeTrue())
+			Expect(unknown.Settings).To(HaveLen(4))
+			Expect(cfg.ServerAddress).To(Equal("192.168.1.1:8080"))
+			Expect(cfg.CertFile).To(Equal("/tmp/cert.pem"))
+		})
+	})
+
+	Describe("ListenerConfigs", func() {
+		It("describes a single HTTP listener by default", func() {
+			cfg := config.FileServerConfig{ServerAddress: "0.0.0.0:8080"}
+			Expect(cfg.ListenerConfigs()).To(Equal([]server.ListenerConfig{
+				{Name: "http", Address: "0.0.0.0:8080", Routes: fileserver.AllRouteGroups},
+			}))
+		})
+
+		It("describes an HTTPS listener and a redirecting HTTP one when HTTPS is enabled", func() {
+			cfg := config.FileServerConfig{
+				ServerAddress:      "0.0.0.0:8080",
+				HTTPSServerEnabled: true,
+				HTTPSListenAddr:    "0.0.0.0:8443",
+				CertFile:           "cert.pem",
+				KeyFile:            "key.pem",
+			}
+			Expect(cfg.ListenerConfigs()).To(Equal([]server.ListenerConfig{
+				{Name: "https", Address: "0.0.0.0:8443", CertFile: "cert.pem", KeyFile: "key.pem", Routes: fileserver.AllRouteGroups},
+				{Name: "http", Address: "0.0.0.0:8080", RedirectTo: "https"},
+			}))
+
+			cfg.HTTPSRedirect.PlainListener = redirect.ModeHealthOnly
+			Expect(cfg.ListenerConfigs()[1]).To(Equal(server.ListenerConfig{Name: "http", Address: "0.0.0.0:8080", Routes: []string{"health"}}))
+
+			cfg.HTTPSRedirect.PlainListener = redirect.ModeDisabled
+			Expect(cfg.ListenerConfigs()).To(HaveLen(1))
+		})
+
+		It("returns the configured listeners, serving every route group unless told otherwise", func() {
+			cfg := config.FileServerConfig{
+				Listeners: []server.ListenerConfig{
+					{Name: "internal", Address: "10.0.0.1:8080"},
+					{Name: "management", Address: "10.1.0.1:8443", CertFile: "cert.pem", KeyFile: "key.pem", Routes: []string{"health"}},
+				},
+			}
+			Expect(cfg.ListenerConfigs()).To(Equal([]server.ListenerConfig{
+				{Name: "internal", Address: "10.0.0.1:8080", Routes: fileserver.AllRouteGroups},
+				{Name: "management", Address: "10.1.0.1:8443", CertFile: "cert.pem", KeyFile: "key.pem", Routes: []string{"health"}},
+			}))
+		})
+	})
+
+	Describe("RestartRequired", func() {
+		var current config.FileServerConfig
+
+		JustBeforeEach(func() {
+			var err error
+			current, err = config.NewFileServerConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns nothing when only reloadable settings changed", func() {
+			next := current
+			next.RateLimit.ReadRequestsPerSecond = 50
+			next.LogLevel = "info"
+			next.Bandwidth.GlobalBytesPerSecond = 1024
+			next.Admission.MaxInFlight = 10
+			next.MinTransferRate.MinBytesPerSecond = 100
+
+			Expect(current.RestartRequired(next)).To(BeEmpty())
+		})
+
+		It("names the settings that need a restart", func() {
+			next := current
+			next.ServerAddress = "0.0.0.0:9090"
+			next.Registration.Tags = []string{"z2"}
+			next.DrainTimeout = durationjson.Duration(time.Second)
+
+			Expect(current.RestartRequired(next)).To(ConsistOf("server_address", "registration", "drain_timeout"))
+		})
+	})
 })
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the name of every environment variable that overrides a
+// setting of the config file. The rest of the name is the path to the setting
+// in upper case, with underscores between its parts: FILE_SERVER_STATIC_DIRECTORY
+// overrides static_directory and FILE_SERVER_RATE_LIMIT_READ_BURST overrides
+// rate_limit.read_burst. Settings that are not strings, numbers or booleans
+// take their JSON form, such as ["z1","z2"] for registration.tags.
+//
+// A string setting can also be read from a file named by the same variable
+// with FileSuffix appended, such as FILE_SERVER_REGISTRATION_HTTP_URL_FILE,
+// so that secrets can be mounted rather than put in the environment. Such
+// settings are redacted by Redacted.
+const EnvPrefix = "FILE_SERVER_"
+
+const FileSuffix = "_FILE"
+
+const redacted = "[REDACTED]"
+
+func applyEnvironment(c *FileServerConfig) error {
+	known := map[string]bool{}
+	err := walkSettings(reflect.ValueOf(c).Elem(), EnvPrefix, func(name string, setting reflect.Value) error {
+		known[name] = true
+		value, fromEnv := os.LookupEnv(name)
+		path, fromFile := os.LookupEnv(name + FileSuffix)
+
+		switch {
+		case fromEnv && fromFile:
+			return fmt.Errorf("%s and %s cannot both be set", name, name+FileSuffix)
+		case fromEnv:
+			return setSetting(name, setting, value)
+		case fromFile:
+			if setting.Kind() != reflect.String {
+				return fmt.Errorf("%s: only string settings can be read from a file", name+FileSuffix)
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("%s: %s", name+FileSuffix, err)
+			}
+			setting.SetString(strings.TrimRight(string(data), "\r\n"))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.unmatchedEnvironment = nil
+	for _, env := range os.Environ() {
+		name := strings.SplitN(env, "=", 2)[0]
+		if strings.HasPrefix(name, EnvPrefix) && !known[name] && !known[strings.TrimSuffix(name, FileSuffix)] {
+			c.unmatchedEnvironment = append(c.unmatchedEnvironment, name)
+		}
+	}
+	sort.Strings(c.unmatchedEnvironment)
+	return nil
+}
+
+// UnmatchedEnvironment returns the variables starting with EnvPrefix that do
+// not override any setting, such as a misspelt one or FILE_SERVER_PORT set by
+// Kubernetes for a service named file-server. They are ignored.
+func (c FileServerConfig) UnmatchedEnvironment() []string {
+	return c.unmatchedEnvironment
+}
+
+// Redacted returns a copy of the configuration fit for logging, without the
+// settings that were read from files named by the environment or the S3
+// secret access key.
+func (c FileServerConfig) Redacted() FileServerConfig {
+	walkSettings(reflect.ValueOf(&c).Elem(), EnvPrefix, func(name string, setting reflect.Value) error {
+		if _, ok := os.LookupEnv(name + FileSuffix); ok && setting.Kind() == reflect.String {
+			setting.SetString(redacted)
+		}
+		return nil
+	})
+	if c.Storage.S3.SecretAccessKey != "" {
+		c.Storage.S3.SecretAccessKey = redacted
+	}
+	return c
+}
+
+func setSetting(name string, setting reflect.Value, value string) error {
+	if setting.Kind() == reflect.String {
+		setting.SetString(value)
+		return nil
+	}
+
+	// Durations and other settings that are strings in JSON are given
+	// without quotes.
+	target := setting.Addr().Interface()
+	err := json.Unmarshal([]byte(value), target)
+	if err != nil && json.Unmarshal([]byte(strconv.Quote(value)), target) == nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: invalid value %q: %s", name, value, err)
+	}
+	return nil
+}
+
+// walkSettings calls fn with every setting below v and the name of the
+// environment variable that overrides it. Nested sections are walked rather
+// than set as a whole; embedded ones share the prefix of their parent, as
+// they do in the config file.
+func walkSettings(v reflect.Value, prefix string, fn func(name string, setting reflect.Value) error) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := walkSettings(v.Field(i), prefix, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := settingName(field)
+		if name == "-" {
+			continue
+		}
+		name = prefix + strings.ToUpper(name)
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := walkSettings(v.Field(i), name+"_", fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(name, v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settingName returns the name of the setting that field is decoded from.
+func settingName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"code.cloudfoundry.org/fileserver"
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/fileserver/handlers/redirect"
+	"code.cloudfoundry.org/fileserver/registration"
+	"code.cloudfoundry.org/fileserver/server"
+	"code.cloudfoundry.org/fileserver/storage"
+	"code.cloudfoundry.org/lager"
+)
+
+var errNotSet = errors.New("must be set")
+
+// Validate checks the settings that the file server would otherwise only
+// trip over once it has started, and returns every problem it finds, each
+// prefixed with the name of the setting.
+func (c FileServerConfig) Validate() []error {
+	var problems []error
+	invalid := func(setting string, err error) {
+		problems = append(problems, fmt.Errorf("%s: %s", setting, err))
+	}
+
+	switch c.Storage.Backend {
+	case "", storage.LocalBackend:
+		if c.StaticDirectory == "" {
+			invalid("static_directory", errNotSet)
+		} else if err := health.StorageReadable(storage.NewLocal(c.StaticDirectory)).Run(); err != nil {
+			invalid("static_directory", err)
+		}
+	case storage.S3Backend:
+		s3 := c.Storage.S3
+		if s3.Endpoint == "" {
+			invalid("storage.s3.endpoint", errNotSet)
+		} else if u, err := url.Parse(s3.Endpoint); err != nil {
+			invalid("storage.s3.endpoint", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			invalid("storage.s3.endpoint", fmt.Errorf("%q is not an http or https URL", s3.Endpoint))
+		}
+		if s3.Bucket == "" {
+			invalid("storage.s3.bucket", errNotSet)
+		}
+		if (s3.AccessKeyID == "") != (s3.SecretAccessKey == "") {
+			invalid("storage.s3.access_key_id", errors.New("access_key_id and secret_access_key must both be set"))
+		}
+		if c.Health.MinFreeDiskBytes > 0 {
+			invalid("health.min_free_disk_bytes", errors.New("requires the local storage backend"))
+		}
+	default:
+		invalid("storage.backend", fmt.Errorf("unknown backend %q", c.Storage.Backend))
+	}
+
+	if len(c.Listeners) > 0 {
+		if c.ServerAddress != "" || c.HTTPSServerEnabled {
+			invalid("listeners", errors.New("cannot be combined with server_address or https_server_enabled"))
+		}
+		c.validateListeners(invalid)
+	} else {
+		plainListenerDisabled := c.HTTPSServerEnabled && c.HTTPSRedirect.PlainListener == redirect.ModeDisabled
+		if err := validateAddress(c.ServerAddress); err != nil && !plainListenerDisabled {
+			invalid("server_address", err)
+		}
+
+		if c.HTTPSServerEnabled {
+			if err := validateAddress(c.HTTPSListenAddr); err != nil {
+				invalid("https_listen_addr", err)
+			}
+			if c.CertFile == "" || c.KeyFile == "" {
+				invalid("cert_file", errors.New("cert_file and key_file must be set when https_server_enabled is true"))
+			} else if _, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile); err != nil {
+				invalid("cert_file", err)
+			}
+			if c.ClientCAFile != "" {
+				if err := validateCAFile(c.ClientCAFile); err != nil {
+					invalid("client_ca_file", err)
+				}
+			}
+
+			switch c.HTTPSRedirect.PlainListener {
+			case "", redirect.ModeRedirect, redirect.ModeHealthOnly, redirect.ModeDisabled:
+			default:
+				invalid("https_redirect.plain_listener", fmt.Errorf("unknown mode %q", c.HTTPSRedirect.PlainListener))
+			}
+			redirecting := c.HTTPSRedirect.PlainListener == "" || c.HTTPSRedirect.PlainListener == redirect.ModeRedirect
+			if redirecting && c.HTTPSRedirect.PublicPort == 0 && !server.IsTCPAddress(c.HTTPSListenAddr) {
+				invalid("https_redirect.public_port", errors.New("must be set when https_listen_addr is not a host:port"))
+			}
+		}
+	}
+
+	if c.HTTPSRedirect.PublicPort < 0 || c.HTTPSRedirect.PublicPort > 65535 {
+		invalid("https_redirect.public_port", fmt.Errorf("%d is not a port", c.HTTPSRedirect.PublicPort))
+	}
+
+	if c.DebugAddress != "" {
+		if !server.IsTCPAddress(c.DebugAddress) {
+			invalid("debug_address", errors.New("must be a host:port"))
+		} else if err := validateAddress(c.DebugAddress); err != nil {
+			invalid("debug_address", err)
+		}
+	}
+	if c.MetricsAddress != "" {
+		if err := validateAddress(c.MetricsAddress); err != nil {
+			invalid("metrics_address", err)
+		}
+	}
+
+	if _, err := lager.LogLevelFromString(c.LogLevel); err != nil {
+		invalid("log_level", err)
+	}
+
+	switch c.Registration.Backend {
+	case "", registration.NoneBackend, registration.ConsulBackend:
+	case registration.FileBackend:
+		if c.Registration.File.Path == "" {
+			invalid("registration.file.path", errNotSet)
+		}
+	case registration.HTTPBackend:
+		if _, err := url.ParseRequestURI(c.Registration.HTTP.URL); err != nil {
+			invalid("registration.http.url", err)
+		}
+	default:
+		invalid("registration.backend", fmt.Errorf("unknown backend %q", c.Registration.Backend))
+	}
+
+	return problems
+}
+
+func (c FileServerConfig) validateListeners(invalid func(setting string, err error)) {
+	listeners := map[string]server.ListenerConfig{}
+	for _, listener := range c.Listeners {
+		listeners[listener.Name] = listener
+	}
+
+	names := map[string]bool{}
+	for i, listener := range c.Listeners {
+		setting := func(name string) string {
+			return fmt.Sprintf("listeners[%d].%s", i, name)
+		}
+
+		if listener.Name == "" {
+			invalid(setting("name"), errNotSet)
+		} else if names[listener.Name] {
+			invalid(setting("name"), fmt.Errorf("%q is the name of another listener", listener.Name))
+		}
+		names[listener.Name] = true
+
+		if err := validateAddress(listener.Address); err != nil {
+			invalid(setting("address"), err)
+		}
+
+		if listener.TLSEnabled() {
+			if listener.CertFile == "" || listener.KeyFile == "" {
+				invalid(setting("cert_file"), errors.New("cert_file and key_file must both be set"))
+			} else if _, err := tls.LoadX509KeyPair(listener.CertFile, listener.KeyFile); err != nil {
+				invalid(setting("cert_file"), err)
+			}
+		}
+		if listener.ClientCAFile != "" {
+			if !listener.TLSEnabled() {
+				invalid(setting("client_ca_file"), errors.New("requires cert_file and key_file"))
+			} else if err := validateCAFile(listener.ClientCAFile); err != nil {
+				invalid(setting("client_ca_file"), err)
+			}
+		}
+
+		for _, group := range listener.Routes {
+			if _, ok := fileserver.RouteGroups[group]; !ok {
+				invalid(setting("routes"), fmt.Errorf("unknown route group %q", group))
+			}
+		}
+
+		if listener.RedirectTo != "" {
+			target, ok := listeners[listener.RedirectTo]
+			switch {
+			case len(listener.Routes) > 0:
+				invalid(setting("redirect_to"), errors.New("cannot be combined with routes"))
+			case !ok || !target.TLSEnabled():
+				invalid(setting("redirect_to"), fmt.Errorf("%q is not the name of a TLS listener", listener.RedirectTo))
+			case c.HTTPSRedirect.PublicPort == 0 && !server.IsTCPAddress(target.Address):
+				invalid("https_redirect.public_port", fmt.Errorf("must be set to redirect to %s", target.Address))
+			}
+		}
+	}
+}
+
+func validateAddress(addr string) error {
+	if addr == "" {
+		return errNotSet
+	}
+	return server.ValidateAddress(addr)
+}
+
+func validateCAFile(caFile string) error {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return errors.New("no certificates found")
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/fileserver/cmd/file-server/config"
+	"code.cloudfoundry.org/fileserver/handlers/redirect"
+	"code.cloudfoundry.org/fileserver/registration"
+	"code.cloudfoundry.org/fileserver/server"
+	"code.cloudfoundry.org/fileserver/storage"
+	"code.cloudfoundry.org/lager/lagerflags"
+	"code.cloudfoundry.org/tlsconfig/certtest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validate", func() {
+	var (
+		tmpDir string
+		cfg    config.FileServerConfig
+	)
+
+	writeIdentity := func(ca *certtest.Authority, name string) (string, string) {
+		cert, err := ca.BuildSignedCertificate(name)
+		Expect(err).NotTo(HaveOccurred())
+		certPEM, keyPEM, err := cert.CertificatePEMAndPrivateKey()
+		Expect(err).NotTo(HaveOccurred())
+
+		certFile := filepath.Join(tmpDir, name+".crt")
+		keyFile := filepath.Join(tmpDir, name+".key")
+		Expect(ioutil.WriteFile(certFile, certPEM, 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(keyFile, keyPEM, 0600)).To(Succeed())
+		return certFile, keyFile
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "file-server-config")
+		Expect(err).NotTo(HaveOccurred())
+
+		cfg = config.FileServerConfig{
+			ServerAddress:   "localhost:8080",
+			StaticDirectory: tmpDir,
+			LagerConfig:     lagerflags.DefaultLagerConfig(),
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("accepts a valid configuration", func() {
+		Expect(cfg.Validate()).To(BeEmpty())
+	})
+
+	It("reports every problem it finds", func() {
+		cfg.StaticDirectory = filepath.Join(tmpDir, "missing")
+		cfg.ServerAddress = "localhost"
+		cfg.MetricsAddress = "localhost:http-ish"
+		cfg.LogLevel = "verbose"
+		cfg.Registration.Backend = "zookeeper"
+
+		problems := cfg.Validate()
+		Expect(problems).To(HaveLen(5))
+		Expect(problems[0]).To(MatchError(ContainSubstring("static_directory: ")))
+		Expect(problems[1]).To(MatchError(ContainSubstring("server_address: ")))
+		Expect(problems[2]).To(MatchError(ContainSubstring("metrics_address: ")))
+		Expect(problems[3]).To(MatchError(ContainSubstring("log_level: ")))
+		Expect(problems[4]).To(MatchError(`registration.backend: unknown backend "zookeeper"`))
+	})
+
+	It("accepts Unix domain sockets and sockets passed by systemd", func() {
+		cfg.ServerAddress = "unix:///var/vcap/sys/run/file-server.sock"
+		cfg.MetricsAddress = "fd://metrics"
+		Expect(cfg.Validate()).To(BeEmpty())
+
+		cfg.ServerAddress = "unix://"
+		Expect(cfg.Validate()).To(ConsistOf(MatchError("server_address: missing socket path")))
+	})
+
+	It("requires the settings of the registration backend", func() {
+		cfg.Registration.Backend = registration.FileBackend
+		Expect(cfg.Validate()).To(ConsistOf(MatchError("registration.file.path: must be set")))
+	})
+
+	Context("when the files are stored in S3", func() {
+		BeforeEach(func() {
+			cfg.StaticDirectory = ""
+			cfg.Storage = storage.Config{
+				Backend: storage.S3Backend,
+				S3: storage.S3Config{
+					Endpoint:        "https://blobstore.service.cf.internal",
+					Bucket:          "cc-packages",
+					AccessKeyID:     "file-server",
+					SecretAccessKey: "secret",
+				},
+			}
+		})
+
+		It("does not require a static directory", func() {
+			Expect(cfg.Validate()).To(BeEmpty())
+		})
+
+		It("reports every problem with the S3 settings", func() {
+			cfg.Storage.S3.Endpoint = "blobstore.service.cf.internal"
+			cfg.Storage.S3.Bucket = ""
+			cfg.Storage.S3.SecretAccessKey = ""
+			cfg.Health.MinFreeDiskBytes = 1024
+
+			Expect(cfg.Validate()).To(ConsistOf(
+				MatchError(`storage.s3.endpoint: "blobstore.service.cf.internal" is not an http or https URL`),
+				MatchError("storage.s3.bucket: must be set"),
+				MatchError("storage.s3.access_key_id: access_key_id and secret_access_key must both be set"),
+				MatchError("health.min_free_disk_bytes: requires the local storage backend"),
+			))
+		})
+
+		It("reports an unknown backend", func() {
+			cfg.Storage.Backend = "ftp"
+			Expect(cfg.Validate()).To(ConsistOf(MatchError(`storage.backend: unknown backend "ftp"`)))
+		})
+	})
+
+	Context("when HTTPS is enabled", func() {
+		var ca *certtest.Authority
+
+		BeforeEach(func() {
+			var err error
+			ca, err = certtest.BuildCA("test-ca")
+			Expect(err).NotTo(HaveOccurred())
+
+			cfg.HTTPSServerEnabled = true
+			cfg.HTTPSListenAddr = "localhost:8443"
+			cfg.CertFile, cfg.KeyFile = writeIdentity(ca, "server")
+		})
+
+		It("accepts a matching certificate and key", func() {
+			Expect(cfg.Validate()).To(BeEmpty())
+		})
+
+		It("reports a key that does not match the certificate", func() {
+			_, cfg.KeyFile = writeIdentity(ca, "other")
+			Expect(cfg.Validate()).To(ConsistOf(MatchError(ContainSubstring("cert_file: "))))
+		})
+
+		It("reports a missing listen address", func() {
+			cfg.HTTPSListenAddr = ""
+			Expect(cfg.Validate()).To(ConsistOf(MatchError("https_listen_addr: must be set")))
+		})
+
+		It("reports an unknown use of the plain listener", func() {
+			cfg.HTTPSRedirect.PlainListener = "proxy"
+			Expect(cfg.Validate()).To(ConsistOf(MatchError(`https_redirect.plain_listener: unknown mode "proxy"`)))
+		})
+
+		It("does not require a server address when the plain listener is disabled", func() {
+			cfg.ServerAddress = ""
+			cfg.HTTPSRedirect.PlainListener = redirect.ModeDisabled
+			Expect(cfg.Validate()).To(BeEmpty())
+		})
+
+		It("reports a client CA file without certificates", func() {
+			cfg.ClientCAFile = cfg.KeyFile
+			Expect(cfg.Validate()).To(ConsistOf(MatchError("client_ca_file: no certificates found")))
+		})
+	})
+
+	Context("when listeners are configured", func() {
+		var certFile, keyFile string
+
+		BeforeEach(func() {
+			ca, err := certtest.BuildCA("test-ca")
+			Expect(err).NotTo(HaveOccurred())
+			certFile, keyFile = writeIdentity(ca, "server")
+
+			cfg.ServerAddress = ""
+			cfg.Listeners = []server.ListenerConfig{
+				{Name: "internal", Address: "10.0.0.1:8080", Routes: []string{"static"}},
+				{Name: "management", Address: "10.1.0.1:8443", CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile, Routes: []string{"health"}},
+				{Name: "redirect", Address: ":8081", RedirectTo: "management"},
+			}
+		})
+
+		It("accepts them", func() {
+			Expect(cfg.Validate()).To(BeEmpty())
+		})
+
+		It("reports every problem with them", func() {
+			cfg.ServerAddress = "localhost:8080"
+			cfg.Listeners[0].Routes = []string{"admin"}
+			cfg.Listeners[1].Name = "internal"
+			cfg.Listeners[2].RedirectTo = "public"
+
+			Expect(cfg.Validate()).To(ConsistOf(
+				MatchError("listeners: cannot be combined with server_address or https_server_enabled"),
+				MatchError(`listeners[0].routes: unknown route group "admin"`),
+				MatchError(`listeners[1].name: "internal" is the name of another listener`),
+				MatchError(`listeners[2].redirect_to: "public" is not the name of a TLS listener`),
+			))
+		})
+
+		It("reports a client CA file on a listener without TLS", func() {
+			cfg.Listeners[0].ClientCAFile = certFile
+			Expect(cfg.Validate()).To(ConsistOf(MatchError("listeners[0].client_ca_file: requires cert_file and key_file")))
+		})
+	})
+})
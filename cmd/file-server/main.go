@@ -2,40 +2,72 @@ package main
 
 import (
 	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
-	"strings"
+	"strconv"
+	"syscall"
+	"time"
 
 	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/consuladapter"
 	"code.cloudfoundry.org/debugserver"
 	loggingclient "code.cloudfoundry.org/diego-logging-client"
+	"code.cloudfoundry.org/fileserver"
 	"code.cloudfoundry.org/fileserver/cmd/file-server/config"
 	"code.cloudfoundry.org/fileserver/handlers"
+	"code.cloudfoundry.org/fileserver/handlers/accesslog"
+	"code.cloudfoundry.org/fileserver/handlers/admission"
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/fileserver/handlers/inflight"
+	"code.cloudfoundry.org/fileserver/handlers/metrics"
+	"code.cloudfoundry.org/fileserver/handlers/otlp"
+	"code.cloudfoundry.org/fileserver/handlers/prometheus"
+	"code.cloudfoundry.org/fileserver/handlers/ratelimit"
+	"code.cloudfoundry.org/fileserver/handlers/redirect"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/fileserver/handlers/throttle"
+	"code.cloudfoundry.org/fileserver/handlers/watchdog"
+	"code.cloudfoundry.org/fileserver/registration"
+	"code.cloudfoundry.org/fileserver/server"
+	"code.cloudfoundry.org/fileserver/storage"
+	"code.cloudfoundry.org/fileserver/tlsreload"
 	"code.cloudfoundry.org/go-loggregator/v8/runtimeemitter"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagerflags"
 	"code.cloudfoundry.org/locket"
-	"code.cloudfoundry.org/tlsconfig"
-	"github.com/hashicorp/consul/api"
 	"github.com/tedsuo/ifrit"
 	"github.com/tedsuo/ifrit/grouper"
-	"github.com/tedsuo/ifrit/http_server"
 	"github.com/tedsuo/ifrit/sigmon"
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
 var configFilePath = flag.String(
 	"config",
 	"",
-	"The path to the JSON configuration file.",
+	"The path to the JSON configuration file. Its settings can be overridden with "+config.EnvPrefix+" environment variables.",
+)
+
+var validateConfig = flag.Bool(
+	"validate-config",
+	false,
+	"Check the configuration file, print every problem found and exit non-zero if there are any.",
 )
 
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	flag.Parse()
+	if *validateConfig {
+		os.Exit(validateConfigFile(*configFilePath))
+	}
+
 	cfg, err := config.NewFileServerConfig(*configFilePath)
 	if err != nil {
 		logger, _ := lagerflags.NewFromConfig("file-server", lagerflags.DefaultLagerConfig())
@@ -43,39 +75,154 @@ func main() {
 	}
 
 	logger, reconfigurableSink := lagerflags.NewFromConfig("file-server", cfg.LagerConfig)
+	logger.Info("effective-config", lager.Data{"config": cfg.Redacted()})
+	if unmatched := cfg.UnmatchedEnvironment(); len(unmatched) > 0 {
+		logger.Info("ignoring-unmatched-environment", lager.Data{"variables": unmatched})
+	}
 
-	_, err = initializeMetron(logger, cfg)
+	metronClient, err := initializeMetron(logger, cfg)
 	if err != nil {
 		logger.Error("failed-to-initialize-metron-client", err)
 		os.Exit(1)
 	}
 
-	consulClient, err := consuladapter.NewClientFromUrl(cfg.ConsulCluster)
-	if err != nil {
-		logger.Fatal("new-client-failed", err)
-	}
-
-	var tlsConfig *tls.Config
 	if cfg.HTTPSServerEnabled {
 		if len(cfg.HTTPSListenAddr) == 0 {
 			logger.Fatal("invalid-https-configuration", nil)
 		}
-		var err error
-		tlsConfig, err = tlsconfig.Build(
-			tlsconfig.WithInternalServiceDefaults(),
-			tlsconfig.WithIdentityFromFile(cfg.CertFile, cfg.KeyFile),
-		).Server()
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			logger.Fatal("failed-to-create-tls-config", errors.New("cert_file and key_file must be set"))
+		}
+	}
+	if problems := cfg.Validate(); len(problems) > 0 {
+		logger.Fatal("invalid-config", nil, lager.Data{"problems": problemMessages(problems)})
+	}
+
+	listeners := cfg.ListenerConfigs()
+	tlsConfigs := map[string]*tls.Config{}
+	tlsReloaders := map[string]*tlsreload.Reloader{}
+	for _, listener := range listeners {
+		if !listener.TLSEnabled() {
+			continue
+		}
+		tlsReloader, err := tlsreload.New(logger.WithData(lager.Data{"listener": listener.Name}), clock.NewClock(), tlsreload.DefaultPollInterval, listener.CertFile, listener.KeyFile, listener.ClientCAFile)
 		if err != nil {
-			logger.Fatal("failed-to-create-tls-config", err)
+			logger.Fatal("failed-to-create-tls-config", err, lager.Data{"listener": listener.Name})
 		}
+		tlsConfigs[listener.Name] = tlsReloader.TLSConfig()
+		tlsReloaders[listener.Name] = tlsReloader
+	}
+	rateLimiter := ratelimit.New(logger, clock.NewClock(), cfg.RateLimit)
+	admissionController := admission.New(logger, clock.NewClock(), cfg.Admission)
+	throttler := throttle.New(clock.NewClock(), cfg.Bandwidth)
+	transferWatchdog := watchdog.New(logger, clock.NewClock(), cfg.MinTransferRate)
+	inFlight := inflight.New(logger, clock.NewClock())
+	metricsNotifier := metrics.New(logger, clock.NewClock(), time.Duration(cfg.ReportInterval), metronClient, inFlight.Len)
+
+	observers := []static.Observer{metricsNotifier}
+	reloaders := []func() error{initializeConfigReloader(logger, *configFilePath, cfg, reconfigurableSink, rateLimiter, admissionController, throttler, transferWatchdog)}
+	for _, tlsReloader := range tlsReloaders {
+		reloaders = append(reloaders, tlsReloader.Reload)
 	}
+	if cfg.AccessLog.Path != "" {
+		accessLog, err := accesslog.New(logger, cfg.AccessLog)
+		if err != nil {
+			logger.Fatal("failed-to-open-access-log", err)
+		}
+		defer accessLog.Close()
+		observers = append(observers, accessLog)
+		reloaders = append(reloaders, accessLog.Reopen)
+	}
+
+	var spanExporter *otlp.Exporter
+	if cfg.Tracing.Endpoint != "" {
+		spanExporter = otlp.New(logger, clock.NewClock(), cfg.Tracing)
+		observers = append(observers, spanExporter)
+	}
+
+	digestCache := static.NewDigestCache()
+	var metricsCollector *prometheus.Collector
+	if cfg.MetricsAddress != "" {
+		metricsCollector = prometheus.New(digestCache.Len)
+		observers = append(observers, metricsCollector)
+	}
+	registerMetrics(metricsNotifier, metricsCollector, rateLimiter, admissionController, transferWatchdog)
+
+	listenerOptions := func(listener string) []server.Option {
+		options := []server.Option{
+			server.WithConnContext(throttle.ConnContext),
+			server.WithDrainTimeoutHook(func() {
+				logger.Info("drain-timed-out", lager.Data{"listener": listener, "in-flight": inFlight.Len()})
+				inFlight.LogInFlight()
+			}),
+		}
+		if metricsCollector != nil {
+			options = append(options, server.WithConnState(metricsCollector.ConnState(listener)))
+		}
+		return options
+	}
+
+	store := initializeStorage(logger, cfg)
+	listenersUp := health.NewGate("listeners")
+	serving := health.NewGate("serving")
+	healthChecker := health.NewChecker(
+		health.Cached(health.StorageReadable(store), clock.NewClock(), health.DefaultCacheTTL),
+		listenersUp.Check(),
+		serving.Check(),
+	)
+	if _, local := store.(*storage.Local); local && cfg.Health.MinFreeDiskBytes > 0 {
+		healthChecker.Add(health.Cached(health.FreeDiskSpace(cfg.StaticDirectory, cfg.Health.MinFreeDiskBytes), clock.NewClock(), health.DefaultCacheTTL))
+	}
+	for _, listener := range listeners {
+		tlsReloader, ok := tlsReloaders[listener.Name]
+		if !ok {
+			continue
+		}
+		check := health.CertificateNotExpired(tlsReloader.Certificate, clock.NewClock())
+		if len(tlsReloaders) > 1 {
+			check.Name += "-" + listener.Name
+		}
+		healthChecker.Add(check)
+	}
+
 	members := grouper.Members{
-		{"file server", initializeServer(logger, cfg.StaticDirectory, cfg.ServerAddress, cfg.HTTPSListenAddr, tlsConfig)},
+		{"file server", initializeGatedRunner(listenersUp, initializeServer(logger, store, listeners, tlsConfigs, cfg.HTTPSRedirect, cfg.ServerConfig, listenerOptions, digestCache, healthChecker, observers, inFlight.Wrap, rateLimiter.Wrap, admissionController.Wrap, throttler.Wrap, transferWatchdog.Wrap))},
+		{"reloader", initializeReloader(logger, reloaders...)},
+		{"metrics-notifier", metricsNotifier},
+	}
+
+	if cfg.WarmUpDigestCache {
+		warmUp := health.NewGate("digest-warm-up")
+		healthChecker.Add(warmUp.Check())
+		members = append(members, grouper.Member{"digest-warm-up", initializeWarmUp(logger, digestCache, store, warmUp)})
 	}
 
-	if cfg.EnableConsulServiceRegistration {
-		registrationRunner := initializeRegistrationRunner(logger, consulClient, cfg.ServerAddress, clock.NewClock())
-		members = append(members, grouper.Member{"registration-runner", registrationRunner})
+	for _, listener := range listeners {
+		if tlsReloader, ok := tlsReloaders[listener.Name]; ok {
+			members = append(members, grouper.Member{"tls-reloader-" + listener.Name, tlsReloader})
+		}
+	}
+
+	if spanExporter != nil {
+		// Started before and stopped after the server, so that the spans of
+		// the last requests are still sent.
+		members = append(grouper.Members{{"otlp-exporter", spanExporter}}, members...)
+	}
+
+	// On shutdown, the members stop in reverse order: the server is
+	// deregistered and reports itself not ready before it drains its
+	// connections.
+	members = append(members,
+		grouper.Member{"drainer", initializeDrainer(logger, serving)},
+		grouper.Member{"registration-runner", initializeRegistrationRunner(logger, cfg, healthChecker, clock.NewClock())},
+	)
+
+	if metricsCollector != nil {
+		metricsHandler := http.NewServeMux()
+		metricsHandler.Handle("/metrics", metricsCollector)
+		members = append(grouper.Members{
+			{"metrics-server", server.New(cfg.MetricsAddress, metricsHandler, server.DefaultServerConfig())},
+		}, members...)
 	}
 
 	if dbgAddr := debugserver.DebugAddress(flag.CommandLine); dbgAddr != "" {
@@ -112,36 +259,337 @@ func initializeMetron(logger lager.Logger, config config.FileServerConfig) (logg
 	return client, nil
 }
 
-func initializeServer(logger lager.Logger, staticDirectory, serverAddress, serverAddressTls string, tlsConfig *tls.Config) ifrit.Runner {
-	if staticDirectory == "" {
-		logger.Fatal("static-directory-missing", nil)
+// initializeStorage returns the storage holding the served files.
+func initializeStorage(logger lager.Logger, cfg config.FileServerConfig) storage.Storage {
+	var store storage.Storage
+	switch cfg.Storage.Backend {
+	case "", storage.LocalBackend:
+		if cfg.StaticDirectory == "" {
+			logger.Fatal("static-directory-missing", nil)
+		}
+		store = storage.NewLocal(cfg.StaticDirectory)
+	case storage.S3Backend:
+		s3, err := storage.NewS3(cfg.Storage.S3, clock.NewClock())
+		if err != nil {
+			logger.Fatal("invalid-s3-storage", err)
+		}
+		store = s3
+	default:
+		logger.Fatal("invalid-storage-backend", nil, lager.Data{"backend": cfg.Storage.Backend})
 	}
+	return store
+}
 
-	fileServerHandler, err := handlers.New(staticDirectory, logger)
-	if err != nil {
-		logger.Error("router-building-failed", err)
-		os.Exit(1)
+func initializeServer(logger lager.Logger, store storage.Storage, listeners []server.ListenerConfig, tlsConfigs map[string]*tls.Config, redirectConfig redirect.Config, serverConfig server.ServerConfig, listenerOptions func(listener string) []server.Option, digestCache *static.DigestCache, healthChecker *health.Checker, observers []static.Observer, middleware ...func(http.Handler) http.Handler) ifrit.Runner {
+	addresses := map[string]string{}
+	for _, listener := range listeners {
+		addresses[listener.Name] = listener.Address
 	}
 
-	if tlsConfig != nil {
-		return grouper.NewParallel(os.Interrupt, grouper.Members{
-			{Name: "tls-server", Runner: http_server.NewTLSServer(serverAddressTls, fileServerHandler, tlsConfig)},
-			{
-				Name: "redirect-server",
-				Runner: http_server.New(serverAddress, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					httpHostPort := strings.Split(r.Host, ":")
-					tlsHostPort := strings.Split(serverAddressTls, ":")
-					httpsHost := httpHostPort[0] + ":" + tlsHostPort[1]
-					http.Redirect(w, r, "https://"+httpsHost+r.URL.String(), http.StatusMovedPermanently)
-				})),
-			},
-		})
+	members := grouper.Members{}
+	for _, listener := range listeners {
+		var handler http.Handler
+		if listener.RedirectTo != "" {
+			redirector, err := redirect.New(redirectConfig, addresses[listener.RedirectTo])
+			if err != nil {
+				logger.Fatal("invalid-https-configuration", err, lager.Data{"listener": listener.Name})
+			}
+			handler = redirector
+		} else {
+			var err error
+			handler, err = handlers.New(listener.Routes, store, digestCache, healthChecker, logger, observers, middleware...)
+			if err != nil {
+				logger.Error("router-building-failed", err, lager.Data{"listener": listener.Name})
+				os.Exit(1)
+			}
+		}
+
+		tlsConfig := tlsConfigs[listener.Name]
+		if tlsConfig != nil {
+			handler = redirect.NewHSTS(redirectConfig).Wrap(handler)
+		}
+		members = append(members, grouper.Member{Name: listener.Name, Runner: server.NewTLS(listener.Address, handler, tlsConfig, serverConfig, listenerOptions(listener.Name)...)})
 	}
 
-	return http_server.New(serverAddress, fileServerHandler)
+	return grouper.NewParallel(os.Interrupt, members)
 }
 
-func initializeRegistrationRunner(logger lager.Logger, consulClient consuladapter.Client, listenAddress string, clock clock.Clock) ifrit.Runner {
+// problemMessages returns the messages of the problems found by Validate, to
+// be logged.
+func problemMessages(problems []error) []string {
+	messages := make([]string, len(problems))
+	for i, problem := range problems {
+		messages[i] = problem.Error()
+	}
+	return messages
+}
+
+// validateConfigFile prints every problem with the configuration file to
+// stderr and returns the exit status for -validate-config.
+func validateConfigFile(configPath string) int {
+	var problems []error
+	cfg, err := config.NewFileServerConfig(configPath)
+	var unknown *config.UnknownSettingsError
+	if errors.As(err, &unknown) {
+		for _, setting := range unknown.Settings {
+			problems = append(problems, fmt.Errorf("%s: unknown setting", setting))
+		}
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", configPath, err)
+		return 1
+	}
+
+	for _, name := range cfg.UnmatchedEnvironment() {
+		fmt.Fprintf(os.Stderr, "%s: %s does not override any setting and is ignored\n", configPath, name)
+	}
+
+	problems = append(problems, cfg.Validate()...)
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", configPath, problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+
+	fmt.Printf("%s: ok\n", configPath)
+	return 0
+}
+
+// initializeReloader runs every reloader whenever the process receives
+// SIGHUP. It is a member of the group rather than a signal handled by sigmon,
+// which would forward SIGHUP to every member as a request to stop.
+func initializeReloader(logger lager.Logger, reloaders ...func() error) ifrit.Runner {
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		logger := logger.Session("reloader")
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
+		close(ready)
+
+		for {
+			select {
+			case <-hup:
+				logger.Info("reloading")
+				for _, reload := range reloaders {
+					if err := reload(); err != nil {
+						logger.Error("failed-to-reload", err)
+					}
+				}
+				logger.Info("reloaded")
+			case <-signals:
+				return nil
+			}
+		}
+	})
+}
+
+// registerMetrics reports the counters kept by the middleware through the
+// notifier and, when it is enabled, the Prometheus collector.
+func registerMetrics(
+	notifier *metrics.Notifier,
+	collector *prometheus.Collector,
+	rateLimiter *ratelimit.Limiter,
+	admissionController *admission.Controller,
+	transferWatchdog *watchdog.Watchdog,
+) {
+	rateLimitedReads := func() uint64 {
+		reads, _ := rateLimiter.Rejections()
+		return reads
+	}
+	rateLimitedWrites := func() uint64 {
+		_, writes := rateLimiter.Rejections()
+		return writes
+	}
+
+	notifier.ReportCounter(metrics.RateLimitedReadsMetric, rateLimitedReads)
+	notifier.ReportCounter(metrics.RateLimitedWritesMetric, rateLimitedWrites)
+	notifier.ReportGauge(metrics.AdmissionInFlightMetric, admissionController.InFlight)
+	notifier.ReportGauge(metrics.AdmissionQueuedMetric, admissionController.Queued)
+	notifier.ReportCounter(metrics.AdmissionRejectionsMetric, admissionController.Rejections)
+	notifier.ReportCounter(metrics.SlowTransferEvictionsMetric, transferWatchdog.Evictions)
+
+	if collector == nil {
+		return
+	}
+	collector.Counter("fileserver_rate_limited_requests_total", `class="read"`, "Requests turned away by the rate limiter, by class.", rateLimitedReads)
+	collector.Counter("fileserver_rate_limited_requests_total", `class="write"`, "", rateLimitedWrites)
+	collector.Gauge("fileserver_admission_in_flight_requests", "", "Requests admitted and being served.", admissionController.InFlight)
+	collector.Gauge("fileserver_admission_queued_requests", "", "Requests waiting for an admission slot.", admissionController.Queued)
+	collector.Counter("fileserver_admission_rejected_requests_total", "", "Requests shed by admission control.", admissionController.Rejections)
+	collector.Counter("fileserver_slow_transfer_evictions_total", "", "Responses aborted for being sent below the minimum transfer rate.", transferWatchdog.Evictions)
+}
+
+// initializeConfigReloader reads the configuration file again and applies the
+// settings that can change while the server runs. Other changes are logged
+// and left for the next restart; a file that cannot be parsed or does not
+// pass Validate leaves the current settings in place.
+func initializeConfigReloader(
+	logger lager.Logger,
+	configPath string,
+	current config.FileServerConfig,
+	sink *lager.ReconfigurableSink,
+	rateLimiter *ratelimit.Limiter,
+	admissionController *admission.Controller,
+	throttler *throttle.Throttler,
+	transferWatchdog *watchdog.Watchdog,
+) func() error {
+	return func() error {
+		logger := logger.Session("config-reload")
+
+		next, err := config.NewFileServerConfig(configPath)
+		if err != nil {
+			return err
+		}
+		if problems := next.Validate(); len(problems) > 0 {
+			logger.Error("invalid-config", nil, lager.Data{"problems": problemMessages(problems)})
+			return errors.New("invalid config, keeping the current settings")
+		}
+		level, err := lager.LogLevelFromString(next.LogLevel)
+		if err != nil {
+			return err
+		}
+
+		if settings := current.RestartRequired(next); len(settings) > 0 {
+			logger.Info("settings-require-restart", lager.Data{"settings": settings})
+		}
+
+		sink.SetMinLevel(level)
+		rateLimiter.Update(next.RateLimit)
+		if next.Admission != current.Admission {
+			admissionController.Update(next.Admission)
+		}
+		throttler.Update(next.Bandwidth)
+		transferWatchdog.Update(next.MinTransferRate)
+		current.LogLevel = next.LogLevel
+		current.RateLimit = next.RateLimit
+		current.Admission = next.Admission
+		current.Bandwidth = next.Bandwidth
+		current.MinTransferRate = next.MinTransferRate
+
+		logger.Info("reloaded", lager.Data{"log-level": next.LogLevel})
+		return nil
+	}
+}
+
+// initializeWarmUp computes the digest of every served file in the background
+// and opens the gate once it is done, whether or not it succeeded: a file that
+// could not be read is logged and skipped, and its digest is computed by the
+// first request for it, as it would have been without the warm-up.
+func initializeWarmUp(logger lager.Logger, digestCache *static.DigestCache, store storage.Storage, gate *health.Gate) ifrit.Runner {
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		logger := logger.Session("digest-warm-up")
+
+		go func() {
+			logger.Info("starting")
+			if err := digestCache.Warm(logger, store); err != nil {
+				logger.Error("failed-to-warm-up", err)
+			}
+			gate.Open()
+			logger.Info("finished", lager.Data{"digests": digestCache.Len()})
+		}()
+
+		close(ready)
+
+		<-signals
+		return nil
+	})
+}
+
+// initializeGatedRunner opens gate once runner is ready, and closes it again
+// when runner exits.
+func initializeGatedRunner(gate *health.Gate, runner ifrit.Runner) ifrit.Runner {
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		process := ifrit.Background(runner)
+		defer gate.Close("stopped")
+
+		processReady := process.Ready()
+		exited := process.Wait()
+		for {
+			select {
+			case <-processReady:
+				gate.Open()
+				close(ready)
+				processReady = nil
+			case sig := <-signals:
+				process.Signal(sig)
+			case err := <-exited:
+				return err
+			}
+		}
+	})
+}
+
+// initializeDrainer opens the serving gate once started, and closes it when
+// signaled.
+func initializeDrainer(logger lager.Logger, serving *health.Gate) ifrit.Runner {
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		serving.Open()
+		close(ready)
+
+		<-signals
+		logger.Info("draining")
+		serving.Close("shutting down")
+		return nil
+	})
+}
+
+// initializeRegistrationRunner announces the port clients should use: the one
+// of the first listener that serves files, which is the HTTPS one when it is
+// enabled through https_server_enabled. The backend defaults to Consul when
+// the older enable_consul_service_registration flag is set, and to none
+// otherwise.
+func initializeRegistrationRunner(logger lager.Logger, cfg config.FileServerConfig, healthChecker *health.Checker, clock clock.Clock) ifrit.Runner {
+	var port int
+	meta := map[string]string{"version": version}
+	for _, listener := range cfg.ListenerConfigs() {
+		if !listener.Serves(fileserver.StaticRouteGroup) {
+			continue
+		}
+		port = lookupPort(logger, listener.Address)
+		if listener.TLSEnabled() && port != 0 {
+			meta["tls_port"] = strconv.Itoa(port)
+		}
+		break
+	}
+	instance := cfg.Registration.Instance(port, meta)
+
+	backend := cfg.Registration.Backend
+	if backend == "" {
+		backend = registration.NoneBackend
+		if cfg.EnableConsulServiceRegistration {
+			backend = registration.ConsulBackend
+		}
+	}
+
+	var registrar registration.Registrar
+	switch backend {
+	case registration.NoneBackend:
+		registrar = registration.None{}
+	case registration.ConsulBackend:
+		consulClient, err := consuladapter.NewClientFromUrl(cfg.ConsulCluster)
+		if err != nil {
+			logger.Fatal("new-client-failed", err)
+		}
+		registrar = registration.NewConsulRegistrar(consulClient, instance)
+	case registration.FileBackend:
+		registrar = registration.NewFileRegistrar(cfg.Registration.File, instance)
+	case registration.HTTPBackend:
+		registrar = registration.NewHTTPRegistrar(cfg.Registration.HTTP, instance)
+	default:
+		logger.Fatal("invalid-registration-backend", nil, lager.Data{"backend": backend})
+	}
+
+	return registration.NewRunner(logger.WithData(lager.Data{"backend": backend}), registrar, healthChecker, clock, instance.TTL, locket.RetryInterval)
+}
+
+// lookupPort returns the port of a host:port listen address, and 0 for the
+// other kinds, whose port is not known.
+func lookupPort(logger lager.Logger, listenAddress string) int {
+	if !server.IsTCPAddress(listenAddress) {
+		return 0
+	}
 	_, portString, err := net.SplitHostPort(listenAddress)
 	if err != nil {
 		logger.Fatal("failed-invalid-listen-address", err)
@@ -150,14 +598,5 @@ func initializeRegistrationRunner(logger lager.Logger, consulClient consuladapte
 	if err != nil {
 		logger.Fatal("failed-invalid-listen-port", err)
 	}
-
-	registration := &api.AgentServiceRegistration{
-		Name: "file-server",
-		Port: portNum,
-		Check: &api.AgentServiceCheck{
-			TTL: "20s",
-		},
-	}
-
-	return locket.NewRegistrationRunner(logger, registration, consulClient, locket.RetryInterval, clock)
+	return portNum
 }
@@ -1,18 +1,35 @@
 package main_test
 
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"syscall"
+	"time"
 
+	"code.cloudfoundry.org/durationjson"
 	"code.cloudfoundry.org/fileserver/cmd/file-server/config"
+	"code.cloudfoundry.org/fileserver/handlers/accesslog"
+	"code.cloudfoundry.org/fileserver/handlers/admission"
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/fileserver/handlers/otlp"
+	"code.cloudfoundry.org/fileserver/handlers/ratelimit"
+	"code.cloudfoundry.org/fileserver/handlers/redirect"
+	"code.cloudfoundry.org/fileserver/handlers/throttle"
+	"code.cloudfoundry.org/fileserver/handlers/watchdog"
+	"code.cloudfoundry.org/fileserver/registration"
+	"code.cloudfoundry.org/fileserver/server"
 	"code.cloudfoundry.org/lager/lagerflags"
 	"code.cloudfoundry.org/tlsconfig"
 	"code.cloudfoundry.org/tlsconfig/certtest"
@@ -21,6 +38,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
+	"golang.org/x/net/http2"
 )
 
 var _ = Describe("File server", func() {
@@ -31,11 +49,14 @@ var _ = Describe("File server", func() {
 		err             error
 		configPath      string
 		cfg             config.FileServerConfig
+		env             []string
 	)
 
 	start := func(extras ...string) *gexec.Session {
 		args := []string{"-config", configPath}
-		session, err = gexec.Start(exec.Command(fileServerBinary, args...), GinkgoWriter, GinkgoWriter)
+		cmd := exec.Command(fileServerBinary, args...)
+		cmd.Env = append(os.Environ(), env...)
+		session, err = gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
 		Expect(err).NotTo(HaveOccurred())
 
 		Eventually(session).Should(gbytes.Say("file-server.ready"))
@@ -43,6 +64,10 @@ var _ = Describe("File server", func() {
 		return session
 	}
 
+	BeforeEach(func() {
+		env = nil
+	})
+
 	AfterEach(func() {
 		session.Kill().Wait()
 		os.RemoveAll(servedDirectory)
@@ -58,6 +83,64 @@ var _ = Describe("File server", func() {
 		})
 	})
 
+	Context("when started with an invalid config", func() {
+		It("logs every problem and fails", func() {
+			configFile, err := ioutil.TempFile("", "file_server-test-config")
+			Expect(err).NotTo(HaveOccurred())
+			configPath = configFile.Name()
+			_, err = configFile.WriteString(`{"server_address": "localhost", "static_directory": "/nonexistent", "log_level": "info"}`)
+			Expect(err).NotTo(HaveOccurred())
+			configFile.Close()
+
+			session, err = gexec.Start(exec.Command(fileServerBinary, "-config", configPath), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(2))
+			Expect(session.Out).To(gbytes.Say("invalid-config"))
+			Expect(session.Out.Contents()).To(ContainSubstring("static_directory: "))
+			Expect(session.Out.Contents()).To(ContainSubstring("server_address: "))
+		})
+	})
+
+	Context("when asked to validate the config", func() {
+		validate := func(configData string) *gexec.Session {
+			configFile, err := ioutil.TempFile("", "file_server-test-config")
+			Expect(err).NotTo(HaveOccurred())
+			configPath = configFile.Name()
+			_, err = configFile.WriteString(configData)
+			Expect(err).NotTo(HaveOccurred())
+			configFile.Close()
+
+			session, err = gexec.Start(exec.Command(fileServerBinary, "-config", configPath, "-validate-config"), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			return session
+		}
+
+		It("exits 0 when the config is valid", func() {
+			servedDirectory, err = ioutil.TempDir("", "file_server-test")
+			Expect(err).NotTo(HaveOccurred())
+
+			session = validate(fmt.Sprintf(`{"server_address": "localhost:8080", "static_directory": %q, "log_level": "info"}`, servedDirectory))
+			Eventually(session).Should(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say(": ok"))
+		})
+
+		It("prints every problem and exits non-zero", func() {
+			session = validate(`{"server_address": "localhost", "log_level": "info"}`)
+			Eventually(session).Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("static_directory: must be set"))
+			Expect(session.Err).To(gbytes.Say("server_address: "))
+		})
+
+		It("reports every setting it does not know along with the other problems", func() {
+			session = validate(`{"static_dirctory": "/tmp", "server_address": "localhost", "registration": {"tagz": ["z1"]}}`)
+			Eventually(session).Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("registration.tagz: unknown setting"))
+			Expect(session.Err).To(gbytes.Say("static_dirctory: unknown setting"))
+			Expect(session.Err).To(gbytes.Say("static_directory: must be set"))
+			Expect(session.Err).To(gbytes.Say("server_address: "))
+		})
+	})
+
 	Context("when started correctly", func() {
 		BeforeEach(func() {
 			servedDirectory, err = ioutil.TempDir("", "file_server-test")
@@ -102,6 +185,474 @@ var _ = Describe("File server", func() {
 			Expect(string(body)).To(Equal("hello"))
 		})
 
+		Context("when a client is slow to send its request headers", func() {
+			BeforeEach(func() {
+				cfg.ReadHeaderTimeout = durationjson.Duration(100 * time.Millisecond)
+			})
+
+			It("closes the connection", func() {
+				conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+				Expect(err).NotTo(HaveOccurred())
+				defer conn.Close()
+
+				_, err = conn.Write([]byte("GET /v1/static/test HTTP/1.1\r\n"))
+				Expect(err).NotTo(HaveOccurred())
+
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				_, err = ioutil.ReadAll(conn)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when rate limiting is configured", func() {
+			BeforeEach(func() {
+				cfg.RateLimit = ratelimit.Config{
+					ReadRequestsPerSecond: 0.01,
+					ReadBurst:             1,
+				}
+			})
+
+			It("rejects requests past the client's allowance with 429", func() {
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				resp, err = http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusTooManyRequests))
+				Expect(resp.Header.Get("Retry-After")).NotTo(BeEmpty())
+			})
+
+			Context("when a metrics address is configured", func() {
+				var metricsPort int
+
+				BeforeEach(func() {
+					metricsPort = 8482 + GinkgoParallelNode()
+					cfg.MetricsAddress = fmt.Sprintf("localhost:%d", metricsPort)
+				})
+
+				It("exposes the rejected requests by class", func() {
+					for i := 0; i < 2; i++ {
+						resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+						Expect(err).NotTo(HaveOccurred())
+						resp.Body.Close()
+					}
+
+					resp, err := http.Get(fmt.Sprintf("http://localhost:%d/metrics", metricsPort))
+					Expect(err).NotTo(HaveOccurred())
+					defer resp.Body.Close()
+					body, err := ioutil.ReadAll(resp.Body)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(string(body)).To(ContainSubstring(`fileserver_rate_limited_requests_total{class="read"} 1`))
+					Expect(string(body)).To(ContainSubstring(`fileserver_rate_limited_requests_total{class="write"} 0`))
+				})
+			})
+
+			It("applies a new rate limit from the config file on SIGHUP", func() {
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+
+				cfg.RateLimit = ratelimit.Config{}
+				cfg.ServerAddress = fmt.Sprintf("localhost:%d", port+100)
+				configData, err := json.Marshal(&cfg)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ioutil.WriteFile(configPath, configData, os.ModePerm)).To(Succeed())
+
+				session.Signal(syscall.SIGHUP)
+				Eventually(session).Should(gbytes.Say("config-reload.settings-require-restart.*server_address"))
+				Eventually(session).Should(gbytes.Say("config-reload.reloaded"))
+
+				for i := 0; i < 3; i++ {
+					resp, err = http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+					Expect(err).NotTo(HaveOccurred())
+					resp.Body.Close()
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				}
+			})
+
+			It("keeps the current settings when the config file is invalid", func() {
+				Expect(ioutil.WriteFile(configPath, []byte("{{"), os.ModePerm)).To(Succeed())
+
+				session.Signal(syscall.SIGHUP)
+				Eventually(session).Should(gbytes.Say("reloader.failed-to-reload"))
+
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				resp, err = http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusTooManyRequests))
+			})
+
+			It("keeps the current settings when the new ones do not pass validation", func() {
+				cfg.RateLimit = ratelimit.Config{}
+				cfg.LogLevel = "loud"
+				configData, err := json.Marshal(&cfg)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ioutil.WriteFile(configPath, configData, os.ModePerm)).To(Succeed())
+
+				session.Signal(syscall.SIGHUP)
+				Eventually(session).Should(gbytes.Say("config-reload.invalid-config.*log_level"))
+				Eventually(session).Should(gbytes.Say("reloader.failed-to-reload"))
+
+				for i := 0; i < 2; i++ {
+					resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+					Expect(err).NotTo(HaveOccurred())
+					resp.Body.Close()
+				}
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusTooManyRequests))
+			})
+		})
+
+		It("logs its effective configuration at startup", func() {
+			Expect(string(session.Out.Contents())).To(ContainSubstring(`"message":"file-server.effective-config"`))
+			Expect(string(session.Out.Contents())).To(ContainSubstring(fmt.Sprintf(`"server_address":"localhost:%d"`, port)))
+		})
+
+		Context("when h2c is enabled", func() {
+			BeforeEach(func() {
+				cfg.HTTP2 = server.HTTP2Config{H2C: true, MaxConcurrentStreams: 16}
+			})
+
+			It("serves files over HTTP/2 without TLS", func() {
+				httpClient := &http.Client{
+					Transport: &http2.Transport{
+						AllowHTTP: true,
+						DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+							return net.Dial(network, addr)
+						},
+					},
+				}
+				resp, err := httpClient.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Proto).To(Equal("HTTP/2.0"))
+
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(Equal("hello"))
+			})
+		})
+
+		It("echoes the request ID it was given", func() {
+			req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/v1/static/test", port), nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("X-Vcap-Request-Id", "some-request-id")
+
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			Expect(resp.Header.Get("X-Vcap-Request-Id")).To(Equal("some-request-id"))
+			Expect(resp.Header.Get("traceparent")).To(MatchRegexp(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`))
+		})
+
+		It("reports itself live and ready", func() {
+			resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/health/live", port))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			resp, err = http.Get(fmt.Sprintf("http://localhost:%d/v1/health/ready", port))
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var report struct {
+				Ready  bool `json:"ready"`
+				Checks []struct {
+					Name string `json:"name"`
+					OK   bool   `json:"ok"`
+				} `json:"checks"`
+			}
+			Expect(json.NewDecoder(resp.Body).Decode(&report)).To(Succeed())
+			Expect(report.Ready).To(BeTrue())
+			Expect(report.Checks).To(HaveLen(3))
+			Expect(report.Checks[0].Name).To(Equal("static-directory"))
+			Expect(report.Checks[1].Name).To(Equal("listeners"))
+			Expect(report.Checks[2].Name).To(Equal("serving"))
+		})
+
+		Context("when the digest cache is warmed up", func() {
+			BeforeEach(func() {
+				cfg.WarmUpDigestCache = true
+			})
+
+			It("becomes ready once the warm-up has finished", func() {
+				Eventually(func() (int, error) {
+					resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/health/ready", port))
+					if err != nil {
+						return 0, err
+					}
+					resp.Body.Close()
+					return resp.StatusCode, nil
+				}).Should(Equal(http.StatusOK))
+				Expect(session.Out.Contents()).To(ContainSubstring("digest-warm-up.finished"))
+			})
+		})
+
+		Context("when variables set for a Kubernetes service share the prefix of the overrides", func() {
+			BeforeEach(func() {
+				env = []string{"FILE_SERVER_SERVICE_HOST=10.0.0.1", "FILE_SERVER_PORT=tcp://10.0.0.1:8080"}
+			})
+
+			It("starts, and logs that it ignores them", func() {
+				Expect(string(session.Out.Contents())).To(MatchRegexp(`ignoring-unmatched-environment.*"FILE_SERVER_PORT","FILE_SERVER_SERVICE_HOST"`))
+			})
+		})
+
+		Context("when the static directory is gone", func() {
+			It("reports itself live but not ready once the cached check expires", func() {
+				Expect(os.RemoveAll(servedDirectory)).To(Succeed())
+
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/health/live", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				Eventually(func() (int, error) {
+					resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/health/ready", port))
+					if err != nil {
+						return 0, err
+					}
+					resp.Body.Close()
+					return resp.StatusCode, nil
+				}, 2*health.DefaultCacheTTL, 500*time.Millisecond).Should(Equal(http.StatusServiceUnavailable))
+			})
+		})
+
+		Context("when span export is configured", func() {
+			var (
+				collector *httptest.Server
+				exports   chan []byte
+			)
+
+			BeforeEach(func() {
+				exports = make(chan []byte, 10)
+				collector = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					body, _ := ioutil.ReadAll(r.Body)
+					exports <- body
+				}))
+				cfg.Tracing = otlp.Config{
+					Endpoint:      collector.URL,
+					FlushInterval: durationjson.Duration(100 * time.Millisecond),
+				}
+			})
+
+			AfterEach(func() {
+				collector.Close()
+			})
+
+			It("sends a span for each request to the collector", func() {
+				req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/v1/static/test", port), nil)
+				Expect(err).NotTo(HaveOccurred())
+				req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+				resp, err := http.DefaultClient.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+
+				var body []byte
+				Eventually(exports, 5*time.Second).Should(Receive(&body))
+				Expect(string(body)).To(ContainSubstring(`"traceId":"4bf92f3577b34da6a3ce929d0e0e4736"`))
+				Expect(string(body)).To(ContainSubstring(`"parentSpanId":"00f067aa0ba902b7"`))
+			})
+		})
+
+		Context("when a metrics address is configured", func() {
+			var metricsPort int
+
+			BeforeEach(func() {
+				metricsPort = 8482 + GinkgoParallelNode()
+				cfg.MetricsAddress = fmt.Sprintf("localhost:%d", metricsPort)
+			})
+
+			It("serves Prometheus metrics on it", func() {
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+
+				resp, err = http.Get(fmt.Sprintf("http://localhost:%d/metrics", metricsPort))
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(string(body)).To(ContainSubstring(`fileserver_request_duration_seconds_count{route="/v1/static/",status="200"} 1`))
+				Expect(string(body)).To(ContainSubstring(`fileserver_response_bytes_total{route="/v1/static/"} 5`))
+				Expect(string(body)).To(ContainSubstring(`fileserver_connections_total{listener="http"} 1`))
+			})
+		})
+
+		Context("when admission control is configured", func() {
+			var metricsPort int
+
+			BeforeEach(func() {
+				metricsPort = 8482 + GinkgoParallelNode()
+				cfg.MetricsAddress = fmt.Sprintf("localhost:%d", metricsPort)
+				cfg.Admission = admission.Config{MaxInFlight: 1}
+				cfg.Bandwidth = throttle.Config{GlobalBytesPerSecond: 1024}
+			})
+
+			It("exposes the requests in flight and the requests shed", func() {
+				Expect(ioutil.WriteFile(filepath.Join(servedDirectory, "large"), make([]byte, 1024*1024), os.ModePerm)).To(Succeed())
+
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/large", port))
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+
+				resp, err = http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+
+				resp, err = http.Get(fmt.Sprintf("http://localhost:%d/metrics", metricsPort))
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(string(body)).To(ContainSubstring("fileserver_admission_in_flight_requests 1\n"))
+				Expect(string(body)).To(ContainSubstring("fileserver_admission_queued_requests 0\n"))
+				Expect(string(body)).To(ContainSubstring("fileserver_admission_rejected_requests_total 1\n"))
+			})
+
+			It("applies a new in-flight limit from the config file on SIGHUP", func() {
+				Expect(ioutil.WriteFile(filepath.Join(servedDirectory, "large"), make([]byte, 1024*1024), os.ModePerm)).To(Succeed())
+
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/large", port))
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+
+				cfg.Admission = admission.Config{MaxInFlight: 2}
+				configData, err := json.Marshal(&cfg)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ioutil.WriteFile(configPath, configData, os.ModePerm)).To(Succeed())
+
+				session.Signal(syscall.SIGHUP)
+				Eventually(session).Should(gbytes.Say("config-reload.reloaded"))
+				Expect(string(session.Out.Contents())).NotTo(ContainSubstring("settings-require-restart"))
+
+				resp, err = http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("when a minimum transfer rate is configured", func() {
+			var metricsPort int
+
+			BeforeEach(func() {
+				metricsPort = 8482 + GinkgoParallelNode()
+				cfg.MetricsAddress = fmt.Sprintf("localhost:%d", metricsPort)
+				cfg.MinTransferRate = watchdog.Config{MinBytesPerSecond: 64 * 1024, Window: durationjson.Duration(time.Second)}
+				cfg.Bandwidth = throttle.Config{GlobalBytesPerSecond: 16 * 1024}
+			})
+
+			It("exposes the responses aborted for being too slow", func() {
+				Expect(ioutil.WriteFile(filepath.Join(servedDirectory, "large"), make([]byte, 1024*1024), os.ModePerm)).To(Succeed())
+
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/large", port))
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+
+				Eventually(func() (string, error) {
+					resp, err := http.Get(fmt.Sprintf("http://localhost:%d/metrics", metricsPort))
+					if err != nil {
+						return "", err
+					}
+					defer resp.Body.Close()
+					body, err := ioutil.ReadAll(resp.Body)
+					return string(body), err
+				}, 10*time.Second).Should(ContainSubstring("fileserver_slow_transfer_evictions_total 1\n"))
+			})
+		})
+
+		Context("when an access log is configured", func() {
+			var accessLogPath string
+
+			BeforeEach(func() {
+				accessLogPath = filepath.Join(servedDirectory, "access.log")
+				cfg.AccessLog = accesslog.Config{Path: accessLogPath}
+			})
+
+			It("writes a line per request and reopens the file on SIGHUP", func() {
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+
+				Eventually(func() (string, error) {
+					contents, err := ioutil.ReadFile(accessLogPath)
+					return string(contents), err
+				}).Should(ContainSubstring(`"GET /v1/static/test HTTP/1.1" 200 5`))
+
+				Expect(os.Rename(accessLogPath, accessLogPath+".1")).To(Succeed())
+				session.Signal(syscall.SIGHUP)
+				Eventually(accessLogPath).Should(BeAnExistingFile())
+				Consistently(session).ShouldNot(gexec.Exit())
+			})
+		})
+
+		Context("when a download outlasts the drain timeout", func() {
+			BeforeEach(func() {
+				cfg.DrainTimeout = durationjson.Duration(200 * time.Millisecond)
+				cfg.Bandwidth = throttle.Config{GlobalBytesPerSecond: 1024}
+			})
+
+			It("logs it and exits once the timeout has passed", func() {
+				Expect(ioutil.WriteFile(filepath.Join(servedDirectory, "large"), make([]byte, 1024*1024), os.ModePerm)).To(Succeed())
+
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/large", port))
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+
+				session.Interrupt()
+				Eventually(session).Should(gbytes.Say("file-server.draining"))
+				Eventually(session).Should(gbytes.Say("file-server.drain-timed-out"))
+				Eventually(session).Should(gbytes.Say(`file-server.inflight.request-in-flight.*"path":"/v1/static/large"`))
+				Eventually(session).Should(gexec.Exit(0))
+			})
+		})
+
+		Context("when the file registration backend is configured", func() {
+			var registrationPath string
+
+			BeforeEach(func() {
+				registrationPath = filepath.Join(servedDirectory, "registration.json")
+				cfg.Registration = registration.Config{
+					Backend: registration.FileBackend,
+					File:    registration.FileConfig{Path: registrationPath},
+				}
+			})
+
+			It("announces itself as ready and withdraws on shutdown", func() {
+				Eventually(func() (string, error) {
+					contents, err := ioutil.ReadFile(registrationPath)
+					return string(contents), err
+				}).Should(ContainSubstring(`"ready":true`))
+				contents, err := ioutil.ReadFile(registrationPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(ContainSubstring(fmt.Sprintf(`"port":%d`, port)))
+
+				session.Interrupt()
+				Eventually(session).Should(gexec.Exit(0))
+				Expect(registrationPath).NotTo(BeAnExistingFile())
+			})
+		})
+
 		Context("when consul service registration is enabled", func() {
 			BeforeEach(func() {
 				cfg.EnableConsulServiceRegistration = true
@@ -115,6 +666,7 @@ var _ = Describe("File server", func() {
 						Service: "file-server",
 						ID:      "file-server",
 						Port:    port,
+						Meta:    map[string]string{"version": "dev"},
 					}))
 			})
 
@@ -142,6 +694,203 @@ var _ = Describe("File server", func() {
 		})
 	})
 
+	Context("when listening on sockets other than TCP ones", func() {
+		var socketDir string
+
+		writeConfig := func() {
+			configFile, err := ioutil.TempFile("", "file_server-test-config")
+			Expect(err).NotTo(HaveOccurred())
+			configPath = configFile.Name()
+			Expect(json.NewEncoder(configFile).Encode(&cfg)).To(Succeed())
+			Expect(configFile.Close()).To(Succeed())
+		}
+
+		get := func(client *http.Client, url string) string {
+			resp, err := client.Get(url)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			return string(body)
+		}
+
+		BeforeEach(func() {
+			servedDirectory, err = ioutil.TempDir("", "file_server-test")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(servedDirectory, "test"), []byte("hello"), os.ModePerm)).To(Succeed())
+			socketDir, err = ioutil.TempDir("", "file_server-sockets")
+			Expect(err).NotTo(HaveOccurred())
+
+			cfg = config.FileServerConfig{
+				LagerConfig: lagerflags.LagerConfig{
+					LogLevel:   lagerflags.INFO,
+					TimeFormat: lagerflags.FormatUnixEpoch,
+				},
+				StaticDirectory: servedDirectory,
+			}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(socketDir)
+		})
+
+		It("serves files on a Unix domain socket", func() {
+			socketPath := filepath.Join(socketDir, "file-server.sock")
+			cfg.ServerAddress = "unix://" + socketPath
+			cfg.UnixSocketMode = "0660"
+			writeConfig()
+			session = start()
+
+			client := &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+				},
+			}}
+			Expect(get(client, "http://file-server/v1/static/test")).To(Equal("hello"))
+
+			info, err := os.Stat(socketPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0660)))
+		})
+
+		It("serves files on a socket passed by systemd", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+			listenerFile, err := listener.(*net.TCPListener).File()
+			Expect(err).NotTo(HaveOccurred())
+			defer listenerFile.Close()
+
+			cfg.ServerAddress = "fd://file-server"
+			writeConfig()
+
+			// Like systemd, name the process that is given the socket in
+			// LISTEN_PID, which the shell keeps through exec.
+			cmd := exec.Command("/bin/sh", "-c", `LISTEN_PID=$$ exec "$0" -config "$1"`, fileServerBinary, configPath)
+			cmd.Env = append(os.Environ(), "LISTEN_FDS=1", "LISTEN_FDNAMES=file-server")
+			cmd.ExtraFiles = []*os.File{listenerFile}
+			session, err = gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gbytes.Say("file-server.ready"))
+
+			Expect(get(http.DefaultClient, fmt.Sprintf("http://%s/v1/static/test", listener.Addr()))).To(Equal("hello"))
+		})
+	})
+
+	Context("when several listeners are configured", func() {
+		var (
+			internalPort, managementPort int
+			certDir                      string
+			managementClient             *http.Client
+		)
+
+		writeFile := func(name string, data []byte) string {
+			path := filepath.Join(certDir, name)
+			Expect(ioutil.WriteFile(path, data, 0600)).To(Succeed())
+			return path
+		}
+
+		BeforeEach(func() {
+			servedDirectory, err = ioutil.TempDir("", "file_server-test")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(servedDirectory, "test"), []byte("hello"), os.ModePerm)).To(Succeed())
+			certDir, err = ioutil.TempDir("", "file_server-certs")
+			Expect(err).NotTo(HaveOccurred())
+
+			ca, err := certtest.BuildCA("test-ca")
+			Expect(err).NotTo(HaveOccurred())
+			caPEM, err := ca.CertificatePEM()
+			Expect(err).NotTo(HaveOccurred())
+			caPool, err := ca.CertPool()
+			Expect(err).NotTo(HaveOccurred())
+			serverCert, err := ca.BuildSignedCertificate("fileserver")
+			Expect(err).NotTo(HaveOccurred())
+			serverCertPEM, serverKeyPEM, err := serverCert.CertificatePEMAndPrivateKey()
+			Expect(err).NotTo(HaveOccurred())
+			clientCert, err := ca.BuildSignedCertificate("operator")
+			Expect(err).NotTo(HaveOccurred())
+			clientIdentity, err := clientCert.TLSCertificate()
+			Expect(err).NotTo(HaveOccurred())
+
+			clientTLSConfig, err := tlsconfig.Build(
+				tlsconfig.WithInternalServiceDefaults(),
+				tlsconfig.WithIdentity(clientIdentity),
+			).Client(tlsconfig.WithAuthority(caPool))
+			Expect(err).NotTo(HaveOccurred())
+			managementClient = &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+
+			internalPort = 8182 + GinkgoParallelNode()
+			managementPort = 8282 + GinkgoParallelNode()
+			cfg = config.FileServerConfig{
+				LagerConfig: lagerflags.LagerConfig{
+					LogLevel:   lagerflags.INFO,
+					TimeFormat: lagerflags.FormatUnixEpoch,
+				},
+				StaticDirectory: servedDirectory,
+				Listeners: []server.ListenerConfig{
+					{
+						Name:    "internal",
+						Address: fmt.Sprintf("localhost:%d", internalPort),
+						Routes:  []string{"static"},
+					},
+					{
+						Name:         "management",
+						Address:      fmt.Sprintf("localhost:%d", managementPort),
+						CertFile:     writeFile("server.crt", serverCertPEM),
+						KeyFile:      writeFile("server.key", serverKeyPEM),
+						ClientCAFile: writeFile("ca.crt", caPEM),
+						Routes:       []string{"health"},
+					},
+				},
+			}
+
+			configFile, err := ioutil.TempFile("", "file_server-test-config")
+			Expect(err).NotTo(HaveOccurred())
+			configPath = configFile.Name()
+			Expect(json.NewEncoder(configFile).Encode(&cfg)).To(Succeed())
+			Expect(configFile.Close()).To(Succeed())
+
+			session = start()
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(certDir)
+		})
+
+		It("serves only the route groups enabled on each listener", func() {
+			resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", internalPort))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			resp, err = http.Get(fmt.Sprintf("http://localhost:%d/v1/health/live", internalPort))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+
+			resp, err = managementClient.Get(fmt.Sprintf("https://localhost:%d/v1/health/live", managementPort))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			resp, err = managementClient.Get(fmt.Sprintf("https://localhost:%d/v1/static/test", managementPort))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		It("requires a client certificate on the listener with a client CA", func() {
+			transport := managementClient.Transport.(*http.Transport).Clone()
+			transport.TLSClientConfig.Certificates = nil
+			transport.TLSClientConfig.GetClientCertificate = nil
+
+			_, err := (&http.Client{Transport: transport}).Get(fmt.Sprintf("https://localhost:%d/v1/health/live", managementPort))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
 	Context("when HTTPS server is enabled", func() {
 		var tlsPort int
 		BeforeEach(func() {
@@ -263,6 +1012,29 @@ var _ = Describe("File server", func() {
 				Expect(string(body)).To(Equal("hello"))
 			})
 
+			It("serves the test file over HTTP/2", func() {
+				clientTLSConfig, err := tlsconfig.Build(
+					tlsconfig.WithInternalServiceDefaults(),
+				).Client(tlsconfig.WithAuthority(caCertPool))
+				Expect(err).NotTo(HaveOccurred())
+
+				httpClient := &http.Client{
+					Transport: &http2.Transport{
+						TLSClientConfig: clientTLSConfig,
+					},
+				}
+				resp, err := httpClient.Get(fmt.Sprintf("https://localhost:%d/v1/static/test", tlsPort))
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Proto).To(Equal("HTTP/2.0"))
+
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(Equal("hello"))
+			})
+
 			It("fails to return test when caCertPool is missing", func() {
 				clientTLSConfig, err := tlsconfig.Build(
 					tlsconfig.WithInternalServiceDefaults(),
@@ -278,6 +1050,37 @@ var _ = Describe("File server", func() {
 				Expect(err.Error()).To(ContainSubstring("x509: certificate signed by unknown authority"))
 			})
 
+			It("serves a rotated certificate after SIGHUP", func() {
+				newCA, err := certtest.BuildCA("new-ca")
+				Expect(err).NotTo(HaveOccurred())
+				newCert, err := newCA.BuildSignedCertificate("fileserver")
+				Expect(err).NotTo(HaveOccurred())
+				newPool, err := newCA.CertPool()
+				Expect(err).NotTo(HaveOccurred())
+				certPEM, keyPEM, err := newCert.CertificatePEMAndPrivateKey()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ioutil.WriteFile(certFile.Name(), certPEM, 0600)).To(Succeed())
+				Expect(ioutil.WriteFile(keyFile.Name(), keyPEM, 0600)).To(Succeed())
+
+				session.Signal(syscall.SIGHUP)
+				Eventually(session).Should(gbytes.Say("tls-reloader.reload.reloaded"))
+
+				clientTLSConfig, err := tlsconfig.Build(
+					tlsconfig.WithInternalServiceDefaults(),
+				).Client(tlsconfig.WithAuthority(newPool))
+				Expect(err).NotTo(HaveOccurred())
+
+				httpClient := &http.Client{
+					Transport: &http.Transport{
+						TLSClientConfig: clientTLSConfig,
+					},
+				}
+				resp, err := httpClient.Get(fmt.Sprintf("https://localhost:%d/v1/static/test", tlsPort))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+
 			It("should return a 301 redirect to the HTTPS URL when making an HTTP Get request", func() {
 				clientTLSConfig, err := tlsconfig.Build(
 					tlsconfig.WithInternalServiceDefaults(),
@@ -304,6 +1107,58 @@ var _ = Describe("File server", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(location.String()).To(Equal(fmt.Sprintf("https://file-server.service.test.com:%d/v1/static/test", tlsPort)))
 			})
+
+			Context("when HSTS is configured", func() {
+				BeforeEach(func() {
+					cfg.HTTPSRedirect.HSTSMaxAge = durationjson.Duration(24 * time.Hour)
+				})
+
+				It("sends the Strict-Transport-Security header over HTTPS", func() {
+					clientTLSConfig, err := tlsconfig.Build(
+						tlsconfig.WithInternalServiceDefaults(),
+					).Client(tlsconfig.WithAuthority(caCertPool))
+					Expect(err).NotTo(HaveOccurred())
+
+					httpClient := &http.Client{
+						Transport: &http.Transport{
+							TLSClientConfig: clientTLSConfig,
+						},
+					}
+					resp, err := httpClient.Get(fmt.Sprintf("https://localhost:%d/v1/static/test", tlsPort))
+					Expect(err).NotTo(HaveOccurred())
+					resp.Body.Close()
+					Expect(resp.Header.Get("Strict-Transport-Security")).To(Equal("max-age=86400"))
+				})
+			})
+
+			Context("when the plain listener only serves health checks", func() {
+				BeforeEach(func() {
+					cfg.HTTPSRedirect.PlainListener = redirect.ModeHealthOnly
+				})
+
+				It("serves the health routes and nothing else over HTTP", func() {
+					resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/health/live", port))
+					Expect(err).NotTo(HaveOccurred())
+					resp.Body.Close()
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+					resp, err = http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+					Expect(err).NotTo(HaveOccurred())
+					resp.Body.Close()
+					Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+				})
+			})
+
+			Context("when the plain listener is disabled", func() {
+				BeforeEach(func() {
+					cfg.HTTPSRedirect.PlainListener = redirect.ModeDisabled
+				})
+
+				It("does not listen for HTTP", func() {
+					_, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/health/live", port))
+					Expect(err).To(HaveOccurred())
+				})
+			})
 		})
 	})
 })
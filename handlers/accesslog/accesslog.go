@@ -0,0 +1,237 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	FormatCombined = "combined"
+	FormatJSON     = "json"
+)
+
+// Config describes the access log file. An empty Path disables it. The file
+// is rotated once it would grow past MaxSizeBytes, keeping MaxBackups old
+// files as Path.1, Path.2 and so on; a MaxSizeBytes of zero never rotates.
+type Config struct {
+	Path         string `json:"path,omitempty"`
+	Format       string `json:"format,omitempty"`
+	MaxSizeBytes int64  `json:"max_size_bytes,omitempty"`
+	MaxBackups   int    `json:"max_backups,omitempty"`
+}
+
+// Writer writes one line per response to the access log file, regardless of
+// the level of the lager logger. It implements static.Observer.
+type Writer struct {
+	logger lager.Logger
+	config Config
+	format func(static.Response) []byte
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func New(logger lager.Logger, config Config) (*Writer, error) {
+	if config.Path == "" {
+		return nil, errors.New("access log path is required")
+	}
+
+	w := &Writer{
+		logger: logger.Session("access-log", lager.Data{"path": config.Path}),
+		config: config,
+	}
+
+	switch config.Format {
+	case "", FormatCombined:
+		w.format = combinedLine
+	case FormatJSON:
+		w.format = jsonLine
+	default:
+		return nil, fmt.Errorf("unknown access log format: %q", config.Format)
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) ObserveResponse(res static.Response) {
+	line := w.format(res)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.config.MaxSizeBytes > 0 && w.size > 0 && w.size+int64(len(line)) > w.config.MaxSizeBytes {
+		if err := w.rotate(); err != nil {
+			w.logger.Error("failed-to-rotate", err)
+		}
+	}
+
+	if w.file == nil {
+		return
+	}
+
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	if err != nil {
+		w.logger.Error("failed-to-write", err)
+	}
+}
+
+// Reopen closes and reopens the file at the configured path, so that the
+// log can be moved away by an external tool such as logrotate.
+func (w *Writer) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.close()
+	return w.open()
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.close()
+}
+
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+func (w *Writer) close() error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *Writer) rotate() error {
+	w.close()
+
+	if w.config.MaxBackups > 0 {
+		for i := w.config.MaxBackups - 1; i > 0; i-- {
+			err := os.Rename(w.backupPath(i), w.backupPath(i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(w.config.Path, w.backupPath(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(w.config.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return w.open()
+}
+
+func (w *Writer) backupPath(i int) string {
+	return w.config.Path + "." + strconv.Itoa(i)
+}
+
+// combinedLine formats the response in the Apache Combined Log Format.
+func combinedLine(res static.Response) []byte {
+	host, _, err := net.SplitHostPort(res.RemoteAddr)
+	if err != nil {
+		host = res.RemoteAddr
+	}
+
+	size := "-"
+	if res.Size > 0 {
+		size = strconv.FormatInt(res.Size, 10)
+	}
+
+	return []byte(fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		host,
+		res.StartedAt.Format("02/Jan/2006:15:04:05 -0700"),
+		escape(res.Method), escape(res.URI), escape(res.Proto),
+		res.Status,
+		size,
+		escape(orDash(res.Referer)),
+		escape(orDash(res.UserAgent)),
+	))
+}
+
+type jsonRecord struct {
+	Time                string  `json:"time"`
+	RemoteAddr          string  `json:"remote_addr"`
+	Method              string  `json:"method"`
+	URI                 string  `json:"uri"`
+	Proto               string  `json:"proto"`
+	Status              int     `json:"status"`
+	Size                int64   `json:"size"`
+	DurationSeconds     float64 `json:"duration_seconds"`
+	Referer             string  `json:"referer,omitempty"`
+	UserAgent           string  `json:"user_agent,omitempty"`
+	TLSVersion          string  `json:"tls_version,omitempty"`
+	TLSPeer             string  `json:"tls_peer,omitempty"`
+	DigestCached        *bool   `json:"digest_cached,omitempty"`
+	RangeBytesRequested *int64  `json:"range_bytes_requested,omitempty"`
+}
+
+func jsonLine(res static.Response) []byte {
+	record := jsonRecord{
+		Time:            res.StartedAt.UTC().Format("2006-01-02T15:04:05.000000000Z"),
+		RemoteAddr:      res.RemoteAddr,
+		Method:          res.Method,
+		URI:             res.URI,
+		Proto:           res.Proto,
+		Status:          res.Status,
+		Size:            res.Size,
+		DurationSeconds: res.Duration.Seconds(),
+		Referer:         res.Referer,
+		UserAgent:       res.UserAgent,
+		TLSVersion:      res.TLSVersion,
+		TLSPeer:         res.TLSPeer,
+	}
+	if res.DigestComputed {
+		record.DigestCached = &res.DigestCached
+	}
+	if res.RangeRequested {
+		record.RangeBytesRequested = &res.RangeBytesRequested
+	}
+
+	line, _ := json.Marshal(record)
+	return append(line, '\n')
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
@@ -0,0 +1,153 @@
+package accesslog_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/accesslog"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AccessLog", func() {
+	var (
+		logger   *lagertest.TestLogger
+		logDir   string
+		logPath  string
+		cfg      accesslog.Config
+		writer   *accesslog.Writer
+		response static.Response
+	)
+
+	readLines := func(path string) []string {
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	}
+
+	BeforeEach(func() {
+		var err error
+		logDir, err = ioutil.TempDir("", "access-log")
+		Expect(err).NotTo(HaveOccurred())
+		logPath = filepath.Join(logDir, "access.log")
+
+		logger = lagertest.NewTestLogger("test")
+		cfg = accesslog.Config{Path: logPath}
+		response = static.Response{
+			StartedAt:  time.Date(2026, time.March, 4, 5, 6, 7, 0, time.UTC),
+			Duration:   250 * time.Millisecond,
+			Method:     "GET",
+			URI:        "/v1/static/droplet.tgz?x=1",
+			Proto:      "HTTP/1.1",
+			Status:     200,
+			Size:       1234,
+			RemoteAddr: "10.0.0.1:54321",
+			UserAgent:  `curl/"8"`,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		writer, err = accesslog.New(logger, cfg)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		writer.Close()
+		os.RemoveAll(logDir)
+	})
+
+	Describe("New", func() {
+		It("rejects an unknown format", func() {
+			_, err := accesslog.New(logger, accesslog.Config{Path: logPath, Format: "xml"})
+			Expect(err).To(MatchError(ContainSubstring("xml")))
+		})
+
+		It("requires a path", func() {
+			_, err := accesslog.New(logger, accesslog.Config{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("in the combined format", func() {
+		It("writes one line per response", func() {
+			writer.ObserveResponse(response)
+			response.Status = 304
+			response.Size = 0
+			writer.ObserveResponse(response)
+
+			Expect(readLines(logPath)).To(Equal([]string{
+				`10.0.0.1 - - [04/Mar/2026:05:06:07 +0000] "GET /v1/static/droplet.tgz?x=1 HTTP/1.1" 200 1234 "-" "curl/\"8\""`,
+				`10.0.0.1 - - [04/Mar/2026:05:06:07 +0000] "GET /v1/static/droplet.tgz?x=1 HTTP/1.1" 304 - "-" "curl/\"8\""`,
+			}))
+		})
+	})
+
+	Context("in the JSON Lines format", func() {
+		BeforeEach(func() {
+			cfg.Format = accesslog.FormatJSON
+			response.DigestComputed = true
+			response.DigestCached = true
+		})
+
+		It("writes one JSON object per response", func() {
+			writer.ObserveResponse(response)
+
+			lines := readLines(logPath)
+			Expect(lines).To(HaveLen(1))
+
+			var record map[string]interface{}
+			Expect(json.Unmarshal([]byte(lines[0]), &record)).To(Succeed())
+			Expect(record).To(Equal(map[string]interface{}{
+				"time":             "2026-03-04T05:06:07.000000000Z",
+				"remote_addr":      "10.0.0.1:54321",
+				"method":           "GET",
+				"uri":              "/v1/static/droplet.tgz?x=1",
+				"proto":            "HTTP/1.1",
+				"status":           float64(200),
+				"size":             float64(1234),
+				"duration_seconds": 0.25,
+				"user_agent":       `curl/"8"`,
+				"digest_cached":    true,
+			}))
+		})
+	})
+
+	Context("when the file grows past the maximum size", func() {
+		BeforeEach(func() {
+			cfg.MaxSizeBytes = 150
+			cfg.MaxBackups = 2
+		})
+
+		It("rotates it and keeps the configured number of backups", func() {
+			for i := 0; i < 4; i++ {
+				writer.ObserveResponse(response)
+			}
+
+			Expect(readLines(logPath)).To(HaveLen(1))
+			Expect(readLines(logPath + ".1")).To(HaveLen(1))
+			Expect(readLines(logPath + ".2")).To(HaveLen(1))
+			Expect(logPath + ".3").NotTo(BeAnExistingFile())
+		})
+	})
+
+	Describe("Reopen", func() {
+		It("starts a new file once the old one has been moved away", func() {
+			writer.ObserveResponse(response)
+			Expect(os.Rename(logPath, logPath+".old")).To(Succeed())
+
+			Expect(writer.Reopen()).To(Succeed())
+			writer.ObserveResponse(response)
+
+			Expect(readLines(logPath)).To(HaveLen(1))
+			Expect(readLines(logPath + ".old")).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,178 @@
+package admission
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/durationjson"
+	"code.cloudfoundry.org/lager"
+)
+
+const DefaultQueueTimeout = time.Second
+
+// Config bounds the number of requests served at once. A MaxInFlight of
+// zero disables admission control. Up to MaxQueued requests wait at most
+// QueueTimeout for a slot to free up before being shed.
+type Config struct {
+	MaxInFlight  int                   `json:"max_in_flight,omitempty"`
+	MaxQueued    int                   `json:"max_queued,omitempty"`
+	QueueTimeout durationjson.Duration `json:"queue_timeout,omitempty"`
+}
+
+type Controller struct {
+	logger lager.Logger
+	clock  clock.Clock
+
+	rejections uint64
+
+	mu           sync.Mutex
+	maxInFlight  int64
+	maxQueued    int64
+	queueTimeout time.Duration
+	inFlight     int64
+	waiting      []chan struct{}
+}
+
+func New(logger lager.Logger, clock clock.Clock, config Config) *Controller {
+	c := &Controller{
+		logger: logger.Session("admission-control"),
+		clock:  clock,
+	}
+	c.Update(config)
+	return c
+}
+
+// Update applies new limits. The requests being served keep counting against
+// the in-flight limit, and queued requests are admitted as soon as it allows.
+func (c *Controller) Update(config Config) {
+	queueTimeout := time.Duration(config.QueueTimeout)
+	if queueTimeout <= 0 {
+		queueTimeout = DefaultQueueTimeout
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxInFlight = int64(config.MaxInFlight)
+	c.maxQueued = int64(config.MaxQueued)
+	c.queueTimeout = queueTimeout
+	c.admitWaiting()
+}
+
+// Wrap returns a handler that serves at most MaxInFlight requests at once
+// and responds with 503 Service Unavailable once the wait queue is full or a
+// queued request has waited too long.
+func (c *Controller) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := c.admit(r); !ok {
+			if r.Context().Err() != nil {
+				return
+			}
+
+			atomic.AddUint64(&c.rejections, 1)
+			c.logger.Debug("shed", lager.Data{
+				"uri":       r.URL.RequestURI(),
+				"in-flight": c.InFlight(),
+				"queued":    c.Queued(),
+			})
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer c.release()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// admit counts the request as in flight, once there is room for it. When it
+// is turned away, it also returns the queue timeout to suggest as the time to
+// retry after.
+func (c *Controller) admit(r *http.Request) (bool, time.Duration) {
+	c.mu.Lock()
+	if c.maxInFlight <= 0 || c.inFlight < c.maxInFlight {
+		c.inFlight++
+		c.mu.Unlock()
+		return true, 0
+	}
+
+	queueTimeout := c.queueTimeout
+	if int64(len(c.waiting)) >= c.maxQueued {
+		c.mu.Unlock()
+		return false, queueTimeout
+	}
+	admitted := make(chan struct{})
+	c.waiting = append(c.waiting, admitted)
+	c.mu.Unlock()
+
+	timer := c.clock.NewTimer(queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-admitted:
+		return true, 0
+	case <-timer.C():
+	case <-r.Context().Done():
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, waiting := range c.waiting {
+		if waiting == admitted {
+			c.waiting = append(c.waiting[:i], c.waiting[i+1:]...)
+			return false, queueTimeout
+		}
+	}
+	// Admitted while giving up: the request is served unless its client has
+	// gone, in which case the slot goes to the next in line.
+	if r.Context().Err() == nil {
+		return true, 0
+	}
+	c.inFlight--
+	c.admitWaiting()
+	return false, queueTimeout
+}
+
+func (c *Controller) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inFlight--
+	c.admitWaiting()
+}
+
+// admitWaiting admits queued requests, in the order they arrived, for as long
+// as the in-flight limit allows.
+func (c *Controller) admitWaiting() {
+	for len(c.waiting) > 0 && (c.maxInFlight <= 0 || c.inFlight < c.maxInFlight) {
+		close(c.waiting[0])
+		c.waiting = c.waiting[1:]
+		c.inFlight++
+	}
+}
+
+// InFlight returns the number of requests currently being served.
+func (c *Controller) InFlight() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inFlight
+}
+
+// Queued returns the number of requests currently waiting for a slot.
+func (c *Controller) Queued() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int64(len(c.waiting))
+}
+
+// Rejections returns the number of requests shed since the controller was
+// created.
+func (c *Controller) Rejections() uint64 {
+	return atomic.LoadUint64(&c.rejections)
+}
@@ -0,0 +1,187 @@
+package admission_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/durationjson"
+	"code.cloudfoundry.org/fileserver/handlers/admission"
+	"code.cloudfoundry.org/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Controller", func() {
+	var (
+		fakeClock  *fakeclock.FakeClock
+		cfg        admission.Config
+		controller *admission.Controller
+		handler    http.Handler
+		release    chan struct{}
+	)
+
+	serve := func() (*httptest.ResponseRecorder, chan struct{}) {
+		rec := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/static/file", nil))
+		}()
+		return rec, done
+	}
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		release = make(chan struct{})
+		cfg = admission.Config{
+			MaxInFlight:  1,
+			MaxQueued:    1,
+			QueueTimeout: durationjson.Duration(2 * time.Second),
+		}
+	})
+
+	JustBeforeEach(func() {
+		controller = admission.New(lagertest.NewTestLogger("test"), fakeClock, cfg)
+		release := release
+		handler = controller.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			w.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	AfterEach(func() {
+		close(release)
+	})
+
+	It("serves requests up to the in-flight limit", func() {
+		_, done := serve()
+		Eventually(controller.InFlight).Should(BeEquivalentTo(1))
+
+		release <- struct{}{}
+		Eventually(done).Should(BeClosed())
+		Expect(controller.InFlight()).To(BeZero())
+	})
+
+	It("queues requests past the limit until a slot frees up", func() {
+		_, firstDone := serve()
+		Eventually(controller.InFlight).Should(BeEquivalentTo(1))
+
+		secondRec, secondDone := serve()
+		Eventually(controller.Queued).Should(BeEquivalentTo(1))
+
+		release <- struct{}{}
+		Eventually(firstDone).Should(BeClosed())
+		Eventually(controller.Queued).Should(BeZero())
+
+		release <- struct{}{}
+		Eventually(secondDone).Should(BeClosed())
+		Expect(secondRec.Code).To(Equal(http.StatusOK))
+	})
+
+	It("sheds requests with 503 once the queue is full", func() {
+		serve()
+		Eventually(controller.InFlight).Should(BeEquivalentTo(1))
+		serve()
+		Eventually(controller.Queued).Should(BeEquivalentTo(1))
+
+		rec, done := serve()
+		Eventually(done).Should(BeClosed())
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(rec.Header().Get("Retry-After")).To(Equal("2"))
+		Expect(controller.Rejections()).To(BeEquivalentTo(1))
+	})
+
+	It("sheds queued requests that wait longer than the queue timeout", func() {
+		serve()
+		Eventually(controller.InFlight).Should(BeEquivalentTo(1))
+
+		rec, done := serve()
+		Eventually(controller.Queued).Should(BeEquivalentTo(1))
+
+		fakeClock.WaitForWatcherAndIncrement(2 * time.Second)
+		Eventually(done).Should(BeClosed())
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(controller.Queued()).To(BeZero())
+	})
+
+	It("applies updated limits to the requests that follow", func() {
+		_, firstDone := serve()
+		Eventually(controller.InFlight).Should(BeEquivalentTo(1))
+
+		controller.Update(admission.Config{MaxInFlight: 2})
+		_, secondDone := serve()
+		Eventually(controller.InFlight).Should(BeEquivalentTo(2))
+		Expect(controller.Queued()).To(BeZero())
+
+		release <- struct{}{}
+		release <- struct{}{}
+		Eventually(firstDone).Should(BeClosed())
+		Eventually(secondDone).Should(BeClosed())
+		Expect(controller.InFlight()).To(BeZero())
+	})
+
+	It("keeps counting the requests admitted before an update", func() {
+		_, firstDone := serve()
+		Eventually(controller.InFlight).Should(BeEquivalentTo(1))
+
+		controller.Update(admission.Config{MaxInFlight: 1})
+		rec, secondDone := serve()
+		Eventually(secondDone).Should(BeClosed())
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+
+		release <- struct{}{}
+		Eventually(firstDone).Should(BeClosed())
+		Expect(controller.InFlight()).To(BeZero())
+	})
+
+	It("admits queued requests once an update raises the limit", func() {
+		serve()
+		Eventually(controller.InFlight).Should(BeEquivalentTo(1))
+		_, secondDone := serve()
+		Eventually(controller.Queued).Should(BeEquivalentTo(1))
+
+		controller.Update(admission.Config{MaxInFlight: 2})
+		Eventually(controller.InFlight).Should(BeEquivalentTo(2))
+		Expect(controller.Queued()).To(BeZero())
+
+		release <- struct{}{}
+		release <- struct{}{}
+		Eventually(secondDone).Should(BeClosed())
+	})
+
+	Context("when no in-flight limit is configured", func() {
+		BeforeEach(func() {
+			cfg.MaxInFlight = 0
+		})
+
+		It("does not limit requests", func() {
+			_, firstDone := serve()
+			_, secondDone := serve()
+			_, thirdDone := serve()
+
+			release <- struct{}{}
+			release <- struct{}{}
+			release <- struct{}{}
+			Eventually(firstDone).Should(BeClosed())
+			Eventually(secondDone).Should(BeClosed())
+			Eventually(thirdDone).Should(BeClosed())
+		})
+
+		It("starts limiting once a limit is applied", func() {
+			controller.Update(admission.Config{MaxInFlight: 1})
+			_, firstDone := serve()
+			Eventually(controller.InFlight).Should(BeEquivalentTo(1))
+
+			rec, secondDone := serve()
+			Eventually(secondDone).Should(BeClosed())
+			Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+
+			release <- struct{}{}
+			Eventually(firstDone).Should(BeClosed())
+		})
+	})
+})
@@ -0,0 +1 @@
+package admission // import "code.cloudfoundry.org/fileserver/handlers/admission"
@@ -0,0 +1,46 @@
+package chunked
+
+import "io"
+
+// ReadFrom hands src to dst at most chunkSize bytes at a time and calls
+// afterChunk with the number of bytes sent after each chunk, stopping at the
+// first error either of them returns.
+//
+// It lets a response writer wrapper observe or pace a transfer without
+// hiding the file being sent from dst. When src is an *io.LimitedReader, as
+// it is for http.ServeContent, the chunks are carved from the reader it
+// wraps rather than nested inside it, because sendfile only looks through a
+// single *io.LimitedReader to find the *os.File.
+func ReadFrom(dst io.ReaderFrom, src io.Reader, chunkSize int64, afterChunk func(int64) error) (int64, error) {
+	remaining := int64(-1)
+	if lr, ok := src.(*io.LimitedReader); ok {
+		remaining = lr.N
+		src = lr.R
+		defer func() { lr.N = remaining }()
+	}
+
+	var written int64
+	for remaining != 0 {
+		size := chunkSize
+		if remaining > 0 && remaining < size {
+			size = remaining
+		}
+
+		n, err := dst.ReadFrom(&io.LimitedReader{R: src, N: size})
+		written += n
+		if remaining > 0 {
+			remaining -= n
+		}
+		if err != nil {
+			return written, err
+		}
+		if err := afterChunk(n); err != nil {
+			return written, err
+		}
+		if n < size {
+			break
+		}
+	}
+
+	return written, nil
+}
@@ -0,0 +1,13 @@
+package chunked_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestChunked(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Chunked Suite")
+}
@@ -0,0 +1,89 @@
+package chunked_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"code.cloudfoundry.org/fileserver/handlers/chunked"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recordingReaderFrom struct {
+	bytes.Buffer
+	sources []io.Reader
+}
+
+func (r *recordingReaderFrom) ReadFrom(src io.Reader) (int64, error) {
+	r.sources = append(r.sources, src)
+	return r.Buffer.ReadFrom(src)
+}
+
+var _ = Describe("ReadFrom", func() {
+	var (
+		dst    *recordingReaderFrom
+		chunks []int64
+	)
+
+	afterChunk := func(n int64) error {
+		chunks = append(chunks, n)
+		return nil
+	}
+
+	BeforeEach(func() {
+		dst = &recordingReaderFrom{}
+		chunks = nil
+	})
+
+	It("sends the source in chunks", func() {
+		n, err := chunked.ReadFrom(dst, strings.NewReader("0123456789"), 4, afterChunk)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(BeEquivalentTo(10))
+		Expect(dst.String()).To(Equal("0123456789"))
+		Expect(chunks).To(Equal([]int64{4, 4, 2}))
+	})
+
+	Context("when the source is a limited reader", func() {
+		var (
+			underlying io.Reader
+			src        *io.LimitedReader
+		)
+
+		BeforeEach(func() {
+			underlying = strings.NewReader("0123456789")
+			src = &io.LimitedReader{R: underlying, N: 6}
+		})
+
+		It("carves the chunks from the reader underneath it", func() {
+			n, err := chunked.ReadFrom(dst, src, 4, afterChunk)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(BeEquivalentTo(6))
+			Expect(dst.String()).To(Equal("012345"))
+			Expect(chunks).To(Equal([]int64{4, 2}))
+
+			for _, s := range dst.sources {
+				Expect(s.(*io.LimitedReader).R).To(BeIdenticalTo(underlying))
+			}
+		})
+
+		It("keeps the limit of the source up to date", func() {
+			_, err := chunked.ReadFrom(dst, src, 4, afterChunk)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(src.N).To(BeZero())
+		})
+	})
+
+	Context("when the callback fails", func() {
+		It("stops and returns the error", func() {
+			disaster := errors.New("boom")
+			n, err := chunked.ReadFrom(dst, strings.NewReader("0123456789"), 4, func(int64) error {
+				return disaster
+			})
+			Expect(err).To(Equal(disaster))
+			Expect(n).To(BeEquivalentTo(4))
+		})
+	})
+})
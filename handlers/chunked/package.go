@@ -0,0 +1 @@
+package chunked // import "code.cloudfoundry.org/fileserver/handlers/chunked"
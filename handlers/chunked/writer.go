@@ -0,0 +1,45 @@
+package chunked
+
+import (
+	"io"
+	"net/http"
+)
+
+// Writer is embedded by the response writers the middleware wrap responses
+// in. It forwards the methods of the writer it wraps, and Unwrap lets
+// http.ResponseController reach that writer.
+type Writer struct {
+	http.ResponseWriter
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w Writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w Writer) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Copy is what the ReadFrom of a wrapper does. When the underlying writer is
+// an io.ReaderFrom, src is handed to it, chunkSize bytes at a time as
+// ReadFrom does or all at once when chunkSize is not positive, so that the
+// sendfile path stays available. Otherwise src is copied to dst through its
+// Write method alone, so that io.Copy does not call back into ReadFrom.
+func (w Writer) Copy(dst io.Writer, src io.Reader, chunkSize int64, afterChunk func(int64) error) (int64, error) {
+	rf, ok := w.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(writerOnly{dst}, src)
+	}
+	if chunkSize <= 0 {
+		return rf.ReadFrom(src)
+	}
+	return ReadFrom(rf, src, chunkSize, afterChunk)
+}
+
+// writerOnly hides every method but Write.
+type writerOnly struct {
+	io.Writer
+}
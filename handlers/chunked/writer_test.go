@@ -0,0 +1,86 @@
+package chunked_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/fileserver/handlers/chunked"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// readingRecorder is a response writer that reads from the sources it is
+// given, as the writers of net/http do.
+type readingRecorder struct {
+	*httptest.ResponseRecorder
+	sources []io.Reader
+}
+
+func (r *readingRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.sources = append(r.sources, src)
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+// wrapper stands for the response writers of the middleware.
+type wrapper struct {
+	chunked.Writer
+	writes int
+}
+
+func (w *wrapper) Write(b []byte) (int, error) {
+	w.writes++
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *wrapper) ReadFrom(src io.Reader) (int64, error) {
+	return w.Copy(w, src, 4, func(int64) error { return nil })
+}
+
+var _ = Describe("Writer", func() {
+	It("lets http.ResponseController reach the writer it wraps", func() {
+		rec := httptest.NewRecorder()
+		w := &wrapper{Writer: chunked.Writer{ResponseWriter: rec}}
+
+		Expect(w.Unwrap()).To(BeIdenticalTo(rec))
+		Expect(http.NewResponseController(w).Flush()).To(Succeed())
+		Expect(rec.Flushed).To(BeTrue())
+	})
+
+	Describe("Copy", func() {
+		It("hands the source to the writer it wraps in chunks", func() {
+			rec := &readingRecorder{ResponseRecorder: httptest.NewRecorder()}
+			w := &wrapper{Writer: chunked.Writer{ResponseWriter: rec}}
+
+			n, err := w.ReadFrom(strings.NewReader("hello world"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(BeEquivalentTo(11))
+			Expect(rec.Body.String()).To(Equal("hello world"))
+			Expect(rec.sources).To(HaveLen(3))
+			Expect(w.writes).To(BeZero())
+		})
+
+		It("hands the whole source at once when there is no chunk size", func() {
+			rec := &readingRecorder{ResponseRecorder: httptest.NewRecorder()}
+			w := chunked.Writer{ResponseWriter: rec}
+
+			n, err := w.Copy(rec, strings.NewReader("hello world"), 0, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(BeEquivalentTo(11))
+			Expect(rec.sources).To(HaveLen(1))
+		})
+
+		It("writes to the wrapper when the writer it wraps cannot read", func() {
+			rec := httptest.NewRecorder()
+			w := &wrapper{Writer: chunked.Writer{ResponseWriter: rec}}
+
+			n, err := w.ReadFrom(strings.NewReader("hello world"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(BeEquivalentTo(11))
+			Expect(rec.Body.String()).To(Equal("hello world"))
+			Expect(w.writes).To(BeNumerically(">", 0))
+		})
+	})
+})
@@ -1,21 +1,49 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 
 	"code.cloudfoundry.org/fileserver"
+	"code.cloudfoundry.org/fileserver/handlers/health"
 	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/fileserver/handlers/tracing"
+	"code.cloudfoundry.org/fileserver/storage"
 	"code.cloudfoundry.org/lager"
 	"github.com/tedsuo/rata"
 )
 
-func New(staticDirectory string, logger lager.Logger) (http.Handler, error) {
+// New returns a handler for the routes of the given route groups.
+func New(routeGroups []string, store storage.Storage, digestCache *static.DigestCache, healthChecker *health.Checker, logger lager.Logger, observers []static.Observer, middleware ...func(http.Handler) http.Handler) (http.Handler, error) {
 	staticRoute, err := fileserver.Routes.CreatePathForRoute(fileserver.StaticRoute, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return rata.NewRouter(fileserver.Routes, rata.Handlers{
-		fileserver.StaticRoute: static.New(staticDirectory, staticRoute, logger),
-	})
+	allHandlers := rata.Handlers{
+		fileserver.StaticRoute:      static.New(store, staticRoute, digestCache, logger, observers, middleware...),
+		fileserver.HealthLiveRoute:  healthChecker.LiveHandler(),
+		fileserver.HealthReadyRoute: healthChecker.ReadyHandler(),
+	}
+
+	routes := rata.Routes{}
+	routeHandlers := rata.Handlers{}
+	for _, group := range routeGroups {
+		names, ok := fileserver.RouteGroups[group]
+		if !ok {
+			return nil, fmt.Errorf("unknown route group %q", group)
+		}
+		for _, name := range names {
+			route, _ := fileserver.Routes.FindRouteByName(name)
+			routes = append(routes, route)
+			routeHandlers[name] = allHandlers[name]
+		}
+	}
+
+	router, err := rata.NewRouter(routes, routeHandlers)
+	if err != nil {
+		return nil, err
+	}
+
+	return tracing.Wrap(router), nil
 }
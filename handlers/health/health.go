@@ -0,0 +1,236 @@
+package health
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/fileserver/storage"
+)
+
+// Config holds the thresholds of the readiness checks. A MinFreeDiskBytes of
+// zero skips the free disk space check.
+type Config struct {
+	MinFreeDiskBytes uint64 `json:"min_free_disk_bytes,omitempty"`
+}
+
+// DefaultCacheTTL is how long the result of an expensive check is reused.
+const DefaultCacheTTL = 5 * time.Second
+
+// A Check reports why the server is not ready to serve files, or nil.
+type Check struct {
+	Name string
+	Run  func() error
+}
+
+// Checker runs the readiness checks and serves their results.
+type Checker struct {
+	mu     sync.RWMutex
+	checks []Check
+}
+
+func NewChecker(checks ...Check) *Checker {
+	return &Checker{checks: checks}
+}
+
+// Add adds a check to the ones run from now on.
+func (c *Checker) Add(check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks = append(c.checks, check)
+}
+
+type Result struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Ready  bool     `json:"ready"`
+	Checks []Result `json:"checks"`
+}
+
+// Run runs every check and reports whether they all passed.
+func (c *Checker) Run() Report {
+	c.mu.RLock()
+	checks := c.checks
+	c.mu.RUnlock()
+
+	report := Report{Ready: true, Checks: make([]Result, 0, len(checks))}
+	for _, check := range checks {
+		result := Result{Name: check.Name, OK: true}
+		if err := check.Run(); err != nil {
+			result.OK = false
+			result.Error = err.Error()
+			report.Ready = false
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	return report
+}
+
+// LiveHandler responds 200 for as long as the process can serve requests at
+// all.
+func (c *Checker) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]bool{"live": true})
+	})
+}
+
+// ReadyHandler runs the checks and responds 200 when they all pass, 503
+// otherwise, listing the result of each.
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run()
+		status := http.StatusOK
+		if !report.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// StorageReadable checks that the root of store can be opened and is a
+// directory.
+func StorageReadable(store storage.Storage) Check {
+	return Check{
+		Name: "static-directory",
+		Run: func() error {
+			f, err := store.Open("/")
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			info, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return errors.New("the root of the storage is not a directory")
+			}
+			return nil
+		},
+	}
+}
+
+// FreeDiskSpace checks that the file system holding dir has at least
+// minBytes available.
+func FreeDiskSpace(dir string, minBytes uint64) Check {
+	return Check{
+		Name: "free-disk-space",
+		Run: func() error {
+			free, err := freeBytes(dir)
+			if err != nil {
+				return err
+			}
+			if free < minBytes {
+				return fmt.Errorf("%d bytes free, below the minimum of %d", free, minBytes)
+			}
+			return nil
+		},
+	}
+}
+
+// CertificateNotExpired checks that the certificate returned by current,
+// typically the one a tlsreload.Reloader serves, is within its validity
+// period.
+func CertificateNotExpired(current func() *x509.Certificate, clock clock.Clock) Check {
+	return Check{
+		Name: "tls-certificate",
+		Run: func() error {
+			cert := current()
+			now := clock.Now()
+			if now.After(cert.NotAfter) {
+				return fmt.Errorf("certificate expired at %s", cert.NotAfter)
+			}
+			if now.Before(cert.NotBefore) {
+				return fmt.Errorf("certificate is not valid before %s", cert.NotBefore)
+			}
+			return nil
+		},
+	}
+}
+
+// Cached returns check with its result reused for ttl, so that frequent
+// probes do not each pay for an expensive check such as listing a remote
+// bucket. Concurrent runs wait for the one in progress.
+func Cached(check Check, clock clock.Clock, ttl time.Duration) Check {
+	var (
+		mu      sync.Mutex
+		err     error
+		expires time.Time
+	)
+
+	return Check{
+		Name: check.Name,
+		Run: func() error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if clock.Now().Before(expires) {
+				return err
+			}
+			err = check.Run()
+			expires = clock.Now().Add(ttl)
+			return err
+		},
+	}
+}
+
+// Gate is a check that fails until it is opened, for conditions such as a
+// warm-up that the server reaches once, and again once it is closed, with the
+// reason given.
+type Gate struct {
+	name string
+
+	mu     sync.RWMutex
+	open   bool
+	reason string
+}
+
+func NewGate(name string) *Gate {
+	return &Gate{name: name, reason: "not done yet"}
+}
+
+func (g *Gate) Open() {
+	g.mu.Lock()
+	g.open = true
+	g.mu.Unlock()
+}
+
+func (g *Gate) Close(reason string) {
+	g.mu.Lock()
+	g.open = false
+	g.reason = reason
+	g.mu.Unlock()
+}
+
+func (g *Gate) Check() Check {
+	return Check{
+		Name: g.name,
+		Run: func() error {
+			g.mu.RLock()
+			defer g.mu.RUnlock()
+
+			if !g.open {
+				return errors.New(g.reason)
+			}
+			return nil
+		},
+	}
+}
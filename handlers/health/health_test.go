@@ -0,0 +1,205 @@
+package health_test
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/fileserver/storage"
+	"code.cloudfoundry.org/tlsconfig/certtest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "health-test")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	Describe("Checker", func() {
+		var checker *health.Checker
+
+		get := func(handler http.Handler) (int, health.Report) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+
+			var report health.Report
+			Expect(json.Unmarshal(rec.Body.Bytes(), &report)).To(Succeed())
+			return rec.Code, report
+		}
+
+		BeforeEach(func() {
+			checker = health.NewChecker(health.Check{Name: "passing", Run: func() error { return nil }})
+		})
+
+		It("is ready when every check passes", func() {
+			status, report := get(checker.ReadyHandler())
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(report).To(Equal(health.Report{
+				Ready:  true,
+				Checks: []health.Result{{Name: "passing", OK: true}},
+			}))
+		})
+
+		It("is not ready when a check fails, and says why", func() {
+			checker.Add(health.Check{Name: "failing", Run: func() error { return errors.New("boom") }})
+
+			status, report := get(checker.ReadyHandler())
+			Expect(status).To(Equal(http.StatusServiceUnavailable))
+			Expect(report).To(Equal(health.Report{
+				Checks: []health.Result{
+					{Name: "passing", OK: true},
+					{Name: "failing", Error: "boom"},
+				},
+			}))
+		})
+
+		It("is live regardless of the checks", func() {
+			checker.Add(health.Check{Name: "failing", Run: func() error { return errors.New("boom") }})
+
+			rec := httptest.NewRecorder()
+			checker.LiveHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(MatchJSON(`{"live":true}`))
+		})
+	})
+
+	Describe("StorageReadable", func() {
+		It("passes for an empty directory", func() {
+			Expect(health.StorageReadable(storage.NewLocal(tmpDir)).Run()).To(Succeed())
+		})
+
+		It("passes for an empty storage", func() {
+			Expect(health.StorageReadable(storage.NewMemory(fakeclock.NewFakeClock(time.Now()))).Run()).To(Succeed())
+		})
+
+		It("fails for a missing directory", func() {
+			Expect(health.StorageReadable(storage.NewLocal(filepath.Join(tmpDir, "missing"))).Run()).NotTo(Succeed())
+		})
+
+		It("fails for a file", func() {
+			file := filepath.Join(tmpDir, "file")
+			Expect(ioutil.WriteFile(file, []byte("hello"), os.ModePerm)).To(Succeed())
+			Expect(health.StorageReadable(storage.NewLocal(file)).Run()).To(MatchError(ContainSubstring("is not a directory")))
+		})
+	})
+
+	Describe("FreeDiskSpace", func() {
+		It("passes when enough space is available", func() {
+			Expect(health.FreeDiskSpace(tmpDir, 1).Run()).To(Succeed())
+		})
+
+		It("fails when less space is available than required", func() {
+			Expect(health.FreeDiskSpace(tmpDir, 1<<62).Run()).To(MatchError(ContainSubstring("below the minimum")))
+		})
+	})
+
+	Describe("CertificateNotExpired", func() {
+		var (
+			cert      *x509.Certificate
+			fakeClock *fakeclock.FakeClock
+		)
+
+		current := func() *x509.Certificate {
+			return cert
+		}
+
+		BeforeEach(func() {
+			ca, err := certtest.BuildCA("test-ca")
+			Expect(err).NotTo(HaveOccurred())
+			built, err := ca.BuildSignedCertificateWithExpiry("server", time.Now().Add(time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			tlsCert, err := built.TLSCertificate()
+			Expect(err).NotTo(HaveOccurred())
+			cert, err = x509.ParseCertificate(tlsCert.Certificate[0])
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeClock = fakeclock.NewFakeClock(time.Now())
+		})
+
+		It("passes while the certificate is valid", func() {
+			Expect(health.CertificateNotExpired(current, fakeClock).Run()).To(Succeed())
+		})
+
+		It("fails once the certificate has expired", func() {
+			fakeClock.Increment(2 * time.Hour)
+			Expect(health.CertificateNotExpired(current, fakeClock).Run()).To(MatchError(ContainSubstring("certificate expired")))
+		})
+
+		It("checks the certificate current at each run", func() {
+			check := health.CertificateNotExpired(current, fakeClock)
+			Expect(check.Run()).To(Succeed())
+
+			cert = &x509.Certificate{NotAfter: fakeClock.Now().Add(-time.Minute)}
+			Expect(check.Run()).To(MatchError(ContainSubstring("certificate expired")))
+		})
+	})
+
+	Describe("Cached", func() {
+		var (
+			fakeClock *fakeclock.FakeClock
+			runs      int
+			result    error
+			check     health.Check
+		)
+
+		BeforeEach(func() {
+			fakeClock = fakeclock.NewFakeClock(time.Now())
+			runs = 0
+			result = nil
+			check = health.Cached(health.Check{Name: "expensive", Run: func() error {
+				runs++
+				return result
+			}}, fakeClock, 5*time.Second)
+		})
+
+		It("keeps the check's name", func() {
+			Expect(check.Name).To(Equal("expensive"))
+		})
+
+		It("reuses the result until the ttl has passed", func() {
+			Expect(check.Run()).To(Succeed())
+			result = errors.New("unreachable")
+			fakeClock.Increment(4 * time.Second)
+			Expect(check.Run()).To(Succeed())
+			Expect(runs).To(Equal(1))
+
+			fakeClock.Increment(time.Second)
+			Expect(check.Run()).To(MatchError("unreachable"))
+			Expect(runs).To(Equal(2))
+		})
+	})
+
+	Describe("Gate", func() {
+		It("fails until it is opened, and once closed", func() {
+			gate := health.NewGate("warm-up")
+			check := gate.Check()
+			Expect(check.Name).To(Equal("warm-up"))
+			Expect(check.Run()).To(MatchError("not done yet"))
+
+			gate.Open()
+			Expect(check.Run()).To(Succeed())
+
+			gate.Close("shutting down")
+			Expect(check.Run()).To(MatchError("shutting down"))
+		})
+	})
+})
@@ -0,0 +1 @@
+package health // import "code.cloudfoundry.org/fileserver/handlers/health"
@@ -0,0 +1,92 @@
+package inflight
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+// Tracker keeps the requests being served, so that the ones still in flight
+// when the server gives up draining its connections can be logged.
+type Tracker struct {
+	logger lager.Logger
+	clock  clock.Clock
+
+	mu       sync.Mutex
+	nextID   uint64
+	requests map[uint64]request
+}
+
+type request struct {
+	method     string
+	path       string
+	remoteAddr string
+	startedAt  time.Time
+}
+
+func New(logger lager.Logger, clock clock.Clock) *Tracker {
+	return &Tracker{
+		logger:   logger.Session("inflight"),
+		clock:    clock,
+		requests: map[uint64]request{},
+	}
+}
+
+// Wrap returns a handler that keeps track of the requests it serves.
+func (t *Tracker) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.mu.Lock()
+		id := t.nextID
+		t.nextID++
+		t.requests[id] = request{
+			method:     r.Method,
+			path:       r.URL.Path,
+			remoteAddr: r.RemoteAddr,
+			startedAt:  t.clock.Now(),
+		}
+		t.mu.Unlock()
+
+		defer func() {
+			t.mu.Lock()
+			delete(t.requests, id)
+			t.mu.Unlock()
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Len returns the number of requests in flight.
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.requests)
+}
+
+// LogInFlight logs every request in flight, oldest first.
+func (t *Tracker) LogInFlight() {
+	t.mu.Lock()
+	requests := make([]request, 0, len(t.requests))
+	for _, r := range t.requests {
+		requests = append(requests, r)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].startedAt.Before(requests[j].startedAt)
+	})
+
+	now := t.clock.Now()
+	for _, r := range requests {
+		t.logger.Info("request-in-flight", lager.Data{
+			"method":      r.method,
+			"path":        r.path,
+			"remote-addr": r.remoteAddr,
+			"duration":    now.Sub(r.startedAt).String(),
+		})
+	}
+}
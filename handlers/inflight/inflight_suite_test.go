@@ -0,0 +1,13 @@
+package inflight_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestInflight(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Inflight Suite")
+}
@@ -0,0 +1,74 @@
+package inflight_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/fileserver/handlers/inflight"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracker", func() {
+	var (
+		logger    *lagertest.TestLogger
+		fakeClock *fakeclock.FakeClock
+		tracker   *inflight.Tracker
+		started   chan struct{}
+		release   chan struct{}
+		handler   http.Handler
+	)
+
+	serve := func(path string) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "10.0.0.1:5000"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		tracker = inflight.New(logger, fakeClock)
+
+		started = make(chan struct{}, 10)
+		release = make(chan struct{})
+		handler = tracker.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-release
+		}))
+	})
+
+	It("tracks the requests being served", func() {
+		go serve("/v1/static/a")
+		go serve("/v1/static/b")
+		Eventually(started).Should(Receive())
+		Eventually(started).Should(Receive())
+		Expect(tracker.Len()).To(Equal(2))
+
+		close(release)
+		Eventually(tracker.Len).Should(Equal(0))
+	})
+
+	It("logs the requests in flight", func() {
+		go serve("/v1/static/droplet.tgz")
+		Eventually(started).Should(Receive())
+		fakeClock.Increment(time.Minute)
+
+		tracker.LogInFlight()
+		Expect(logger.LogMessages()).To(Equal([]string{"test.inflight.request-in-flight"}))
+		Expect(logger.Logs()[0].Data).To(Equal(lager.Data{
+			"session":     "1",
+			"method":      "GET",
+			"path":        "/v1/static/droplet.tgz",
+			"remote-addr": "10.0.0.1:5000",
+			"duration":    "1m0s",
+		}))
+
+		close(release)
+	})
+})
@@ -0,0 +1 @@
+package inflight // import "code.cloudfoundry.org/fileserver/handlers/inflight"
@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	loggingclient "code.cloudfoundry.org/diego-logging-client"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/lager"
+)
+
+const DefaultReportInterval = time.Minute
+
+const (
+	requestCountMetric        = "RequestCount"
+	responsesMetricFormat     = "Responses%dxx"
+	bytesServedMetric         = "BytesServed"
+	requestLatencyMaxMetric   = "RequestLatencyMax"
+	digestCacheHitsMetric     = "DigestCacheHits"
+	digestCacheMissesMetric   = "DigestCacheMisses"
+	digestCacheHitRatioMetric = "DigestCacheHitRatio"
+	downloadsInFlightMetric   = "DownloadsInFlight"
+)
+
+// Names of the values read from other components and registered with
+// ReportCounter and ReportGauge.
+const (
+	RateLimitedReadsMetric      = "RateLimitedReads"
+	RateLimitedWritesMetric     = "RateLimitedWrites"
+	AdmissionInFlightMetric     = "AdmissionInFlight"
+	AdmissionQueuedMetric       = "AdmissionQueued"
+	AdmissionRejectionsMetric   = "AdmissionRejections"
+	SlowTransferEvictionsMetric = "SlowTransferEvictions"
+)
+
+// Notifier counts the requests served by the static handler and emits the
+// counts through Loggregator every report interval. Counters are sent as
+// deltas since the previous report; RequestLatencyMax is the slowest request
+// of the interval and DigestCacheHitRatio covers the interval's lookups.
+// Values kept by other components are added with ReportCounter and
+// ReportGauge.
+type Notifier struct {
+	logger   lager.Logger
+	clock    clock.Clock
+	interval time.Duration
+	client   loggingclient.IngressClient
+	inFlight func() int
+
+	mu       sync.Mutex
+	stats    stats
+	counters []*counter
+	gauges   []gauge
+}
+
+type counter struct {
+	metric   string
+	value    func() uint64
+	reported uint64
+}
+
+type gauge struct {
+	metric string
+	value  func() int64
+}
+
+type stats struct {
+	requests      uint64
+	statusClasses [6]uint64
+	bytesServed   uint64
+	latencyMax    time.Duration
+	digestHits    uint64
+	digestMisses  uint64
+}
+
+// New returns a Notifier that reports inFlight as the number of downloads in
+// flight.
+func New(logger lager.Logger, clock clock.Clock, interval time.Duration, client loggingclient.IngressClient, inFlight func() int) *Notifier {
+	if interval <= 0 {
+		interval = DefaultReportInterval
+	}
+
+	return &Notifier{
+		logger:   logger.Session("metrics-notifier"),
+		clock:    clock,
+		interval: interval,
+		client:   client,
+		inFlight: inFlight,
+	}
+}
+
+// ReportCounter adds a counter that is read every report interval and
+// emitted as the delta since the previous report.
+func (n *Notifier) ReportCounter(metric string, value func() uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.counters = append(n.counters, &counter{metric: metric, value: value, reported: value()})
+}
+
+// ReportGauge adds a value that is read and emitted every report interval.
+func (n *Notifier) ReportGauge(metric string, value func() int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.gauges = append(n.gauges, gauge{metric: metric, value: value})
+}
+
+func (n *Notifier) ObserveResponse(res static.Response) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.stats.requests++
+	if class := res.Status / 100; class >= 1 && class <= 5 {
+		n.stats.statusClasses[class]++
+	}
+	if res.Size > 0 {
+		n.stats.bytesServed += uint64(res.Size)
+	}
+	if res.Duration > n.stats.latencyMax {
+		n.stats.latencyMax = res.Duration
+	}
+	if res.DigestComputed {
+		if res.DigestCached {
+			n.stats.digestHits++
+		} else {
+			n.stats.digestMisses++
+		}
+	}
+}
+
+func (n *Notifier) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := n.clock.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	for {
+		select {
+		case <-ticker.C():
+			n.emit()
+		case <-signals:
+			return nil
+		}
+	}
+}
+
+func (n *Notifier) emit() {
+	n.mu.Lock()
+	s := n.stats
+	n.stats = stats{}
+	deltas := make([]uint64, len(n.counters))
+	for i, c := range n.counters {
+		value := c.value()
+		deltas[i] = value - c.reported
+		c.reported = value
+	}
+	counters := n.counters
+	gauges := n.gauges
+	n.mu.Unlock()
+
+	logger := n.logger.Session("emit")
+
+	send := func(metric string, err error) {
+		if err != nil {
+			logger.Error("failed-to-send-metric", err, lager.Data{"metric": metric})
+		}
+	}
+
+	send(requestCountMetric, n.client.IncrementCounterWithDelta(requestCountMetric, s.requests))
+	for class := 1; class <= 5; class++ {
+		metric := fmt.Sprintf(responsesMetricFormat, class)
+		send(metric, n.client.IncrementCounterWithDelta(metric, s.statusClasses[class]))
+	}
+	send(bytesServedMetric, n.client.IncrementCounterWithDelta(bytesServedMetric, s.bytesServed))
+	send(requestLatencyMaxMetric, n.client.SendDuration(requestLatencyMaxMetric, s.latencyMax))
+	send(digestCacheHitsMetric, n.client.IncrementCounterWithDelta(digestCacheHitsMetric, s.digestHits))
+	send(digestCacheMissesMetric, n.client.IncrementCounterWithDelta(digestCacheMissesMetric, s.digestMisses))
+	if lookups := s.digestHits + s.digestMisses; lookups > 0 {
+		ratio := float64(s.digestHits) / float64(lookups)
+		send(digestCacheHitRatioMetric, n.client.SendComponentMetric(digestCacheHitRatioMetric, ratio, "ratio"))
+	}
+	for i, c := range counters {
+		send(c.metric, n.client.IncrementCounterWithDelta(c.metric, deltas[i]))
+	}
+	for _, g := range gauges {
+		send(g.metric, n.client.SendMetric(g.metric, int(g.value())))
+	}
+	send(downloadsInFlightMetric, n.client.SendMetric(downloadsInFlightMetric, n.inFlight()))
+}
@@ -0,0 +1,162 @@
+package metrics_test
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	mfakes "code.cloudfoundry.org/diego-logging-client/testhelpers"
+	"code.cloudfoundry.org/fileserver/handlers/metrics"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Notifier", func() {
+	var (
+		logger           *lagertest.TestLogger
+		fakeClock        *fakeclock.FakeClock
+		fakeMetronClient *mfakes.FakeIngressClient
+		notifier         *metrics.Notifier
+		process          ifrit.Process
+		inFlight         int64
+	)
+
+	counters := func() map[string]uint64 {
+		values := map[string]uint64{}
+		for i := 0; i < fakeMetronClient.IncrementCounterWithDeltaCallCount(); i++ {
+			name, delta := fakeMetronClient.IncrementCounterWithDeltaArgsForCall(i)
+			values[name] += delta
+		}
+		return values
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		fakeMetronClient = new(mfakes.FakeIngressClient)
+		atomic.StoreInt64(&inFlight, 0)
+		notifier = metrics.New(logger, fakeClock, time.Minute, fakeMetronClient, func() int {
+			return int(atomic.LoadInt64(&inFlight))
+		})
+		process = ifrit.Invoke(notifier)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("does not emit anything before the report interval elapses", func() {
+		notifier.ObserveResponse(static.Response{Status: http.StatusOK})
+		fakeClock.WaitForWatcherAndIncrement(59 * time.Second)
+		Consistently(fakeMetronClient.IncrementCounterWithDeltaCallCount).Should(BeZero())
+	})
+
+	Context("when responses have been observed", func() {
+		BeforeEach(func() {
+			notifier.ObserveResponse(static.Response{Status: http.StatusOK, Size: 1000, Duration: time.Second, DigestComputed: true, DigestCached: true})
+			notifier.ObserveResponse(static.Response{Status: http.StatusPartialContent, Size: 24, Duration: 3 * time.Second, DigestComputed: true, DigestCached: true})
+			notifier.ObserveResponse(static.Response{Status: http.StatusNotModified, Duration: time.Millisecond, DigestComputed: true})
+			notifier.ObserveResponse(static.Response{Status: http.StatusNotFound, Duration: time.Millisecond})
+			notifier.ObserveResponse(static.Response{Status: http.StatusServiceUnavailable, Duration: time.Millisecond})
+
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(fakeMetronClient.SendMetricCallCount).Should(Equal(1))
+		})
+
+		It("emits the request counts by status class and the bytes served", func() {
+			Expect(counters()).To(Equal(map[string]uint64{
+				"RequestCount":      5,
+				"Responses1xx":      0,
+				"Responses2xx":      2,
+				"Responses3xx":      1,
+				"Responses4xx":      1,
+				"Responses5xx":      1,
+				"BytesServed":       1024,
+				"DigestCacheHits":   2,
+				"DigestCacheMisses": 1,
+			}))
+		})
+
+		It("emits the slowest request of the interval", func() {
+			Expect(fakeMetronClient.SendDurationCallCount()).To(Equal(1))
+			name, value, _ := fakeMetronClient.SendDurationArgsForCall(0)
+			Expect(name).To(Equal("RequestLatencyMax"))
+			Expect(value).To(Equal(3 * time.Second))
+		})
+
+		It("emits the digest cache hit ratio", func() {
+			Expect(fakeMetronClient.SendComponentMetricCallCount()).To(Equal(1))
+			name, value, unit := fakeMetronClient.SendComponentMetricArgsForCall(0)
+			Expect(name).To(Equal("DigestCacheHitRatio"))
+			Expect(value).To(BeNumerically("~", 2.0/3.0))
+			Expect(unit).To(Equal("ratio"))
+		})
+
+		It("starts counting again after each report", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(fakeMetronClient.SendMetricCallCount).Should(Equal(2))
+
+			Expect(counters()["RequestCount"]).To(BeEquivalentTo(5))
+			name, value, _ := fakeMetronClient.SendDurationArgsForCall(1)
+			Expect(name).To(Equal("RequestLatencyMax"))
+			Expect(value).To(BeZero())
+			Expect(fakeMetronClient.SendComponentMetricCallCount()).To(Equal(1))
+		})
+	})
+
+	It("emits the number of downloads in flight", func() {
+		atomic.StoreInt64(&inFlight, 2)
+
+		fakeClock.WaitForWatcherAndIncrement(time.Minute)
+		Eventually(fakeMetronClient.SendMetricCallCount).Should(Equal(1))
+		name, value, _ := fakeMetronClient.SendMetricArgsForCall(0)
+		Expect(name).To(Equal("DownloadsInFlight"))
+		Expect(value).To(Equal(2))
+	})
+
+	It("emits the counters of other components as deltas", func() {
+		var rejections uint64 = 4
+		notifier.ReportCounter("RateLimitedReads", func() uint64 { return rejections })
+
+		rejections = 7
+		fakeClock.WaitForWatcherAndIncrement(time.Minute)
+		Eventually(fakeMetronClient.SendMetricCallCount).Should(Equal(1))
+		Expect(counters()["RateLimitedReads"]).To(BeEquivalentTo(3))
+
+		rejections = 10
+		fakeClock.WaitForWatcherAndIncrement(time.Minute)
+		Eventually(fakeMetronClient.SendMetricCallCount).Should(Equal(2))
+		Expect(counters()["RateLimitedReads"]).To(BeEquivalentTo(6))
+	})
+
+	It("emits the gauges of other components", func() {
+		notifier.ReportGauge("AdmissionQueued", func() int64 { return 3 })
+
+		fakeClock.WaitForWatcherAndIncrement(time.Minute)
+		Eventually(fakeMetronClient.SendMetricCallCount).Should(Equal(2))
+		name, value, _ := fakeMetronClient.SendMetricArgsForCall(0)
+		Expect(name).To(Equal("AdmissionQueued"))
+		Expect(value).To(Equal(3))
+	})
+
+	Context("when the client fails to send a metric", func() {
+		BeforeEach(func() {
+			fakeMetronClient.SendDurationReturns(errors.New("boom"))
+		})
+
+		It("logs the error and keeps reporting", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(logger).Should(gbytes.Say("test.metrics-notifier.emit.failed-to-send-metric"))
+			Consistently(process.Wait()).ShouldNot(Receive())
+		})
+	})
+})
@@ -0,0 +1 @@
+package metrics // import "code.cloudfoundry.org/fileserver/handlers/metrics"
@@ -0,0 +1,255 @@
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/durationjson"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	DefaultServiceName   = "file-server"
+	DefaultBatchSize     = 512
+	DefaultFlushInterval = 5 * time.Second
+
+	exportTimeout   = 10 * time.Second
+	scopeName       = "code.cloudfoundry.org/fileserver"
+	spanKindServer  = 2
+	statusCodeError = 2
+)
+
+// Config points the exporter at an OTLP/HTTP collector, such as
+// http://localhost:4318. An empty Endpoint disables the export.
+type Config struct {
+	Endpoint      string                `json:"endpoint,omitempty"`
+	ServiceName   string                `json:"service_name,omitempty"`
+	BatchSize     int                   `json:"batch_size,omitempty"`
+	FlushInterval durationjson.Duration `json:"flush_interval,omitempty"`
+}
+
+// Exporter turns sampled responses into server spans and sends them to the
+// collector in batches, using the JSON encoding of OTLP/HTTP. It is a
+// static.Observer and an ifrit.Runner; spans observed while it is not running,
+// or faster than it can send them, are dropped.
+type Exporter struct {
+	logger        lager.Logger
+	clock         clock.Clock
+	client        *http.Client
+	url           string
+	serviceName   string
+	batchSize     int
+	flushInterval time.Duration
+
+	spans   chan span
+	dropped uint64
+}
+
+func New(logger lager.Logger, clock clock.Clock, config Config) *Exporter {
+	e := &Exporter{
+		logger:        logger.Session("otlp-exporter", lager.Data{"endpoint": config.Endpoint}),
+		clock:         clock,
+		client:        &http.Client{Timeout: exportTimeout},
+		url:           strings.TrimSuffix(config.Endpoint, "/") + "/v1/traces",
+		serviceName:   config.ServiceName,
+		batchSize:     config.BatchSize,
+		flushInterval: time.Duration(config.FlushInterval),
+	}
+	if e.serviceName == "" {
+		e.serviceName = DefaultServiceName
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = DefaultBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = DefaultFlushInterval
+	}
+	e.spans = make(chan span, 4*e.batchSize)
+	return e
+}
+
+// Dropped returns how many spans were discarded because the queue was full.
+func (e *Exporter) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+func (e *Exporter) ObserveResponse(res static.Response) {
+	if res.Trace.TraceID == "" || !res.Trace.Sampled {
+		return
+	}
+
+	select {
+	case e.spans <- newSpan(res):
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+func (e *Exporter) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := e.clock.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	batch := make([]span, 0, e.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			e.logger.Error("failed-to-export-spans", err, lager.Data{"spans": len(batch)})
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) >= e.batchSize {
+				flush()
+			}
+		case <-ticker.C():
+			flush()
+		case <-signals:
+			e.drain(&batch)
+			flush()
+			return nil
+		}
+	}
+}
+
+// drain moves the spans still queued into the batch, so that they are sent
+// before the exporter exits.
+func (e *Exporter) drain(batch *[]span) {
+	for {
+		select {
+		case s := <-e.spans:
+			*batch = append(*batch, s)
+		default:
+			return
+		}
+	}
+}
+
+func (e *Exporter) export(spans []span) error {
+	body, err := json.Marshal(exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{Attributes: []attribute{stringAttribute("service.name", e.serviceName)}},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: scopeName},
+				Spans: spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// The types below follow the JSON mapping of the OTLP trace protobufs, in
+// which 64-bit integers are strings and IDs are hex rather than base64.
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type span struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes"`
+	Status            spanStatus  `json:"status"`
+}
+
+type spanStatus struct {
+	Code int `json:"code,omitempty"`
+}
+
+type attribute struct {
+	Key   string         `json:"key"`
+	Value attributeValue `json:"value"`
+}
+
+type attributeValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func stringAttribute(key, value string) attribute {
+	return attribute{Key: key, Value: attributeValue{StringValue: &value}}
+}
+
+func intAttribute(key string, value int64) attribute {
+	s := strconv.FormatInt(value, 10)
+	return attribute{Key: key, Value: attributeValue{IntValue: &s}}
+}
+
+func newSpan(res static.Response) span {
+	s := span{
+		TraceID:           res.Trace.TraceID,
+		SpanID:            res.Trace.SpanID,
+		ParentSpanID:      res.Trace.ParentSpanID,
+		Name:              res.Method,
+		Kind:              spanKindServer,
+		StartTimeUnixNano: strconv.FormatInt(res.StartedAt.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(res.StartedAt.Add(res.Duration).UnixNano(), 10),
+		Attributes: []attribute{
+			stringAttribute("http.request.method", res.Method),
+			stringAttribute("url.path", res.URI),
+			intAttribute("http.response.status_code", int64(res.Status)),
+			intAttribute("http.response.body.size", res.Size),
+			stringAttribute("client.address", res.RemoteAddr),
+			stringAttribute("vcap.request_id", res.Trace.RequestID),
+		},
+	}
+	if res.UserAgent != "" {
+		s.Attributes = append(s.Attributes, stringAttribute("user_agent.original", res.UserAgent))
+	}
+	if res.Status >= http.StatusInternalServerError {
+		s.Status.Code = statusCodeError
+	}
+	return s
+}
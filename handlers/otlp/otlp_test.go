@@ -0,0 +1,216 @@
+package otlp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/durationjson"
+	"code.cloudfoundry.org/fileserver/handlers/otlp"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/fileserver/handlers/tracing"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+// exportRequest is the part of an OTLP/HTTP JSON request the collector
+// stand-in decodes.
+type exportRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []attribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []span `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type span struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes"`
+	Status            struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+type attribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+		IntValue    string `json:"intValue"`
+	} `json:"value"`
+}
+
+var _ = Describe("Exporter", func() {
+	var (
+		logger    *lagertest.TestLogger
+		fakeClock *fakeclock.FakeClock
+		collector *httptest.Server
+		requests  chan exportRequest
+		cfg       otlp.Config
+		exporter  *otlp.Exporter
+		process   ifrit.Process
+		startedAt time.Time
+	)
+
+	response := func(traceID string, sampled bool, status int) static.Response {
+		return static.Response{
+			StartedAt:  startedAt,
+			Duration:   2 * time.Second,
+			Method:     "GET",
+			URI:        "/v1/static/droplet.tgz",
+			Status:     status,
+			Size:       1024,
+			RemoteAddr: "10.0.0.1:54321",
+			Trace: tracing.Context{
+				RequestID:    "some-request-id",
+				TraceID:      traceID,
+				SpanID:       "00f067aa0ba902b7",
+				ParentSpanID: "e457b5a2e4d86bd1",
+				Sampled:      sampled,
+			},
+		}
+	}
+
+	spansOf := func(req exportRequest) []span {
+		Expect(req.ResourceSpans).To(HaveLen(1))
+		Expect(req.ResourceSpans[0].ScopeSpans).To(HaveLen(1))
+		return req.ResourceSpans[0].ScopeSpans[0].Spans
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		startedAt = time.Unix(1700000000, 0)
+		fakeClock = fakeclock.NewFakeClock(startedAt)
+
+		requests = make(chan exportRequest, 10)
+		collector = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Method).To(Equal("POST"))
+			Expect(r.URL.Path).To(Equal("/v1/traces"))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+
+			var req exportRequest
+			Expect(json.NewDecoder(r.Body).Decode(&req)).To(Succeed())
+			requests <- req
+		}))
+
+		cfg = otlp.Config{
+			Endpoint:      collector.URL,
+			BatchSize:     2,
+			FlushInterval: durationjson.Duration(time.Second),
+		}
+	})
+
+	JustBeforeEach(func() {
+		exporter = otlp.New(logger, fakeClock, cfg)
+		process = ifrit.Invoke(exporter)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+		collector.Close()
+	})
+
+	It("sends a full batch right away", func() {
+		exporter.ObserveResponse(response("4bf92f3577b34da6a3ce929d0e0e4736", true, http.StatusOK))
+		exporter.ObserveResponse(response("80f198ee56343ba864fe8b2a57d3eff7", true, http.StatusServiceUnavailable))
+
+		var req exportRequest
+		Eventually(requests).Should(Receive(&req))
+
+		Expect(req.ResourceSpans[0].Resource.Attributes).To(HaveLen(1))
+		Expect(req.ResourceSpans[0].Resource.Attributes[0].Key).To(Equal("service.name"))
+		Expect(req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue).To(Equal("file-server"))
+
+		spans := spansOf(req)
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[0].TraceID).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(spans[0].SpanID).To(Equal("00f067aa0ba902b7"))
+		Expect(spans[0].ParentSpanID).To(Equal("e457b5a2e4d86bd1"))
+		Expect(spans[0].Name).To(Equal("GET"))
+		Expect(spans[0].Kind).To(Equal(2))
+		Expect(spans[0].StartTimeUnixNano).To(Equal("1700000000000000000"))
+		Expect(spans[0].EndTimeUnixNano).To(Equal("1700000002000000000"))
+		Expect(spans[0].Status.Code).To(Equal(0))
+		Expect(spans[1].Status.Code).To(Equal(2))
+
+		attributes := map[string]string{}
+		for _, a := range spans[0].Attributes {
+			attributes[a.Key] = a.Value.StringValue + a.Value.IntValue
+		}
+		Expect(attributes).To(Equal(map[string]string{
+			"http.request.method":       "GET",
+			"url.path":                  "/v1/static/droplet.tgz",
+			"http.response.status_code": "200",
+			"http.response.body.size":   "1024",
+			"client.address":            "10.0.0.1:54321",
+			"vcap.request_id":           "some-request-id",
+		}))
+	})
+
+	It("sends a partial batch when the flush interval elapses", func() {
+		exporter.ObserveResponse(response("4bf92f3577b34da6a3ce929d0e0e4736", true, http.StatusOK))
+		Consistently(requests).ShouldNot(Receive())
+
+		fakeClock.WaitForWatcherAndIncrement(time.Second)
+
+		var req exportRequest
+		Eventually(requests).Should(Receive(&req))
+		Expect(spansOf(req)).To(HaveLen(1))
+	})
+
+	It("sends what is left when it is stopped", func() {
+		exporter.ObserveResponse(response("4bf92f3577b34da6a3ce929d0e0e4736", true, http.StatusOK))
+		process.Signal(os.Interrupt)
+
+		var req exportRequest
+		Eventually(requests).Should(Receive(&req))
+		Expect(spansOf(req)).To(HaveLen(1))
+	})
+
+	It("does not send unsampled or untraced responses", func() {
+		exporter.ObserveResponse(response("4bf92f3577b34da6a3ce929d0e0e4736", false, http.StatusOK))
+		exporter.ObserveResponse(response("", true, http.StatusOK))
+		exporter.ObserveResponse(response("80f198ee56343ba864fe8b2a57d3eff7", true, http.StatusOK))
+
+		fakeClock.WaitForWatcherAndIncrement(time.Second)
+
+		var req exportRequest
+		Eventually(requests).Should(Receive(&req))
+		spans := spansOf(req)
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].TraceID).To(Equal("80f198ee56343ba864fe8b2a57d3eff7"))
+	})
+
+	Context("when the collector fails", func() {
+		BeforeEach(func() {
+			collector.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			})
+		})
+
+		It("logs the error and keeps running", func() {
+			exporter.ObserveResponse(response("4bf92f3577b34da6a3ce929d0e0e4736", true, http.StatusOK))
+			exporter.ObserveResponse(response("80f198ee56343ba864fe8b2a57d3eff7", true, http.StatusOK))
+
+			Eventually(logger).Should(gbytes.Say("test.otlp-exporter.failed-to-export-spans"))
+			Consistently(process.Wait()).ShouldNot(Receive())
+		})
+	})
+})
@@ -0,0 +1 @@
+package otlp // import "code.cloudfoundry.org/fileserver/handlers/otlp"
@@ -0,0 +1 @@
+package prometheus // import "code.cloudfoundry.org/fileserver/handlers/prometheus"
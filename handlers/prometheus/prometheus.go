@@ -0,0 +1,297 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/fileserver/handlers/static"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+	digestDurationBuckets  = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60}
+)
+
+// MetricsServerConfig holds the address of the listener serving /metrics. An
+// empty address disables it.
+type MetricsServerConfig struct {
+	MetricsAddress string `json:"metrics_address,omitempty"`
+}
+
+// Collector keeps the metrics exposed in the Prometheus text format. It is a
+// static.Observer, and counts the connections of every listener it is given
+// to through ConnState. Values kept by other components are added with
+// Counter and Gauge and read on every scrape.
+type Collector struct {
+	openFiles       func() (int, error)
+	digestCacheSize func() int
+
+	mu               sync.Mutex
+	requestDurations map[requestLabels]*histogram
+	bytesServed      map[string]uint64
+	digestDurations  *histogram
+	connections      map[string]*connectionCount
+	external         []externalMetric
+}
+
+type externalMetric struct {
+	name       string
+	labels     string
+	help       string
+	metricType string
+	value      func() string
+}
+
+type requestLabels struct {
+	route  string
+	status int
+}
+
+type connectionCount struct {
+	open  int64
+	total uint64
+}
+
+// New returns a collector that reports the size of the digest cache as
+// digestCacheSize returns it at scrape time.
+func New(digestCacheSize func() int) *Collector {
+	return &Collector{
+		openFiles:        countOpenFiles,
+		digestCacheSize:  digestCacheSize,
+		requestDurations: map[requestLabels]*histogram{},
+		bytesServed:      map[string]uint64{},
+		digestDurations:  newHistogram(digestDurationBuckets),
+		connections:      map[string]*connectionCount{},
+	}
+}
+
+func (c *Collector) ObserveResponse(res static.Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	labels := requestLabels{route: res.Route, status: res.Status}
+	h, ok := c.requestDurations[labels]
+	if !ok {
+		h = newHistogram(requestDurationBuckets)
+		c.requestDurations[labels] = h
+	}
+	h.observe(res.Duration.Seconds())
+
+	if res.Size > 0 {
+		c.bytesServed[res.Route] += uint64(res.Size)
+	}
+
+	if res.DigestComputed && !res.DigestCached {
+		c.digestDurations.observe(res.DigestDuration.Seconds())
+	}
+}
+
+// Counter exposes a counter kept by another component. labels are written as
+// given, e.g. `class="read"`, and may be empty; counters sharing a name must
+// be added one after the other and share the first one's help text.
+func (c *Collector) Counter(name, labels, help string, value func() uint64) {
+	c.addExternal(externalMetric{name: name, labels: labels, help: help, metricType: "counter", value: func() string {
+		return strconv.FormatUint(value(), 10)
+	}})
+}
+
+// Gauge exposes a value kept by another component, such as a queue length.
+// labels are written as in Counter.
+func (c *Collector) Gauge(name, labels, help string, value func() int64) {
+	c.addExternal(externalMetric{name: name, labels: labels, help: help, metricType: "gauge", value: func() string {
+		return strconv.FormatInt(value(), 10)
+	}})
+}
+
+func (c *Collector) addExternal(metric externalMetric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.external = append(c.external, metric)
+}
+
+// ConnState returns a connection state hook for the listener with the given
+// name, to be installed with server.WithConnState.
+func (c *Collector) ConnState(listener string) func(net.Conn, http.ConnState) {
+	c.mu.Lock()
+	count, ok := c.connections[listener]
+	if !ok {
+		count = &connectionCount{}
+		c.connections[listener] = count
+	}
+	c.mu.Unlock()
+
+	return func(_ net.Conn, state http.ConnState) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		switch state {
+		case http.StateNew:
+			count.open++
+			count.total++
+		case http.StateClosed, http.StateHijacked:
+			count.open--
+		}
+	}
+}
+
+// ServeHTTP writes every metric in the text exposition format. The metrics
+// are rendered before anything is sent, so that a slow scraper does not hold
+// up the responses and connections being counted.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var out bytes.Buffer
+	external := c.writeCollected(&out)
+
+	for i, metric := range external {
+		if i == 0 || external[i-1].name != metric.name {
+			writeHeader(&out, metric.name, metric.metricType, metric.help)
+		}
+		labels := metric.labels
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+		fmt.Fprintf(&out, "%s%s %s\n", metric.name, labels, metric.value())
+	}
+
+	writeHeader(&out, "fileserver_digest_cache_entries", "gauge", "Number of file digests cached.")
+	fmt.Fprintf(&out, "fileserver_digest_cache_entries %d\n", c.digestCacheSize())
+
+	if openFiles, err := c.openFiles(); err == nil {
+		writeHeader(&out, "process_open_fds", "gauge", "Number of open file descriptors.")
+		fmt.Fprintf(&out, "process_open_fds %d\n", openFiles)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(out.Bytes())
+}
+
+// writeCollected writes the metrics the collector keeps itself, and returns
+// the metrics added by other components, whose values are read once it has
+// let go of the lock.
+func (c *Collector) writeCollected(out io.Writer) []externalMetric {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(out, "fileserver_request_duration_seconds", "histogram", "Time taken to serve requests, by route and status.")
+	requests := make([]requestLabels, 0, len(c.requestDurations))
+	for labels := range c.requestDurations {
+		requests = append(requests, labels)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].route != requests[j].route {
+			return requests[i].route < requests[j].route
+		}
+		return requests[i].status < requests[j].status
+	})
+	for _, labels := range requests {
+		c.requestDurations[labels].write(out, "fileserver_request_duration_seconds",
+			fmt.Sprintf(`route="%s",status="%d"`, escape(labels.route), labels.status))
+	}
+
+	writeHeader(out, "fileserver_response_bytes_total", "counter", "Bytes of response bodies served, by route.")
+	for _, route := range sortedKeys(c.bytesServed) {
+		fmt.Fprintf(out, "fileserver_response_bytes_total{route=\"%s\"} %d\n", escape(route), c.bytesServed[route])
+	}
+
+	writeHeader(out, "fileserver_digest_computation_seconds", "histogram", "Time taken to compute the digest of files missing from the cache.")
+	c.digestDurations.write(out, "fileserver_digest_computation_seconds", "")
+
+	listeners := make([]string, 0, len(c.connections))
+	for listener := range c.connections {
+		listeners = append(listeners, listener)
+	}
+	sort.Strings(listeners)
+
+	writeHeader(out, "fileserver_open_connections", "gauge", "Number of open client connections, by listener.")
+	for _, listener := range listeners {
+		fmt.Fprintf(out, "fileserver_open_connections{listener=\"%s\"} %d\n", escape(listener), c.connections[listener].open)
+	}
+
+	writeHeader(out, "fileserver_connections_total", "counter", "Number of client connections accepted, by listener.")
+	for _, listener := range listeners {
+		fmt.Fprintf(out, "fileserver_connections_total{listener=\"%s\"} %d\n", escape(listener), c.connections[listener].total)
+	}
+
+	return append([]externalMetric(nil), c.external...)
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(out io.Writer, name, labels string) {
+	prefix := labels
+	if prefix != "" {
+		prefix += ","
+	}
+
+	for i, bound := range h.bounds {
+		fmt.Fprintf(out, "%s_bucket{%sle=\"%s\"} %d\n", name, prefix, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(out, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, h.count)
+
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(out, "%s_sum%s %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(out, "%s_count%s %d\n", name, labels, h.count)
+}
+
+func writeHeader(out io.Writer, name, metricType, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countOpenFiles counts the file descriptors of the process. It only works
+// where /proc is available.
+func countOpenFiles() (int, error) {
+	entries, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
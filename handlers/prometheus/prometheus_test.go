@@ -0,0 +1,160 @@
+package prometheus_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/prometheus"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Collector", func() {
+	var (
+		collector       *prometheus.Collector
+		digestCacheSize int
+	)
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		collector.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+		return rec.Body.String()
+	}
+
+	BeforeEach(func() {
+		digestCacheSize = 0
+		collector = prometheus.New(func() int { return digestCacheSize })
+	})
+
+	It("exposes request durations by route and status", func() {
+		collector.ObserveResponse(static.Response{Route: "/v1/static/", Status: 200, Duration: 20 * time.Millisecond})
+		collector.ObserveResponse(static.Response{Route: "/v1/static/", Status: 200, Duration: 3 * time.Second})
+		collector.ObserveResponse(static.Response{Route: "/v1/static/", Status: 404, Duration: time.Millisecond})
+
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring("# TYPE fileserver_request_duration_seconds histogram\n"))
+		Expect(metrics).To(ContainSubstring(`fileserver_request_duration_seconds_bucket{route="/v1/static/",status="200",le="0.01"} 0` + "\n"))
+		Expect(metrics).To(ContainSubstring(`fileserver_request_duration_seconds_bucket{route="/v1/static/",status="200",le="0.025"} 1` + "\n"))
+		Expect(metrics).To(ContainSubstring(`fileserver_request_duration_seconds_bucket{route="/v1/static/",status="200",le="5"} 2` + "\n"))
+		Expect(metrics).To(ContainSubstring(`fileserver_request_duration_seconds_bucket{route="/v1/static/",status="200",le="+Inf"} 2` + "\n"))
+		Expect(metrics).To(ContainSubstring(`fileserver_request_duration_seconds_sum{route="/v1/static/",status="200"} 3.02` + "\n"))
+		Expect(metrics).To(ContainSubstring(`fileserver_request_duration_seconds_count{route="/v1/static/",status="200"} 2` + "\n"))
+		Expect(metrics).To(ContainSubstring(`fileserver_request_duration_seconds_count{route="/v1/static/",status="404"} 1` + "\n"))
+	})
+
+	It("exposes the bytes served by route", func() {
+		collector.ObserveResponse(static.Response{Route: "/v1/static/", Status: 200, Size: 1000})
+		collector.ObserveResponse(static.Response{Route: "/v1/static/", Status: 206, Size: 24})
+
+		Expect(scrape()).To(ContainSubstring(`fileserver_response_bytes_total{route="/v1/static/"} 1024` + "\n"))
+	})
+
+	It("exposes the digest computation time and the cache size", func() {
+		collector.ObserveResponse(static.Response{DigestComputed: true, DigestDuration: 200 * time.Millisecond, DigestCacheSize: 1})
+		collector.ObserveResponse(static.Response{DigestComputed: true, DigestCached: true, DigestCacheSize: 2})
+		digestCacheSize = 2
+
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring(`fileserver_digest_computation_seconds_bucket{le="0.1"} 0` + "\n"))
+		Expect(metrics).To(ContainSubstring(`fileserver_digest_computation_seconds_bucket{le="0.5"} 1` + "\n"))
+		Expect(metrics).To(ContainSubstring("fileserver_digest_computation_seconds_count 1\n"))
+		Expect(metrics).To(ContainSubstring("fileserver_digest_cache_entries 2\n"))
+	})
+
+	It("reads the size of the digest cache when scraped", func() {
+		digestCacheSize = 5
+		Expect(scrape()).To(ContainSubstring("fileserver_digest_cache_entries 5\n"))
+
+		digestCacheSize = 3
+		Expect(scrape()).To(ContainSubstring("fileserver_digest_cache_entries 3\n"))
+	})
+
+	It("does not hold up the responses it counts while reading other components' values", func() {
+		reading := make(chan struct{})
+		proceed := make(chan struct{})
+		collector.Gauge("fileserver_slow_gauge", "", "A value that is slow to read.", func() int64 {
+			close(reading)
+			<-proceed
+			return 1
+		})
+
+		scraped := make(chan string)
+		go func() {
+			defer GinkgoRecover()
+			scraped <- scrape()
+		}()
+		Eventually(reading).Should(BeClosed())
+
+		observed := make(chan struct{})
+		go func() {
+			collector.ObserveResponse(static.Response{Route: "/v1/static/", Status: 200})
+			close(observed)
+		}()
+		Eventually(observed).Should(BeClosed())
+
+		close(proceed)
+		Eventually(scraped).Should(Receive(ContainSubstring("fileserver_slow_gauge 1\n")))
+	})
+
+	It("exposes the counters added by other components", func() {
+		var reads, writes uint64 = 3, 0
+		collector.Counter("fileserver_rate_limited_requests_total", `class="read"`, "Requests turned away by the rate limiter, by class.", func() uint64 { return reads })
+		collector.Counter("fileserver_rate_limited_requests_total", `class="write"`, "", func() uint64 { return writes })
+
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring("# HELP fileserver_rate_limited_requests_total Requests turned away by the rate limiter, by class.\n"))
+		Expect(metrics).To(ContainSubstring("# TYPE fileserver_rate_limited_requests_total counter\n"))
+		Expect(metrics).To(ContainSubstring(`fileserver_rate_limited_requests_total{class="read"} 3` + "\n"))
+		Expect(metrics).To(ContainSubstring(`fileserver_rate_limited_requests_total{class="write"} 0` + "\n"))
+		Expect(strings.Count(metrics, "# TYPE fileserver_rate_limited_requests_total")).To(Equal(1))
+
+		writes = 2
+		Expect(scrape()).To(ContainSubstring(`fileserver_rate_limited_requests_total{class="write"} 2` + "\n"))
+	})
+
+	It("exposes the gauges added by other components", func() {
+		var queued int64 = 2
+		collector.Gauge("fileserver_admission_queued_requests", "", "Requests waiting for a slot.", func() int64 { return queued })
+
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring("# TYPE fileserver_admission_queued_requests gauge\n"))
+		Expect(metrics).To(ContainSubstring("fileserver_admission_queued_requests 2\n"))
+
+		queued = 0
+		Expect(scrape()).To(ContainSubstring("fileserver_admission_queued_requests 0\n"))
+	})
+
+	It("exposes the number of open files", func() {
+		if _, err := ioutil.ReadDir("/proc/self/fd"); err != nil {
+			Skip("/proc is not available")
+		}
+		Expect(scrape()).To(MatchRegexp(`(?m)^process_open_fds [1-9][0-9]*$`))
+	})
+
+	It("exposes the connections of each listener", func() {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.Config.ConnState = collector.ConnState("https")
+		server.Start()
+		defer server.Close()
+		collector.ConnState("http")
+
+		client := &http.Client{Transport: &http.Transport{}}
+		resp, err := client.Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+
+		Eventually(scrape).Should(ContainSubstring(`fileserver_open_connections{listener="https"} 1` + "\n"))
+		Expect(scrape()).To(ContainSubstring(`fileserver_open_connections{listener="http"} 0` + "\n"))
+		Expect(scrape()).To(ContainSubstring(`fileserver_connections_total{listener="https"} 1` + "\n"))
+
+		client.Transport.(*http.Transport).CloseIdleConnections()
+		Eventually(scrape).Should(ContainSubstring(`fileserver_open_connections{listener="https"} 0` + "\n"))
+	})
+})
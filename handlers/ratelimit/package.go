@@ -0,0 +1 @@
+package ratelimit // import "code.cloudfoundry.org/fileserver/handlers/ratelimit"
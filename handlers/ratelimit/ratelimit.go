@@ -0,0 +1,179 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/fileserver/tokenbucket"
+	"code.cloudfoundry.org/lager"
+)
+
+// pruneInterval is how often buckets that have refilled completely are
+// dropped so that clients that went away do not accumulate in memory.
+const pruneInterval = time.Minute
+
+// Config holds the per-client request rates. A rate of zero disables limiting
+// for that class of request.
+type Config struct {
+	ReadRequestsPerSecond  float64 `json:"read_requests_per_second,omitempty"`
+	ReadBurst              int     `json:"read_burst,omitempty"`
+	WriteRequestsPerSecond float64 `json:"write_requests_per_second,omitempty"`
+	WriteBurst             int     `json:"write_burst,omitempty"`
+}
+
+type Limiter struct {
+	logger lager.Logger
+	clock  clock.Clock
+
+	readRejections  uint64
+	writeRejections uint64
+
+	reads  *class
+	writes *class
+}
+
+type class struct {
+	name       string
+	rejections *uint64
+
+	mu        sync.Mutex
+	rate      float64
+	burst     int
+	buckets   map[string]*tokenbucket.Bucket
+	lastPrune time.Time
+}
+
+func New(logger lager.Logger, clock clock.Clock, config Config) *Limiter {
+	now := clock.Now()
+	l := &Limiter{
+		logger: logger.Session("rate-limiter"),
+		clock:  clock,
+	}
+	l.reads = newClass("read", &l.readRejections, now)
+	l.writes = newClass("write", &l.writeRejections, now)
+	l.Update(config)
+	return l
+}
+
+// Update applies new rates. Clients keep what they have used of their
+// allowance, which refills at the new rate up to the new burst.
+func (l *Limiter) Update(config Config) {
+	l.reads.update(config.ReadRequestsPerSecond, config.ReadBurst)
+	l.writes.update(config.WriteRequestsPerSecond, config.WriteBurst)
+}
+
+func newClass(name string, rejections *uint64, now time.Time) *class {
+	return &class{
+		name:       name,
+		rejections: rejections,
+		buckets:    map[string]*tokenbucket.Bucket{},
+		lastPrune:  now,
+	}
+}
+
+func (c *class) update(rate float64, burst int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rate, c.burst = rate, burst
+	if rate <= 0 {
+		c.buckets = map[string]*tokenbucket.Bucket{}
+		return
+	}
+	for _, bucket := range c.buckets {
+		bucket.SetRate(rate, burst)
+	}
+}
+
+// Wrap returns a handler that rejects requests with 429 Too Many Requests
+// once the requesting client has used up its allowance.
+func (l *Limiter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := l.classFor(r)
+		client := ClientID(r)
+		ok, wait := c.take(l.clock, client)
+		if !ok {
+			atomic.AddUint64(c.rejections, 1)
+			l.logger.Debug("rejected", lager.Data{"client": client, "class": c.name, "retry-after": wait.String()})
+
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Rejections returns the number of read and write requests that have been
+// turned away since the limiter was created.
+func (l *Limiter) Rejections() (reads, writes uint64) {
+	return atomic.LoadUint64(&l.readRejections), atomic.LoadUint64(&l.writeRejections)
+}
+
+func (l *Limiter) classFor(r *http.Request) *class {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return l.reads
+	default:
+		return l.writes
+	}
+}
+
+// take spends one request of the client's allowance, and always succeeds
+// when the class is not limited.
+func (c *class) take(clock clock.Clock, client string) (bool, time.Duration) {
+	c.mu.Lock()
+	if c.rate <= 0 {
+		c.mu.Unlock()
+		return true, 0
+	}
+
+	now := clock.Now()
+	if now.Sub(c.lastPrune) >= pruneInterval {
+		for id, bucket := range c.buckets {
+			if bucket.Full() {
+				delete(c.buckets, id)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	bucket, ok := c.buckets[client]
+	if !ok {
+		bucket = tokenbucket.New(clock, c.rate, c.burst)
+		c.buckets[client] = bucket
+	}
+	c.mu.Unlock()
+
+	return bucket.TryTake(1)
+}
+
+// ClientID identifies the client that sent the request: the common name of
+// its certificate when it authenticated over mutual TLS, otherwise its IP
+// address.
+func ClientID(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return "cn:" + r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
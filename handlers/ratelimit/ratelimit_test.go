@@ -0,0 +1,174 @@
+package ratelimit_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/fileserver/handlers/ratelimit"
+	"code.cloudfoundry.org/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limiter", func() {
+	var (
+		fakeClock *fakeclock.FakeClock
+		cfg       ratelimit.Config
+		handler   http.Handler
+		limiter   *ratelimit.Limiter
+	)
+
+	request := func(method, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v1/static/file", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		cfg = ratelimit.Config{
+			ReadRequestsPerSecond:  1,
+			ReadBurst:              2,
+			WriteRequestsPerSecond: 0.5,
+			WriteBurst:             1,
+		}
+	})
+
+	JustBeforeEach(func() {
+		limiter = ratelimit.New(lagertest.NewTestLogger("test"), fakeClock, cfg)
+		handler = limiter.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	It("lets requests through while the client is within its burst", func() {
+		Expect(request("GET", "10.0.0.1:1234").Code).To(Equal(http.StatusOK))
+		Expect(request("GET", "10.0.0.1:1235").Code).To(Equal(http.StatusOK))
+	})
+
+	It("rejects requests past the burst with 429 and a Retry-After header", func() {
+		request("GET", "10.0.0.1:1234")
+		request("GET", "10.0.0.1:1234")
+
+		rec := request("GET", "10.0.0.1:1234")
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(rec.Header().Get("Retry-After")).To(Equal("1"))
+
+		reads, writes := limiter.Rejections()
+		Expect(reads).To(BeEquivalentTo(1))
+		Expect(writes).To(BeZero())
+	})
+
+	It("allows requests again once the bucket has refilled", func() {
+		request("GET", "10.0.0.1:1234")
+		request("GET", "10.0.0.1:1234")
+		Expect(request("GET", "10.0.0.1:1234").Code).To(Equal(http.StatusTooManyRequests))
+
+		fakeClock.Increment(time.Second)
+		Expect(request("GET", "10.0.0.1:1234").Code).To(Equal(http.StatusOK))
+	})
+
+	It("limits each client independently", func() {
+		request("GET", "10.0.0.1:1234")
+		request("GET", "10.0.0.1:1234")
+
+		Expect(request("GET", "10.0.0.2:1234").Code).To(Equal(http.StatusOK))
+	})
+
+	It("applies separate limits to writes", func() {
+		Expect(request("PUT", "10.0.0.1:1234").Code).To(Equal(http.StatusOK))
+
+		rec := request("PUT", "10.0.0.1:1234")
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(rec.Header().Get("Retry-After")).To(Equal("2"))
+
+		Expect(request("GET", "10.0.0.1:1234").Code).To(Equal(http.StatusOK))
+
+		_, writes := limiter.Rejections()
+		Expect(writes).To(BeEquivalentTo(1))
+	})
+
+	Describe("Update", func() {
+		It("applies the new rates to the requests that follow", func() {
+			limiter.Update(ratelimit.Config{ReadRequestsPerSecond: 1, ReadBurst: 5})
+
+			for i := 0; i < 5; i++ {
+				Expect(request("GET", "10.0.0.1:1234").Code).To(Equal(http.StatusOK))
+			}
+			Expect(request("GET", "10.0.0.1:1234").Code).To(Equal(http.StatusTooManyRequests))
+		})
+
+		It("does not give clients a fresh allowance", func() {
+			request("GET", "10.0.0.1:1234")
+			request("GET", "10.0.0.1:1234")
+			Expect(request("GET", "10.0.0.1:1234").Code).To(Equal(http.StatusTooManyRequests))
+
+			limiter.Update(ratelimit.Config{ReadRequestsPerSecond: 2, ReadBurst: 5})
+			Expect(request("GET", "10.0.0.1:1234").Code).To(Equal(http.StatusTooManyRequests))
+
+			fakeClock.Increment(time.Second)
+			Expect(request("GET", "10.0.0.1:1234").Code).To(Equal(http.StatusOK))
+			Expect(request("GET", "10.0.0.1:1234").Code).To(Equal(http.StatusOK))
+			Expect(request("GET", "10.0.0.1:1234").Code).To(Equal(http.StatusTooManyRequests))
+		})
+
+		It("stops limiting a class whose rate is removed", func() {
+			Expect(request("PUT", "10.0.0.1:1234").Code).To(Equal(http.StatusOK))
+			Expect(request("PUT", "10.0.0.1:1234").Code).To(Equal(http.StatusTooManyRequests))
+
+			limiter.Update(ratelimit.Config{})
+
+			Expect(request("PUT", "10.0.0.1:1234").Code).To(Equal(http.StatusOK))
+			Expect(request("PUT", "10.0.0.1:1234").Code).To(Equal(http.StatusOK))
+		})
+
+		It("keeps counting rejections", func() {
+			request("PUT", "10.0.0.1:1234")
+			request("PUT", "10.0.0.1:1234")
+
+			limiter.Update(cfg)
+
+			request("PUT", "10.0.0.1:1234")
+			request("PUT", "10.0.0.1:1234")
+
+			_, writes := limiter.Rejections()
+			Expect(writes).To(BeEquivalentTo(3))
+		})
+	})
+
+	Context("when a rate is not configured", func() {
+		BeforeEach(func() {
+			cfg.ReadRequestsPerSecond = 0
+		})
+
+		It("does not limit that class of request", func() {
+			for i := 0; i < 10; i++ {
+				Expect(request("GET", "10.0.0.1:1234").Code).To(Equal(http.StatusOK))
+			}
+		})
+	})
+
+	Describe("ClientID", func() {
+		It("uses the IP address of the client", func() {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			Expect(ratelimit.ClientID(req)).To(Equal("10.0.0.1"))
+		})
+
+		It("prefers the common name of the client certificate", func() {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "cell-1"}}},
+			}
+			Expect(ratelimit.ClientID(req)).To(Equal("cn:cell-1"))
+		})
+	})
+})
@@ -0,0 +1,124 @@
+package redirect
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/durationjson"
+)
+
+// The uses of the plain HTTP listener once HTTPS is enabled.
+const (
+	ModeRedirect   = "redirect"
+	ModeHealthOnly = "health-only"
+	ModeDisabled   = "disabled"
+)
+
+// Config describes what the plain HTTP listener does once HTTPS is enabled,
+// redirecting by default, and the Strict-Transport-Security header sent over
+// HTTPS. PublicHost and PublicPort are where clients are sent when that is not
+// the host they asked for and the port of the HTTPS listener, as behind a load
+// balancer. An HSTSMaxAge of zero sends no header.
+type Config struct {
+	PlainListener         string                `json:"plain_listener,omitempty"`
+	PublicHost            string                `json:"public_host,omitempty"`
+	PublicPort            int                   `json:"public_port,omitempty"`
+	HSTSMaxAge            durationjson.Duration `json:"hsts_max_age,omitempty"`
+	HSTSIncludeSubdomains bool                  `json:"hsts_include_subdomains,omitempty"`
+	HSTSPreload           bool                  `json:"hsts_preload,omitempty"`
+}
+
+// Redirector sends the clients of the plain HTTP listener to the same URL over
+// HTTPS.
+type Redirector struct {
+	host string
+	port string
+}
+
+// New returns a Redirector to the HTTPS listener at httpsAddr, which may leave
+// out the host, as in ":8443".
+func New(config Config, httpsAddr string) (*Redirector, error) {
+	port := strconv.Itoa(config.PublicPort)
+	if config.PublicPort == 0 {
+		var err error
+		_, port, err = net.SplitHostPort(httpsAddr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot redirect to %s without a public port: %s", httpsAddr, err)
+		}
+	}
+	if port == "443" {
+		port = ""
+	}
+
+	return &Redirector{host: config.PublicHost, port: port}, nil
+}
+
+func (r *Redirector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	host := r.host
+	if host == "" {
+		host = hostname(req.Host)
+	}
+	if host == "" {
+		http.Error(w, "missing Host header", http.StatusBadRequest)
+		return
+	}
+
+	if r.port != "" {
+		host = net.JoinHostPort(host, r.port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	// Only GET and HEAD may be turned into GET by a 301.
+	status := http.StatusMovedPermanently
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), status)
+}
+
+// hostname returns the host of a Host header, with or without a port, without
+// the brackets of an IPv6 literal.
+func hostname(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]")
+	}
+	return host
+}
+
+// HSTS sets the Strict-Transport-Security header on the responses served over
+// HTTPS.
+type HSTS struct {
+	header string
+}
+
+func NewHSTS(config Config) *HSTS {
+	maxAge := time.Duration(config.HSTSMaxAge)
+	if maxAge <= 0 {
+		return &HSTS{}
+	}
+
+	header := fmt.Sprintf("max-age=%d", int64(maxAge/time.Second))
+	if config.HSTSIncludeSubdomains {
+		header += "; includeSubDomains"
+	}
+	if config.HSTSPreload {
+		header += "; preload"
+	}
+	return &HSTS{header: header}
+}
+
+func (h *HSTS) Wrap(next http.Handler) http.Handler {
+	if h.header == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", h.header)
+		next.ServeHTTP(w, r)
+	})
+}
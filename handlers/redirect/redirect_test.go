@@ -0,0 +1,101 @@
+package redirect_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/durationjson"
+	"code.cloudfoundry.org/fileserver/handlers/redirect"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Redirector", func() {
+	var cfg redirect.Config
+
+	BeforeEach(func() {
+		cfg = redirect.Config{}
+	})
+
+	redirectOf := func(httpsAddr, method, host, target string) *httptest.ResponseRecorder {
+		redirector, err := redirect.New(cfg, httpsAddr)
+		Expect(err).NotTo(HaveOccurred())
+
+		req := httptest.NewRequest(method, target, nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		redirector.ServeHTTP(rec, req)
+		return rec
+	}
+
+	DescribeTable("sends the client to the HTTPS listener",
+		func(httpsAddr, host, location string) {
+			rec := redirectOf(httpsAddr, "GET", host, "/v1/static/file?x=1")
+			Expect(rec.Code).To(Equal(http.StatusMovedPermanently))
+			Expect(rec.Header().Get("Location")).To(Equal(location))
+		},
+		Entry("a host with a port", "0.0.0.0:8443", "example.com:8080", "https://example.com:8443/v1/static/file?x=1"),
+		Entry("a host without a port", "0.0.0.0:8443", "example.com", "https://example.com:8443/v1/static/file?x=1"),
+		Entry("a listen address without a host", ":8443", "example.com:8080", "https://example.com:8443/v1/static/file?x=1"),
+		Entry("an IPv6 literal with a port", "[::]:8443", "[::1]:8080", "https://[::1]:8443/v1/static/file?x=1"),
+		Entry("an IPv6 literal without a port", "[::]:8443", "[::1]", "https://[::1]:8443/v1/static/file?x=1"),
+		Entry("the default HTTPS port", ":443", "example.com:80", "https://example.com/v1/static/file?x=1"),
+		Entry("an IPv6 literal and the default HTTPS port", ":443", "[::1]", "https://[::1]/v1/static/file?x=1"),
+	)
+
+	Context("when a public host and port are configured", func() {
+		BeforeEach(func() {
+			cfg.PublicHost = "files.example.com"
+			cfg.PublicPort = 443
+		})
+
+		It("sends the client there instead", func() {
+			rec := redirectOf(":8443", "GET", "10.0.0.5:8080", "/v1/static/file")
+			Expect(rec.Header().Get("Location")).To(Equal("https://files.example.com/v1/static/file"))
+		})
+	})
+
+	It("keeps the method of requests other than GET and HEAD", func() {
+		rec := redirectOf(":8443", "PUT", "example.com", "/v1/static/file")
+		Expect(rec.Code).To(Equal(http.StatusPermanentRedirect))
+	})
+
+	It("rejects requests without a host", func() {
+		rec := redirectOf(":8443", "GET", "", "/v1/static/file")
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("fails to be created for an invalid HTTPS listen address", func() {
+		_, err := redirect.New(cfg, "localhost")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("HSTS", func() {
+	var cfg redirect.Config
+
+	headerOf := func() string {
+		handler := redirect.NewHSTS(cfg).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec.Header().Get("Strict-Transport-Security")
+	}
+
+	BeforeEach(func() {
+		cfg = redirect.Config{}
+	})
+
+	It("sends no header by default", func() {
+		Expect(headerOf()).To(BeEmpty())
+	})
+
+	It("sends the configured policy", func() {
+		cfg.HSTSMaxAge = durationjson.Duration(365 * 24 * time.Hour)
+		cfg.HSTSIncludeSubdomains = true
+		cfg.HSTSPreload = true
+		Expect(headerOf()).To(Equal("max-age=31536000; includeSubDomains; preload"))
+	})
+})
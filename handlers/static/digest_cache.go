@@ -0,0 +1,114 @@
+package static
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"sync/atomic"
+
+	"code.cloudfoundry.org/fileserver/storage"
+	"code.cloudfoundry.org/lager"
+)
+
+// DigestCache holds the SHA-256 digests of served files, keyed by their
+// cleaned name in the storage. A file server computes a digest
+// the first time the file is requested; Warm computes them ahead of time.
+type DigestCache struct {
+	digests sync.Map
+	size    int64
+}
+
+func NewDigestCache() *DigestCache {
+	return &DigestCache{}
+}
+
+// Len returns the number of digests cached.
+func (c *DigestCache) Len() int {
+	return int(atomic.LoadInt64(&c.size))
+}
+
+func (c *DigestCache) load(p string) (string, bool) {
+	cached, ok := c.digests.Load(p)
+	if !ok {
+		return "", false
+	}
+	digest, valid := cached.(string)
+	return digest, valid
+}
+
+func (c *DigestCache) store(p, digest string) {
+	if _, loaded := c.digests.LoadOrStore(p, digest); !loaded {
+		atomic.AddInt64(&c.size, 1)
+	}
+}
+
+// Warm computes the digest of every regular file in store that is not cached
+// yet, so that the first requests for them do not have to. Files whose digest
+// the storage keeps are skipped, which only costs opening them. A file or
+// directory that cannot be read is logged and left for the requests to digest;
+// Warm carries on with the rest and returns an error counting the failures.
+func (c *DigestCache) Warm(logger lager.Logger, store storage.Storage) error {
+	failures := c.warm(logger, store, "/")
+	if failures > 0 {
+		return fmt.Errorf("failed to digest %d files or directories", failures)
+	}
+	return nil
+}
+
+func (c *DigestCache) warm(logger lager.Logger, store storage.Storage, dir string) int {
+	entries, err := store.List(dir)
+	if err != nil {
+		logger.Error("failed-to-list", err, lager.Data{"path": dir})
+		return 1
+	}
+
+	failures := 0
+	for _, info := range entries {
+		p := path.Join(dir, info.Name())
+		if info.IsDir() {
+			failures += c.warm(logger, store, p)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if _, ok := c.load(p); ok {
+			continue
+		}
+
+		digest, err := digestOf(store, p)
+		if err != nil {
+			logger.Error("failed-to-digest", err, lager.Data{"path": p})
+			failures++
+			continue
+		}
+		if digest != "" {
+			c.store(p, digest)
+		}
+	}
+	return failures
+}
+
+func digestOf(store storage.Storage, name string) (string, error) {
+	f, err := store.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, stored := storedDigest(f); stored {
+		return "", nil
+	}
+	return computeDigest(f)
+}
+
+func computeDigest(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,92 @@
+package static_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/fileserver/storage"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DigestCache", func() {
+	var (
+		servedDirectory string
+		cache           *static.DigestCache
+		logger          *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		var err error
+		servedDirectory, err = ioutil.TempDir("", "fileserver-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Mkdir(filepath.Join(servedDirectory, "buildpacks"), os.ModePerm)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(servedDirectory, "test"), []byte("hello"), os.ModePerm)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(servedDirectory, "buildpacks", "go.zip"), []byte("world"), os.ModePerm)).To(Succeed())
+
+		cache = static.NewDigestCache()
+		logger = lagertest.NewTestLogger("test")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(servedDirectory)
+	})
+
+	It("caches the digest of every file when warmed", func() {
+		Expect(cache.Warm(logger, storage.NewLocal(servedDirectory))).To(Succeed())
+		Expect(cache.Len()).To(Equal(2))
+	})
+
+	It("is used by the file servers it is given to", func() {
+		Expect(cache.Warm(logger, storage.NewLocal(servedDirectory))).To(Succeed())
+
+		// Change the file behind the cache's back: the ETag still carries the
+		// digest computed during the warm-up.
+		Expect(ioutil.WriteFile(filepath.Join(servedDirectory, "buildpacks", "go.zip"), []byte("changed"), os.ModePerm)).To(Succeed())
+
+		server := httptest.NewServer(static.NewFileServer(storage.NewLocal(servedDirectory), cache))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/buildpacks/go.zip")
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+
+		sum := sha256.Sum256([]byte("world"))
+		Expect(resp.Header.Get("ETag")).To(Equal(fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:]))))
+		Expect(cache.Len()).To(Equal(2))
+	})
+
+	It("fails when the directory cannot be walked", func() {
+		Expect(cache.Warm(logger, storage.NewLocal(filepath.Join(servedDirectory, "missing")))).NotTo(Succeed())
+	})
+
+	It("logs the files it cannot read and digests the others", func() {
+		store := &unreadableStorage{Storage: storage.NewLocal(servedDirectory), name: "/test"}
+		Expect(cache.Warm(logger, store)).To(MatchError(ContainSubstring("1 files")))
+		Expect(cache.Len()).To(Equal(1))
+
+		Expect(logger.LogMessages()).To(ConsistOf("test.failed-to-digest"))
+		Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("path", "/test"))
+	})
+})
+
+// unreadableStorage fails to open the file name.
+type unreadableStorage struct {
+	storage.Storage
+	name string
+}
+
+func (s *unreadableStorage) Open(name string) (storage.File, error) {
+	if name == s.name {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return s.Storage.Open(name)
+}
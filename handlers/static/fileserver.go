@@ -5,26 +5,31 @@
 package static
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
-	"sync"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/storage"
 )
 
 type fileServer struct {
-	root     http.FileSystem
-	shaCache sync.Map
+	root     storage.Storage
+	shaCache *DigestCache
 }
 
-func NewFileServer(dir string) http.Handler {
+// NewFileServer serves the files held by store. Digests are kept in cache,
+// which may be shared with a warm-up; a nil cache gives the server its own.
+func NewFileServer(store storage.Storage, cache *DigestCache) http.Handler {
+	if cache == nil {
+		cache = NewDigestCache()
+	}
 	return &fileServer{
-		root: http.Dir(dir),
+		root:     store,
+		shaCache: cache,
 	}
 }
 
@@ -41,32 +46,56 @@ func (f *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	tgzPath := path.Clean(upath)
 
-	file, fileStats := f.validateFile(tgzPath, w)
+	file, fileStats := f.validateFile(tgzPath, w, r)
 	if file == nil {
 		return
 	}
 	defer file.Close()
 
-	cached, ok := f.shaCache.Load(tgzPath)
-	sha256sum, valid := cached.(string)
-	if !ok || !valid {
-		h := sha256.New()
-		if _, err := io.Copy(h, file); err != nil {
+	var digestDuration time.Duration
+	sha256sum, cached := storedDigest(file)
+	if !cached {
+		sha256sum, cached = f.shaCache.load(tgzPath)
+	}
+	if !cached {
+		started := time.Now()
+		var err error
+		sha256sum, err = computeDigest(file)
+		if err != nil {
 			http.Error(w, "Error calculating checksum of file", http.StatusInternalServerError)
 			return
 		}
-		sha256sum = hex.EncodeToString(h.Sum(nil)[:])
-		f.shaCache.Store(tgzPath, sha256sum)
+		digestDuration = time.Since(started)
+		f.shaCache.store(tgzPath, sha256sum)
 	}
 	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, sha256sum))
 
+	if details := requestDetailsFrom(r.Context()); details != nil {
+		details.digestComputed = true
+		details.digestCached = cached
+		details.digestDuration = digestDuration
+		details.digestCacheSize = f.shaCache.Len()
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+			details.rangeBytes, details.rangeRequested = requestedRangeBytes(rangeHeader, fileStats.Size())
+		}
+	}
+
+	// A single range is all http.ServeContent reads of the file, unless it
+	// ends up serving the whole file, which the storage still allows.
+	if ranger, ok := file.(storage.Ranger); ok {
+		if ranges, ok := requestedRanges(r.Header.Get("Range"), fileStats.Size()); ok && len(ranges) == 1 {
+			ranger.ExpectRange(ranges[0].start, ranges[0].length)
+		}
+	}
+
 	http.ServeContent(w, r, fileStats.Name(), fileStats.ModTime(), file)
 }
 
 // validateFile checks that a file can be found and is not a directory. It
-// responds with an HTTP error and nil file
-func (f *fileServer) validateFile(p string, w http.ResponseWriter) (ret http.File, stat os.FileInfo) {
-	file, err := f.root.Open(p)
+// responds with an HTTP error and nil file. The file stops reading once the
+// request is done.
+func (f *fileServer) validateFile(p string, w http.ResponseWriter, r *http.Request) (ret storage.File, stat os.FileInfo) {
+	file, err := storage.OpenContext(r.Context(), f.root, p)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("File not found: %s", filepath.Base(p)), http.StatusNotFound)
 		return nil, nil
@@ -91,6 +120,17 @@ func (f *fileServer) validateFile(p string, w http.ResponseWriter) (ret http.Fil
 	return file, d
 }
 
+// storedDigest returns the digest the storage keeps with file, which is as
+// cheap as a cached one and never stale.
+func storedDigest(file storage.File) (string, bool) {
+	if digester, ok := file.(storage.Digester); ok {
+		if digest := digester.Digest(); digest != "" {
+			return digest, true
+		}
+	}
+	return "", false
+}
+
 func containsDotDot(v string) bool {
 	if !strings.Contains(v, "..") {
 		return false
@@ -9,9 +9,13 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"code.cloudfoundry.org/clock/fakeclock"
 	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/fileserver/storage"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -40,7 +44,7 @@ var _ = Describe("FileServer", func() {
 		sha256bytes = sha256.Sum256([]byte("world"))
 		expectedShaTest2 = hex.EncodeToString(sha256bytes[:])
 
-		fileServer = httptest.NewServer(static.NewFileServer(servedDirectory))
+		fileServer = httptest.NewServer(static.NewFileServer(storage.NewLocal(servedDirectory), nil))
 	})
 
 	AfterEach(func() {
@@ -155,4 +159,122 @@ var _ = Describe("FileServer", func() {
 		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
 	})
 
+	Context("when the files are held in another storage", func() {
+		var memoryServer *httptest.Server
+
+		BeforeEach(func() {
+			store := storage.NewMemory(fakeclock.NewFakeClock(time.Now()))
+			Expect(store.Write("/buildpacks/go.zip", strings.NewReader("world"))).To(Succeed())
+			memoryServer = httptest.NewServer(static.NewFileServer(store, nil))
+		})
+
+		AfterEach(func() {
+			memoryServer.Close()
+		})
+
+		It("serves them the same way", func() {
+			resp, err := http.Get(memoryServer.URL + "/buildpacks/go.zip")
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("ETag")).To(Equal(fmt.Sprintf(`"%s"`, expectedShaTest2)))
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("world"))
+
+			resp, err = http.Get(memoryServer.URL + "/buildpacks")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("uses the digest the storage keeps instead of computing one", func() {
+			store := storage.NewMemory(fakeclock.NewFakeClock(time.Now()))
+			Expect(store.Write("/buildpacks/go.zip", strings.NewReader("world"))).To(Succeed())
+			cache := static.NewDigestCache()
+			server := httptest.NewServer(static.NewFileServer(digestingStorage{Memory: store, digest: "stored-digest"}, cache))
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + "/buildpacks/go.zip")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			Expect(resp.Header.Get("ETag")).To(Equal(`"stored-digest"`))
+			Expect(cache.Len()).To(BeZero())
+		})
+
+		It("tells the file the range it is about to serve", func() {
+			store := &rangingStorage{Memory: storage.NewMemory(fakeclock.NewFakeClock(time.Now()))}
+			Expect(store.Write("/buildpacks/go.zip", strings.NewReader("hello world"))).To(Succeed())
+			server := httptest.NewServer(static.NewFileServer(store, nil))
+			defer server.Close()
+
+			for _, rangeHeader := range []string{"bytes=6-8", "bytes=0-1,6-8", ""} {
+				req, err := http.NewRequest("GET", server.URL+"/buildpacks/go.zip", nil)
+				Expect(err).NotTo(HaveOccurred())
+				req.Header.Set("Range", rangeHeader)
+				resp, err := http.DefaultClient.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+			}
+
+			Expect(store.expected()).To(Equal([][2]int64{{6, 3}}))
+		})
+	})
 })
+
+// digestingStorage keeps the same digest with every file, as object stores
+// keep one with every object.
+type digestingStorage struct {
+	*storage.Memory
+	digest string
+}
+
+func (s digestingStorage) Open(name string) (storage.File, error) {
+	file, err := s.Memory.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return digestedFile{File: file, digest: s.digest}, nil
+}
+
+type digestedFile struct {
+	storage.File
+	digest string
+}
+
+func (f digestedFile) Digest() string { return f.digest }
+
+// rangingStorage records the ranges its files are told to expect.
+type rangingStorage struct {
+	*storage.Memory
+
+	mu     sync.Mutex
+	ranges [][2]int64
+}
+
+func (s *rangingStorage) Open(name string) (storage.File, error) {
+	file, err := s.Memory.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return rangedFile{File: file, storage: s}, nil
+}
+
+func (s *rangingStorage) expected() [][2]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ranges
+}
+
+type rangedFile struct {
+	storage.File
+	storage *rangingStorage
+}
+
+func (f rangedFile) ExpectRange(offset, length int64) {
+	f.storage.mu.Lock()
+	defer f.storage.mu.Unlock()
+	f.storage.ranges = append(f.storage.ranges, [2]int64{offset, length})
+}
@@ -1,50 +1,214 @@
 package static
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
 	"net/http"
+	"time"
 
+	"code.cloudfoundry.org/fileserver/handlers/chunked"
+	"code.cloudfoundry.org/fileserver/handlers/tracing"
 	"code.cloudfoundry.org/lager"
 )
 
 type loggingHandler struct {
 	originalHandler http.Handler
+	route           string
 	logger          lager.Logger
+	observers       []Observer
 }
 
+// responseLogger records the status and size of a response. Besides the
+// methods of http.ResponseWriter it forwards io.ReaderFrom, http.Flusher and
+// http.Hijacker to the writer it wraps, so that wrapping a response does not
+// take the sendfile path away from the file server.
 type responseLogger struct {
-	w      http.ResponseWriter
+	chunked.Writer
 	status int
-	size   int
+	size   int64
 }
 
 func (l *responseLogger) Write(b []byte) (int, error) {
+	l.defaultStatus()
+	size, err := l.ResponseWriter.Write(b)
+	l.size += int64(size)
+	return size, err
+}
+
+func (l *responseLogger) WriteHeader(s int) {
+	l.ResponseWriter.WriteHeader(s)
+	// Informational statuses precede the final one, and anything written
+	// after the final status is ignored by net/http.
+	if l.status == 0 || l.status < http.StatusOK {
+		l.status = s
+	}
+}
+
+func (l *responseLogger) Header() http.Header {
+	return l.ResponseWriter.Header()
+}
+
+func (l *responseLogger) ReadFrom(src io.Reader) (int64, error) {
+	l.defaultStatus()
+
+	size, err := l.Copy(l.ResponseWriter, src, 0, nil)
+	l.size += size
+	return size, err
+}
+
+func (l *responseLogger) Flush() {
+	l.defaultStatus()
+	l.Writer.Flush()
+}
+
+func (l *responseLogger) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := l.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && l.status == 0 {
+		l.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (l *responseLogger) defaultStatus() {
 	if l.status == 0 {
 		// The status will be StatusOK if WriteHeader has not been called yet
 		l.status = http.StatusOK
 	}
-	size, err := l.w.Write(b)
-	l.size += size
-	return size, err
 }
 
-func (l *responseLogger) WriteHeader(s int) {
-	l.w.WriteHeader(s)
-	l.status = s
+// requestDetails collects what the file server learns while serving a
+// request that cannot be told from the response alone.
+type requestDetails struct {
+	digestComputed  bool
+	digestCached    bool
+	digestDuration  time.Duration
+	digestCacheSize int
+	rangeRequested  bool
+	rangeBytes      int64
 }
 
-func (l *responseLogger) Header() http.Header {
-	return l.w.Header()
+type requestDetailsKey struct{}
+
+func requestDetailsFrom(ctx context.Context) *requestDetails {
+	details, _ := ctx.Value(requestDetailsKey{}).(*requestDetails)
+	return details
+}
+
+// Response describes a request the static handler has finished serving.
+// Route is the path prefix the handler serves. Digest fields are only
+// meaningful when DigestComputed is set; DigestCached is also set for digests
+// kept by the storage, DigestDuration is zero when the digest did not have to
+// be computed, and DigestCacheSize counts the digests cached once this one
+// was. RangeBytesRequested is only meaningful when RangeRequested is set.
+// Trace is the zero value unless the handler is wrapped by tracing.Wrap.
+type Response struct {
+	Route      string
+	StartedAt  time.Time
+	Duration   time.Duration
+	Method     string
+	URI        string
+	Proto      string
+	Status     int
+	Size       int64
+	RemoteAddr string
+	UserAgent  string
+	Referer    string
+	TLSVersion string
+	TLSPeer    string
+
+	DigestComputed      bool
+	DigestCached        bool
+	DigestDuration      time.Duration
+	DigestCacheSize     int
+	RangeRequested      bool
+	RangeBytesRequested int64
+
+	Trace tracing.Context
+}
+
+// An Observer is told about every response the static handler serves, after
+// it has been sent.
+type Observer interface {
+	ObserveResponse(Response)
 }
 
 func (h loggingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	resLogger := &responseLogger{w: w}
+	start := time.Now()
+	details := &requestDetails{}
+	req = req.WithContext(context.WithValue(req.Context(), requestDetailsKey{}, details))
+
+	resLogger := &responseLogger{Writer: chunked.Writer{ResponseWriter: w}}
 	h.originalHandler.ServeHTTP(resLogger, req)
 
+	res := Response{
+		Route:               h.route,
+		StartedAt:           start,
+		Duration:            time.Since(start),
+		Method:              req.Method,
+		URI:                 req.URL.RequestURI(),
+		Proto:               req.Proto,
+		Status:              resLogger.status,
+		Size:                resLogger.size,
+		RemoteAddr:          req.RemoteAddr,
+		UserAgent:           req.UserAgent(),
+		Referer:             req.Referer(),
+		DigestComputed:      details.digestComputed,
+		DigestCached:        details.digestCached,
+		DigestDuration:      details.digestDuration,
+		DigestCacheSize:     details.digestCacheSize,
+		RangeRequested:      details.rangeRequested,
+		RangeBytesRequested: details.rangeBytes,
+	}
+	if tc, ok := tracing.FromContext(req.Context()); ok {
+		res.Trace = tc
+	}
+	if req.TLS != nil {
+		res.TLSVersion = tls.VersionName(req.TLS.Version)
+		if len(req.TLS.PeerCertificates) > 0 {
+			res.TLSPeer = req.TLS.PeerCertificates[0].Subject.String()
+		}
+	}
+
+	data := lager.Data{
+		"status":       res.Status,
+		"size":         res.Size,
+		"method":       res.Method,
+		"uri":          res.URI,
+		"duration":     res.Duration,
+		"remote-addr":  res.RemoteAddr,
+		"user-agent":   res.UserAgent,
+		"not-modified": res.Status == http.StatusNotModified,
+	}
+	if res.DigestComputed {
+		data["digest-cached"] = res.DigestCached
+	}
+	if res.RangeRequested {
+		data["range-bytes-requested"] = res.RangeBytesRequested
+	}
+	if res.TLSVersion != "" {
+		data["tls-version"] = res.TLSVersion
+	}
+	if res.TLSPeer != "" {
+		data["tls-peer"] = res.TLSPeer
+	}
+	if res.Trace.RequestID != "" {
+		data["request-id"] = res.Trace.RequestID
+		data["trace-id"] = res.Trace.TraceID
+		data["span-id"] = res.Trace.SpanID
+	}
+
 	requestLogger := h.logger.Session("static-file")
-	requestLogger.Info("response", lager.Data{
-		"status": resLogger.status,
-		"size":   resLogger.size,
-		"method": req.Method,
-		"uri":    req.URL.RequestURI(),
-	})
+	requestLogger.Info("response", data)
+
+	for _, observer := range h.observers {
+		observer.ObserveResponse(res)
+	}
 }
@@ -0,0 +1,350 @@
+package static_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/fileserver/handlers/tracing"
+	"code.cloudfoundry.org/fileserver/storage"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/tlsconfig/certtest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logging handler", func() {
+	var (
+		servedDirectory string
+		logger          *lagertest.TestLogger
+		middleware      func(http.Handler) http.Handler
+		server          *httptest.Server
+	)
+
+	responseLog := func() lager.Data {
+		logs := logger.LogMessages()
+		Expect(logs).To(ContainElement("test.static-file.response"))
+		return logger.Logs()[len(logs)-1].Data
+	}
+
+	BeforeEach(func() {
+		var err error
+		servedDirectory, err = ioutil.TempDir("", "fileserver-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(servedDirectory, "test"), []byte(strings.Repeat("a", 4096)), os.ModePerm)).To(Succeed())
+
+		logger = lagertest.NewTestLogger("test")
+		middleware = func(next http.Handler) http.Handler { return next }
+	})
+
+	JustBeforeEach(func() {
+		server = httptest.NewServer(static.New(storage.NewLocal(servedDirectory), "/v1/static/", nil, logger, nil, middleware))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(servedDirectory)
+	})
+
+	It("logs the status and the size of a file sent through ReadFrom", func() {
+		resp, err := http.Get(server.URL + "/v1/static/test")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+
+		Eventually(logger.LogMessages).Should(ContainElement("test.static-file.response"))
+		data := responseLog()
+		Expect(data["status"]).To(BeEquivalentTo(http.StatusOK))
+		Expect(data["size"]).To(BeEquivalentTo(4096))
+	})
+
+	It("logs how the request was served", func() {
+		req, err := http.NewRequest("GET", server.URL+"/v1/static/test", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("User-Agent", "cell-rep")
+
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+
+		Eventually(logger.LogMessages).Should(ContainElement("test.static-file.response"))
+		data := responseLog()
+		Expect(data["duration"]).To(BeNumerically(">", 0))
+		Expect(data["remote-addr"]).To(HavePrefix("127.0.0.1:"))
+		Expect(data["user-agent"]).To(Equal("cell-rep"))
+		Expect(data["digest-cached"]).To(BeFalse())
+		Expect(data["not-modified"]).To(BeFalse())
+		Expect(data).NotTo(HaveKey("range-bytes-requested"))
+		Expect(data).NotTo(HaveKey("tls-version"))
+	})
+
+	It("logs whether the digest came from the cache and whether the response was a 304", func() {
+		resp, err := http.Get(server.URL + "/v1/static/test")
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+
+		req, err := http.NewRequest("GET", server.URL+"/v1/static/test", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+		resp, err = http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusNotModified))
+
+		Eventually(logger.LogMessages).Should(HaveLen(2))
+		data := responseLog()
+		Expect(data["digest-cached"]).To(BeTrue())
+		Expect(data["not-modified"]).To(BeTrue())
+	})
+
+	DescribeTable("logging the bytes requested through Range",
+		func(rangeHeader string, requested, sent int) {
+			req, err := http.NewRequest("GET", server.URL+"/v1/static/test", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Range", rangeHeader)
+
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.Copy(ioutil.Discard, resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			Eventually(logger.LogMessages).Should(ContainElement("test.static-file.response"))
+			data := responseLog()
+			Expect(data["range-bytes-requested"]).To(BeEquivalentTo(requested))
+			Expect(data["size"]).To(BeEquivalentTo(sent))
+		},
+		Entry("a bounded range", "bytes=0-99", 100, 100),
+		Entry("an open-ended range", "bytes=4000-", 96, 96),
+		Entry("a suffix range", "bytes=-10", 10, 10),
+		Entry("a range past the end of the file", "bytes=4000-9999", 96, 96),
+	)
+
+	It("does not log bytes requested when every range starts past the end of the file", func() {
+		req, err := http.NewRequest("GET", server.URL+"/v1/static/test", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Range", "bytes=5000-5999,6000-")
+
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusRequestedRangeNotSatisfiable))
+
+		Eventually(logger.LogMessages).Should(ContainElement("test.static-file.response"))
+		Expect(responseLog()).NotTo(HaveKey("range-bytes-requested"))
+	})
+
+	Context("when the request is made over mutual TLS", func() {
+		var client *http.Client
+
+		JustBeforeEach(func() {
+			server.Close()
+
+			ca, err := certtest.BuildCA("test-ca")
+			Expect(err).NotTo(HaveOccurred())
+			pool, err := ca.CertPool()
+			Expect(err).NotTo(HaveOccurred())
+			serverCert, err := ca.BuildSignedCertificate("server", certtest.WithIPs(net.ParseIP("127.0.0.1")))
+			Expect(err).NotTo(HaveOccurred())
+			serverTLSCert, err := serverCert.TLSCertificate()
+			Expect(err).NotTo(HaveOccurred())
+			clientCert, err := ca.BuildSignedCertificate("cell-1")
+			Expect(err).NotTo(HaveOccurred())
+			clientTLSCert, err := clientCert.TLSCertificate()
+			Expect(err).NotTo(HaveOccurred())
+
+			server = httptest.NewUnstartedServer(static.New(storage.NewLocal(servedDirectory), "/v1/static/", nil, logger, nil, middleware))
+			server.TLS = &tls.Config{
+				Certificates: []tls.Certificate{serverTLSCert},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    pool,
+			}
+			server.StartTLS()
+
+			client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{clientTLSCert},
+				RootCAs:      pool,
+			}}}
+		})
+
+		It("logs the TLS version and the identity of the client", func() {
+			resp, err := client.Get(server.URL + "/v1/static/test")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			Eventually(logger.LogMessages).Should(ContainElement("test.static-file.response"))
+			data := responseLog()
+			Expect(data["tls-version"]).To(Equal("TLS 1.3"))
+			Expect(data["tls-peer"]).To(ContainSubstring("CN=cell-1"))
+		})
+	})
+
+	Context("when the handler is wrapped for tracing", func() {
+		JustBeforeEach(func() {
+			server.Close()
+			server = httptest.NewServer(tracing.Wrap(static.New(storage.NewLocal(servedDirectory), "/v1/static/", nil, logger, nil, middleware)))
+		})
+
+		It("logs the request ID and the trace context", func() {
+			req, err := http.NewRequest("GET", server.URL+"/v1/static/test", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set(tracing.RequestIDHeader, "some-request-id")
+			req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			Eventually(logger.LogMessages).Should(ContainElement("test.static-file.response"))
+			data := responseLog()
+			Expect(data["request-id"]).To(Equal("some-request-id"))
+			Expect(data["trace-id"]).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+			Expect(data["span-id"]).To(MatchRegexp(`^[0-9a-f]{16}$`))
+			Expect(resp.Header.Get(tracing.TraceparentHeader)).To(ContainSubstring(data["span-id"].(string)))
+		})
+	})
+
+	Context("when observers are given", func() {
+		var observer *fakeObserver
+
+		JustBeforeEach(func() {
+			server.Close()
+			observer = &fakeObserver{}
+			server = httptest.NewServer(static.New(storage.NewLocal(servedDirectory), "/v1/static/", nil, logger, []static.Observer{observer}, middleware))
+		})
+
+		It("tells them about every response", func() {
+			req, err := http.NewRequest("GET", server.URL+"/v1/static/test", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("User-Agent", "cell-rep")
+			req.Header.Set("Referer", "http://example.com")
+
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.Copy(ioutil.Discard, resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			Eventually(observer.Responses).Should(HaveLen(1))
+			res := observer.Responses()[0]
+			Expect(res.Method).To(Equal("GET"))
+			Expect(res.URI).To(Equal("/v1/static/test"))
+			Expect(res.Proto).To(Equal("HTTP/1.1"))
+			Expect(res.Status).To(Equal(http.StatusOK))
+			Expect(res.Size).To(BeEquivalentTo(4096))
+			Expect(res.UserAgent).To(Equal("cell-rep"))
+			Expect(res.Referer).To(Equal("http://example.com"))
+			Expect(res.Route).To(Equal("/v1/static/"))
+			Expect(res.DigestComputed).To(BeTrue())
+			Expect(res.DigestCached).To(BeFalse())
+			Expect(res.DigestDuration).To(BeNumerically(">", 0))
+			Expect(res.DigestCacheSize).To(Equal(1))
+			Expect(res.StartedAt).NotTo(BeZero())
+		})
+	})
+
+	It("logs the status of error responses", func() {
+		resp, err := http.Get(server.URL + "/v1/static/does-not-exist")
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+
+		Eventually(logger.LogMessages).Should(ContainElement("test.static-file.response"))
+		Expect(responseLog()["status"]).To(BeEquivalentTo(http.StatusNotFound))
+	})
+
+	Context("when the wrapped handler uses the optional interfaces", func() {
+		var (
+			readerFrom, flusher, hijacker bool
+			deadlineErr                   error
+		)
+
+		BeforeEach(func() {
+			middleware = func(http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					_, readerFrom = w.(io.ReaderFrom)
+					_, flusher = w.(http.Flusher)
+					_, hijacker = w.(http.Hijacker)
+					deadlineErr = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(time.Minute))
+
+					if r.URL.Query().Get("hijack") == "" {
+						w.(http.Flusher).Flush()
+						return
+					}
+
+					conn, rw, err := w.(http.Hijacker).Hijack()
+					Expect(err).NotTo(HaveOccurred())
+					defer conn.Close()
+					rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+					rw.Flush()
+				})
+			}
+		})
+
+		It("passes them through", func() {
+			resp, err := http.Get(server.URL + "/v1/static/test")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			Expect(readerFrom).To(BeTrue())
+			Expect(flusher).To(BeTrue())
+			Expect(hijacker).To(BeTrue())
+			Expect(deadlineErr).NotTo(HaveOccurred())
+		})
+
+		It("counts a flush without a status as 200", func() {
+			resp, err := http.Get(server.URL + "/v1/static/test")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			Eventually(logger.LogMessages).Should(ContainElement("test.static-file.response"))
+			Expect(responseLog()["status"]).To(BeEquivalentTo(http.StatusOK))
+		})
+
+		It("hands over the connection on hijack", func() {
+			conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			_, err = conn.Write([]byte("GET /v1/static/test?hijack=true HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+			Expect(err).NotTo(HaveOccurred())
+
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			Expect(err).NotTo(HaveOccurred())
+			Expect(line).To(Equal("HTTP/1.1 101 Switching Protocols\r\n"))
+
+			Eventually(logger.LogMessages).Should(ContainElement("test.static-file.response"))
+			Expect(responseLog()["status"]).To(BeEquivalentTo(http.StatusSwitchingProtocols))
+		})
+	})
+})
+
+type fakeObserver struct {
+	mu        sync.Mutex
+	responses []static.Response
+}
+
+func (o *fakeObserver) ObserveResponse(res static.Response) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.responses = append(o.responses, res)
+}
+
+func (o *fakeObserver) Responses() []static.Response {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]static.Response{}, o.responses...)
+}
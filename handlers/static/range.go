@@ -0,0 +1,83 @@
+package static
+
+import (
+	"strconv"
+	"strings"
+)
+
+// byteRange is the part of a file length bytes long from start.
+type byteRange struct {
+	start, length int64
+}
+
+// requestedRangeBytes returns how many bytes of a file of the given size the
+// Range header asks for, following the same rules as http.ServeContent. It
+// returns false when the header is absent or cannot be satisfied, as when
+// every range starts past the end of the file.
+func requestedRangeBytes(header string, size int64) (int64, bool) {
+	ranges, satisfiable := requestedRanges(header, size)
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	return total, satisfiable
+}
+
+// requestedRanges returns the parts of a file of the given size the Range
+// header asks for, leaving out the ones that start past its end. It returns
+// false when the header is absent or cannot be satisfied.
+func requestedRanges(header string, size int64) ([]byteRange, bool) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, false
+	}
+
+	var ranges []byteRange
+	for _, spec := range strings.Split(header[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		dash := strings.Index(spec, "-")
+		if dash < 0 {
+			return nil, false
+		}
+		start, end := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+		if start == "" {
+			// A suffix range asks for the last n bytes.
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return nil, false
+			}
+			if n > size {
+				n = size
+			}
+			ranges = append(ranges, byteRange{start: size - n, length: n})
+			continue
+		}
+
+		first, err := strconv.ParseInt(start, 10, 64)
+		if err != nil || first < 0 {
+			return nil, false
+		}
+		if first >= size {
+			continue
+		}
+
+		last := size - 1
+		if end != "" {
+			last, err = strconv.ParseInt(end, 10, 64)
+			if err != nil || last < first {
+				return nil, false
+			}
+			if last >= size {
+				last = size - 1
+			}
+		}
+		ranges = append(ranges, byteRange{start: first, length: last - first + 1})
+	}
+
+	return ranges, len(ranges) > 0
+}
@@ -0,0 +1,124 @@
+package static_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/fileserver/handlers/throttle"
+	"code.cloudfoundry.org/fileserver/handlers/watchdog"
+	"code.cloudfoundry.org/fileserver/storage"
+	"code.cloudfoundry.org/lager"
+)
+
+const benchmarkFileSize = 16 * 1024 * 1024
+
+// sendfileListener wraps the connections it accepts to record how many bytes
+// the server handed them as a file to copy with sendfile.
+type sendfileListener struct {
+	net.Listener
+	sendfileBytes int64
+}
+
+func (l *sendfileListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &sendfileConn{TCPConn: conn.(*net.TCPConn), listener: l}, nil
+}
+
+type sendfileConn struct {
+	*net.TCPConn
+	listener *sendfileListener
+}
+
+func (c *sendfileConn) ReadFrom(src io.Reader) (int64, error) {
+	n, err := c.TCPConn.ReadFrom(src)
+	if isFile(src) {
+		atomic.AddInt64(&c.listener.sendfileBytes, n)
+	}
+	return n, err
+}
+
+// isFile mirrors the check net.TCPConn makes before using sendfile.
+func isFile(src io.Reader) bool {
+	if lr, ok := src.(*io.LimitedReader); ok {
+		src = lr.R
+	}
+	_, ok := src.(*os.File)
+	return ok
+}
+
+func BenchmarkStaticSendfile(b *testing.B) {
+	servedDirectory, err := ioutil.TempDir("", "fileserver-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(servedDirectory)
+
+	err = ioutil.WriteFile(filepath.Join(servedDirectory, "droplet.tgz"), make([]byte, benchmarkFileSize), os.ModePerm)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	logger := lager.NewLogger("bench")
+	benchmarks := []struct {
+		name       string
+		middleware []func(http.Handler) http.Handler
+	}{
+		{name: "logging-handler"},
+		{name: "with-watchdog", middleware: []func(http.Handler) http.Handler{
+			watchdog.New(logger, clock.NewClock(), watchdog.Config{MinBytesPerSecond: 1}).Wrap,
+		}},
+		{name: "with-throttle", middleware: []func(http.Handler) http.Handler{
+			throttle.New(clock.NewClock(), throttle.Config{GlobalBytesPerSecond: 1 << 40}).Wrap,
+		}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			recorder := &sendfileListener{Listener: listener}
+
+			server := httptest.NewUnstartedServer(static.New(storage.NewLocal(servedDirectory), "/v1/static/", nil, logger, nil, bm.middleware...))
+			server.Listener = recorder
+			server.Start()
+			defer server.Close()
+
+			b.SetBytes(benchmarkFileSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := http.Get(server.URL + "/v1/static/droplet.tgz")
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, err = io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			sent := atomic.LoadInt64(&recorder.sendfileBytes)
+			b.ReportMetric(float64(sent)/float64(b.N*benchmarkFileSize), "sendfile-ratio")
+			// Only the headers and the first bytes used for content sniffing are
+			// expected to be copied; the body has to go through sendfile.
+			if sent < int64(b.N)*(benchmarkFileSize-512) {
+				b.Fatalf("expected the file to be sent with sendfile, but only %d of %d bytes were", sent, int64(b.N)*benchmarkFileSize)
+			}
+		})
+	}
+}
@@ -3,14 +3,24 @@ package static
 import (
 	"net/http"
 
+	"code.cloudfoundry.org/fileserver/storage"
 	"code.cloudfoundry.org/lager"
 )
 
-func New(dir, pathPrefix string, logger lager.Logger) http.Handler {
-	fileServer := NewFileServer(dir)
-	stripped := http.StripPrefix(pathPrefix, fileServer)
+// New returns the handler for the static route. Each middleware wraps the
+// file server inside the request logger, the first one outermost, so that
+// requests they reject are still logged and observed.
+func New(store storage.Storage, pathPrefix string, cache *DigestCache, logger lager.Logger, observers []Observer, middleware ...func(http.Handler) http.Handler) http.Handler {
+	fileServer := NewFileServer(store, cache)
+	var handler http.Handler = http.StripPrefix(pathPrefix, fileServer)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
 	return loggingHandler{
 		logger:          logger,
-		originalHandler: stripped,
+		originalHandler: handler,
+		route:           pathPrefix,
+		observers:       observers,
 	}
 }
@@ -0,0 +1 @@
+package throttle // import "code.cloudfoundry.org/fileserver/handlers/throttle"
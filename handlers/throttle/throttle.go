@@ -0,0 +1,268 @@
+package throttle
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/fileserver/handlers/chunked"
+	"code.cloudfoundry.org/fileserver/tokenbucket"
+)
+
+// chunkSize bounds how much of a single Write is sent before the limits are
+// consulted again, so that one large write cannot burst past them.
+const chunkSize = 32 * 1024
+
+// Config holds the egress limits in bytes per second. A limit of zero is not
+// enforced.
+type Config struct {
+	GlobalBytesPerSecond        int64            `json:"global_bytes_per_second,omitempty"`
+	PerConnectionBytesPerSecond int64            `json:"per_connection_bytes_per_second,omitempty"`
+	PathPrefixBytesPerSecond    map[string]int64 `json:"path_prefix_bytes_per_second,omitempty"`
+}
+
+type Throttler struct {
+	clock clock.Clock
+
+	mu            sync.Mutex
+	global        *tokenbucket.Bucket
+	perConnection int64
+	prefixes      []prefixLimit
+	connections   map[interface{}]*connection
+}
+
+type prefixLimit struct {
+	prefix string
+	bucket *tokenbucket.Bucket
+}
+
+type connection struct {
+	bucket *tokenbucket.Bucket
+	refs   int
+}
+
+func New(clock clock.Clock, config Config) *Throttler {
+	t := &Throttler{
+		clock:       clock,
+		connections: map[interface{}]*connection{},
+	}
+	t.Update(config)
+	return t
+}
+
+// Update applies new limits, to the responses being sent as well as to the
+// ones that follow. The buckets are kept, so that what has been sent so far
+// still counts against the new rates.
+func (t *Throttler) Update(config Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.global = updateBucket(t.clock, t.global, config.GlobalBytesPerSecond)
+
+	var prefixes []prefixLimit
+	for prefix, rate := range config.PathPrefixBytesPerSecond {
+		if rate <= 0 {
+			continue
+		}
+		var bucket *tokenbucket.Bucket
+		for _, p := range t.prefixes {
+			if p.prefix == prefix {
+				bucket = p.bucket
+			}
+		}
+		prefixes = append(prefixes, prefixLimit{prefix: prefix, bucket: updateBucket(t.clock, bucket, rate)})
+	}
+	// Longest prefix first, so that the most specific limit wins.
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i].prefix) > len(prefixes[j].prefix)
+	})
+	t.prefixes = prefixes
+
+	t.perConnection = config.PerConnectionBytesPerSecond
+	for _, conn := range t.connections {
+		conn.bucket = updateBucket(t.clock, conn.bucket, t.perConnection)
+	}
+}
+
+// updateBucket returns bucket with its rate changed to bytesPerSecond, a new
+// bucket when there was none, or nil when the rate is not enforced.
+func updateBucket(clock clock.Clock, bucket *tokenbucket.Bucket, bytesPerSecond int64) *tokenbucket.Bucket {
+	switch {
+	case bytesPerSecond <= 0:
+		return nil
+	case bucket == nil:
+		return newBucket(clock, bytesPerSecond)
+	default:
+		bucket.SetRate(float64(bytesPerSecond), int(bytesPerSecond))
+		return bucket
+	}
+}
+
+type connectionKey struct{}
+
+// ConnContext records the client connection in the context of its requests,
+// to be installed with server.WithConnContext. The per-connection limit then
+// tells connections apart even where their RemoteAddr does not, as on Unix
+// domain sockets; without it, requests are grouped by RemoteAddr.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connectionKey{}, c)
+}
+
+func newBucket(clock clock.Clock, bytesPerSecond int64) *tokenbucket.Bucket {
+	return tokenbucket.New(clock, float64(bytesPerSecond), int(bytesPerSecond))
+}
+
+// Wrap returns a handler whose response bodies are written no faster than
+// the configured limits allow. Only the body is slowed down; status, headers
+// and range handling are left to the wrapped handler. Responses that start
+// while no limit is configured are sent unthrottled.
+func (t *Throttler) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !t.limited() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := connectionOf(r)
+		conn := t.acquireConnection(id)
+		defer t.releaseConnection(id)
+
+		next.ServeHTTP(&throttledWriter{
+			Writer:    chunked.Writer{ResponseWriter: w},
+			request:   r,
+			throttler: t,
+			conn:      conn,
+		}, r)
+	})
+}
+
+func (t *Throttler) limited() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.global != nil || t.perConnection > 0 || len(t.prefixes) > 0
+}
+
+// connectionOf identifies the connection a request was made over: the one
+// recorded by ConnContext, or else the client address.
+func connectionOf(r *http.Request) interface{} {
+	if c, ok := r.Context().Value(connectionKey{}).(net.Conn); ok {
+		return c
+	}
+	return r.RemoteAddr
+}
+
+// acquireConnection returns the state shared by every request made over the
+// connection identified by id, so that a client reusing a keep-alive
+// connection or multiplexing requests over it still gets a single allowance.
+func (t *Throttler) acquireConnection(id interface{}) *connection {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conn, ok := t.connections[id]
+	if !ok {
+		conn = &connection{bucket: updateBucket(t.clock, nil, t.perConnection)}
+		t.connections[id] = conn
+	}
+	conn.refs++
+	return conn
+}
+
+func (t *Throttler) releaseConnection(id interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conn := t.connections[id]
+	conn.refs--
+	if conn.refs == 0 {
+		delete(t.connections, id)
+	}
+}
+
+// buckets appends the buckets currently pacing a response for path sent
+// over conn to dst.
+func (t *Throttler) buckets(dst []*tokenbucket.Bucket, path string, conn *connection) []*tokenbucket.Bucket {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.global != nil {
+		dst = append(dst, t.global)
+	}
+	if conn.bucket != nil {
+		dst = append(dst, conn.bucket)
+	}
+	for _, p := range t.prefixes {
+		if strings.HasPrefix(path, p.prefix) {
+			dst = append(dst, p.bucket)
+			break
+		}
+	}
+	return dst
+}
+
+type throttledWriter struct {
+	chunked.Writer
+	request   *http.Request
+	throttler *Throttler
+	conn      *connection
+	buckets   []*tokenbucket.Bucket
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := len(b)
+		if n > chunkSize {
+			n = chunkSize
+		}
+
+		if err := w.wait(n); err != nil {
+			return written, err
+		}
+
+		m, err := w.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// ReadFrom paces the transfer one chunk at a time, keeping the sendfile path
+// of the underlying writer available.
+func (w *throttledWriter) ReadFrom(src io.Reader) (int64, error) {
+	return w.Copy(w, src, chunkSize, func(n int64) error {
+		return w.wait(int(n))
+	})
+}
+
+func (w *throttledWriter) wait(n int) error {
+	w.buckets = w.throttler.buckets(w.buckets[:0], w.request.URL.Path, w.conn)
+
+	var wait time.Duration
+	for _, bucket := range w.buckets {
+		if d := bucket.Take(float64(n)); d > wait {
+			wait = d
+		}
+	}
+	if wait == 0 {
+		return nil
+	}
+
+	timer := w.throttler.clock.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return nil
+	case <-w.request.Context().Done():
+		return w.request.Context().Err()
+	}
+}
@@ -0,0 +1,213 @@
+package throttle_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/fileserver/handlers/throttle"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Throttler", func() {
+	var (
+		fakeClock *fakeclock.FakeClock
+		cfg       throttle.Config
+		throttler *throttle.Throttler
+		handler   http.Handler
+		body      []byte
+	)
+
+	serve := func(req *http.Request) (*httptest.ResponseRecorder, chan struct{}) {
+		rec := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			handler.ServeHTTP(rec, req)
+		}()
+		return rec, done
+	}
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		cfg = throttle.Config{}
+		body = bytes.Repeat([]byte("a"), 300)
+	})
+
+	JustBeforeEach(func() {
+		throttler = throttle.New(fakeClock, cfg)
+		handler = throttler.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(body)
+		}))
+	})
+
+	Context("when no limits are configured", func() {
+		It("writes the response without waiting", func() {
+			rec, done := serve(httptest.NewRequest("GET", "/v1/static/file", nil))
+			Eventually(done).Should(BeClosed())
+			Expect(rec.Body.Bytes()).To(Equal(body))
+		})
+
+		It("applies limits once they are updated", func() {
+			throttler.Update(throttle.Config{GlobalBytesPerSecond: 100})
+
+			_, done := serve(httptest.NewRequest("GET", "/v1/static/file", nil))
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			Consistently(done).ShouldNot(BeClosed())
+
+			fakeClock.Increment(2 * time.Second)
+			Eventually(done).Should(BeClosed())
+		})
+	})
+
+	Context("when a per-connection limit is configured", func() {
+		BeforeEach(func() {
+			cfg.PerConnectionBytesPerSecond = 100
+		})
+
+		It("holds the body back until the connection's allowance covers it", func() {
+			rec, done := serve(httptest.NewRequest("GET", "/v1/static/file", nil))
+
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			Consistently(done).ShouldNot(BeClosed())
+
+			fakeClock.Increment(2 * time.Second)
+			Eventually(done).Should(BeClosed())
+			Expect(rec.Body.Bytes()).To(Equal(body))
+		})
+
+		It("preserves range requests", func() {
+			handler = throttle.New(fakeClock, cfg).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "file", time.Time{}, strings.NewReader("0123456789"))
+			}))
+
+			req := httptest.NewRequest("GET", "/v1/static/file", nil)
+			req.Header.Set("Range", "bytes=2-5")
+			rec, done := serve(req)
+
+			Eventually(done).Should(BeClosed())
+			Expect(rec.Code).To(Equal(http.StatusPartialContent))
+			Expect(rec.Header().Get("Content-Range")).To(Equal("bytes 2-5/10"))
+			Expect(rec.Body.String()).To(Equal("2345"))
+		})
+
+		It("limits connections independently", func() {
+			first := httptest.NewRequest("GET", "/v1/static/file", nil)
+			first.RemoteAddr = "10.0.0.1:1000"
+			second := httptest.NewRequest("GET", "/v1/static/file", nil)
+			second.RemoteAddr = "10.0.0.1:1001"
+
+			body = body[:100]
+			_, firstDone := serve(first)
+			_, secondDone := serve(second)
+
+			Eventually(firstDone).Should(BeClosed())
+			Eventually(secondDone).Should(BeClosed())
+		})
+
+		It("tells apart connections sharing a remote address once ConnContext is installed", func() {
+			firstConn, _ := net.Pipe()
+			secondConn, _ := net.Pipe()
+			first := httptest.NewRequest("GET", "/v1/static/file", nil)
+			first = first.WithContext(throttle.ConnContext(first.Context(), firstConn))
+			first.RemoteAddr = "@"
+			second := httptest.NewRequest("GET", "/v1/static/file", nil)
+			second = second.WithContext(throttle.ConnContext(second.Context(), secondConn))
+			second.RemoteAddr = "@"
+
+			body = body[:100]
+			_, firstDone := serve(first)
+			_, secondDone := serve(second)
+
+			Eventually(firstDone).Should(BeClosed())
+			Eventually(secondDone).Should(BeClosed())
+		})
+
+		It("gives connections the updated allowance", func() {
+			throttler.Update(throttle.Config{PerConnectionBytesPerSecond: 300})
+
+			rec, done := serve(httptest.NewRequest("GET", "/v1/static/file", nil))
+			Eventually(done).Should(BeClosed())
+			Expect(rec.Body.Bytes()).To(Equal(body))
+		})
+
+		It("stops waiting when the client goes away", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			_, done := serve(httptest.NewRequest("GET", "/v1/static/file", nil).WithContext(ctx))
+
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+	})
+
+	Context("when a global limit is configured", func() {
+		BeforeEach(func() {
+			cfg.GlobalBytesPerSecond = 200
+			body = body[:200]
+		})
+
+		It("shares the allowance between connections", func() {
+			first := httptest.NewRequest("GET", "/v1/static/file", nil)
+			first.RemoteAddr = "10.0.0.1:1000"
+			_, firstDone := serve(first)
+			Eventually(firstDone).Should(BeClosed())
+
+			second := httptest.NewRequest("GET", "/v1/static/file", nil)
+			second.RemoteAddr = "10.0.0.2:1000"
+			_, secondDone := serve(second)
+
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			Consistently(secondDone).ShouldNot(BeClosed())
+
+			fakeClock.Increment(time.Second)
+			Eventually(secondDone).Should(BeClosed())
+		})
+
+		It("keeps counting what was sent when the limit changes", func() {
+			_, firstDone := serve(httptest.NewRequest("GET", "/v1/static/file", nil))
+			Eventually(firstDone).Should(BeClosed())
+
+			throttler.Update(throttle.Config{GlobalBytesPerSecond: 400})
+
+			_, secondDone := serve(httptest.NewRequest("GET", "/v1/static/file", nil))
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			Consistently(secondDone).ShouldNot(BeClosed())
+
+			fakeClock.Increment(500 * time.Millisecond)
+			Eventually(secondDone).Should(BeClosed())
+		})
+	})
+
+	Context("when path prefix limits are configured", func() {
+		BeforeEach(func() {
+			cfg.PathPrefixBytesPerSecond = map[string]int64{
+				"/v1/static/":       1000,
+				"/v1/static/large/": 100,
+			}
+		})
+
+		It("applies the most specific matching limit", func() {
+			_, done := serve(httptest.NewRequest("GET", "/v1/static/large/rootfs.tgz", nil))
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			Consistently(done).ShouldNot(BeClosed())
+
+			fakeClock.Increment(2 * time.Second)
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("leaves paths within a less restrictive prefix alone", func() {
+			rec, done := serve(httptest.NewRequest("GET", "/v1/static/small/buildpack.zip", nil))
+			Eventually(done).Should(BeClosed())
+			Expect(rec.Body.String()).To(Equal(strings.Repeat("a", 300)))
+		})
+	})
+})
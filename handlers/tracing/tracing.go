@@ -0,0 +1,197 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	RequestIDHeader   = "X-Vcap-Request-Id"
+	TraceparentHeader = "traceparent"
+	B3Header          = "b3"
+	B3TraceIDHeader   = "X-B3-TraceId"
+	B3SpanIDHeader    = "X-B3-SpanId"
+	B3SampledHeader   = "X-B3-Sampled"
+)
+
+// Context identifies a request and the span the file server serves it in.
+// IDs are lowercase hex: 32 characters for the trace, 16 for spans.
+// ParentSpanID is empty when the request did not carry a trace.
+type Context struct {
+	RequestID    string
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Sampled      bool
+}
+
+type contextKey struct{}
+
+// FromContext returns the trace context Wrap attached to a request, if any.
+func FromContext(ctx context.Context) (Context, bool) {
+	tc, ok := ctx.Value(contextKey{}).(Context)
+	return tc, ok
+}
+
+// Wrap returns a handler that reads the request ID and the trace context
+// from the request, generating whatever is missing, and echoes them in the
+// response. The trace context is taken from traceparent, then from the
+// single b3 header, then from the X-B3-* headers. The file server always
+// starts a span of its own, a child of the incoming one.
+func Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc, usedB3 := extract(r.Header)
+
+		header := w.Header()
+		header.Set(RequestIDHeader, tc.RequestID)
+		header.Set(TraceparentHeader, tc.traceparent())
+		if usedB3 {
+			header.Set(B3TraceIDHeader, tc.TraceID)
+			header.Set(B3SpanIDHeader, tc.SpanID)
+			header.Set(B3SampledHeader, sampledFlag(tc.Sampled, "1", "0"))
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, tc)))
+	})
+}
+
+func extract(header http.Header) (Context, bool) {
+	tc := Context{
+		RequestID: header.Get(RequestIDHeader),
+		Sampled:   true,
+	}
+	if tc.RequestID == "" {
+		tc.RequestID = newUUID()
+	}
+
+	usedB3 := false
+	if traceID, spanID, sampled, ok := parseTraceparent(header.Get(TraceparentHeader)); ok {
+		tc.TraceID, tc.ParentSpanID, tc.Sampled = traceID, spanID, sampled
+	} else if traceID, spanID, sampled, ok := parseB3(header.Get(B3Header)); ok {
+		tc.TraceID, tc.ParentSpanID, tc.Sampled = traceID, spanID, sampled
+		usedB3 = true
+	} else if traceID, spanID, sampled, ok := parseB3Multi(header); ok {
+		tc.TraceID, tc.ParentSpanID, tc.Sampled = traceID, spanID, sampled
+		usedB3 = true
+	} else {
+		tc.TraceID = randomHex(16)
+	}
+	tc.SpanID = randomHex(8)
+
+	return tc, usedB3
+}
+
+func (tc Context) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%s", tc.TraceID, tc.SpanID, sampledFlag(tc.Sampled, "01", "00"))
+}
+
+// parseTraceparent parses a W3C traceparent header of the form
+// version-traceid-parentid-flags.
+func parseTraceparent(value string) (traceID, spanID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[3]) != 2 {
+		return "", "", false, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", "", false, false
+	}
+	if !validID(parts[1], 32) || !validID(parts[2], 16) {
+		return "", "", false, false
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return "", "", false, false
+	}
+	return parts[1], parts[2], flags[0]&1 == 1, true
+}
+
+// parseB3 parses the single b3 header of the form
+// traceid-spanid[-sampled[-parentspanid]]. A header carrying only a sampling
+// decision has no trace to join and is ignored.
+func parseB3(value string) (traceID, spanID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 2 {
+		return "", "", false, false
+	}
+
+	traceID = padTraceID(parts[0])
+	if !validID(traceID, 32) || !validID(parts[1], 16) {
+		return "", "", false, false
+	}
+
+	sampled = true
+	if len(parts) > 2 {
+		sampled = parts[2] != "0"
+	}
+	return traceID, parts[1], sampled, true
+}
+
+func parseB3Multi(header http.Header) (traceID, spanID string, sampled, ok bool) {
+	traceID = padTraceID(header.Get(B3TraceIDHeader))
+	spanID = header.Get(B3SpanIDHeader)
+	if !validID(traceID, 32) || !validID(spanID, 16) {
+		return "", "", false, false
+	}
+
+	switch header.Get(B3SampledHeader) {
+	case "0", "false":
+		sampled = false
+	default:
+		sampled = true
+	}
+	return traceID, spanID, sampled, true
+}
+
+// padTraceID widens a 64-bit B3 trace ID to 128 bits.
+func padTraceID(id string) string {
+	if len(id) == 16 {
+		return strings.Repeat("0", 16) + id
+	}
+	return id
+}
+
+// validID reports whether id is lowercase hex of the given length and not
+// all zeros, which both W3C and B3 reserve as invalid.
+func validID(id string, length int) bool {
+	if len(id) != length || strings.Trim(id, "0") == "" {
+		return false
+	}
+	for _, c := range id {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func sampledFlag(sampled bool, yes, no string) string {
+	if sampled {
+		return yes
+	}
+	return no
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// newUUID returns a random (version 4) UUID, the form the Gorouter uses for
+// request IDs.
+func newUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,127 @@
+package tracing_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/fileserver/handlers/tracing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		request  *http.Request
+		recorder *httptest.ResponseRecorder
+		seen     tracing.Context
+		found    bool
+	)
+
+	serve := func() {
+		tracing.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen, found = tracing.FromContext(r.Context())
+		})).ServeHTTP(recorder, request)
+	}
+
+	BeforeEach(func() {
+		request = httptest.NewRequest("GET", "/v1/static/test", nil)
+		recorder = httptest.NewRecorder()
+		seen, found = tracing.Context{}, false
+	})
+
+	Context("when the request carries no IDs", func() {
+		It("generates them and echoes them in the response", func() {
+			serve()
+
+			Expect(found).To(BeTrue())
+			Expect(seen.RequestID).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+			Expect(seen.TraceID).To(MatchRegexp(`^[0-9a-f]{32}$`))
+			Expect(seen.SpanID).To(MatchRegexp(`^[0-9a-f]{16}$`))
+			Expect(seen.ParentSpanID).To(BeEmpty())
+			Expect(seen.Sampled).To(BeTrue())
+
+			Expect(recorder.Header().Get(tracing.RequestIDHeader)).To(Equal(seen.RequestID))
+			Expect(recorder.Header().Get(tracing.TraceparentHeader)).To(Equal("00-" + seen.TraceID + "-" + seen.SpanID + "-01"))
+			Expect(recorder.Header().Get(tracing.B3TraceIDHeader)).To(BeEmpty())
+		})
+	})
+
+	It("keeps the request ID it was given", func() {
+		request.Header.Set(tracing.RequestIDHeader, "some-request-id")
+		serve()
+
+		Expect(seen.RequestID).To(Equal("some-request-id"))
+		Expect(recorder.Header().Get(tracing.RequestIDHeader)).To(Equal("some-request-id"))
+	})
+
+	Context("when the request carries a traceparent", func() {
+		BeforeEach(func() {
+			request.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+			request.Header.Set(tracing.B3Header, "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1")
+		})
+
+		It("starts a child span in the same trace, preferring it to B3", func() {
+			serve()
+
+			Expect(seen.TraceID).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+			Expect(seen.ParentSpanID).To(Equal("00f067aa0ba902b7"))
+			Expect(seen.SpanID).NotTo(Equal("00f067aa0ba902b7"))
+			Expect(seen.Sampled).To(BeFalse())
+
+			Expect(recorder.Header().Get(tracing.TraceparentHeader)).To(Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-" + seen.SpanID + "-00"))
+			Expect(recorder.Header().Get(tracing.B3TraceIDHeader)).To(BeEmpty())
+		})
+	})
+
+	Context("when the request carries a single b3 header", func() {
+		BeforeEach(func() {
+			request.Header.Set(tracing.B3Header, "a3ce929d0e0e4736-e457b5a2e4d86bd1-0-05e3ac9a4f6e3b90")
+		})
+
+		It("joins the trace and echoes it in the B3 headers", func() {
+			serve()
+
+			Expect(seen.TraceID).To(Equal("0000000000000000a3ce929d0e0e4736"))
+			Expect(seen.ParentSpanID).To(Equal("e457b5a2e4d86bd1"))
+			Expect(seen.Sampled).To(BeFalse())
+
+			Expect(recorder.Header().Get(tracing.B3TraceIDHeader)).To(Equal(seen.TraceID))
+			Expect(recorder.Header().Get(tracing.B3SpanIDHeader)).To(Equal(seen.SpanID))
+			Expect(recorder.Header().Get(tracing.B3SampledHeader)).To(Equal("0"))
+		})
+	})
+
+	Context("when the request carries X-B3 headers", func() {
+		BeforeEach(func() {
+			request.Header.Set(tracing.B3TraceIDHeader, "80f198ee56343ba864fe8b2a57d3eff7")
+			request.Header.Set(tracing.B3SpanIDHeader, "e457b5a2e4d86bd1")
+			request.Header.Set(tracing.B3SampledHeader, "1")
+		})
+
+		It("joins the trace", func() {
+			serve()
+
+			Expect(seen.TraceID).To(Equal("80f198ee56343ba864fe8b2a57d3eff7"))
+			Expect(seen.ParentSpanID).To(Equal("e457b5a2e4d86bd1"))
+			Expect(seen.Sampled).To(BeTrue())
+			Expect(recorder.Header().Get(tracing.B3SampledHeader)).To(Equal("1"))
+		})
+	})
+
+	DescribeTable("ignores malformed trace headers",
+		func(header, value string) {
+			request.Header.Set(header, value)
+			serve()
+
+			Expect(seen.TraceID).To(MatchRegexp(`^[0-9a-f]{32}$`))
+			Expect(seen.ParentSpanID).To(BeEmpty())
+		},
+		Entry("traceparent with a short trace ID", tracing.TraceparentHeader, "00-4bf92f3577b34da6-00f067aa0ba902b7-01"),
+		Entry("traceparent with an all-zero trace ID", tracing.TraceparentHeader, "00-00000000000000000000000000000000-00f067aa0ba902b7-01"),
+		Entry("traceparent with uppercase hex", tracing.TraceparentHeader, "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"),
+		Entry("traceparent with the forbidden version", tracing.TraceparentHeader, "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+		Entry("b3 carrying only a sampling decision", tracing.B3Header, "1"),
+	)
+})
@@ -0,0 +1 @@
+package watchdog // import "code.cloudfoundry.org/fileserver/handlers/watchdog"
@@ -0,0 +1,192 @@
+package watchdog
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/durationjson"
+	"code.cloudfoundry.org/fileserver/handlers/chunked"
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	DefaultWindow = 30 * time.Second
+
+	// samplesPerWindow is how many times per window the transfer rate is
+	// checked.
+	samplesPerWindow = 10
+
+	// maxReadFromChunkSize and minReadFromChunkSize bound how much of a
+	// sendfile transfer can go by before the count of bytes sent is updated.
+	// Within them, a chunk is the least a client has to send between two
+	// samples, so that a client sending at the minimum rate is seen doing so.
+	maxReadFromChunkSize = 1024 * 1024
+	minReadFromChunkSize = 4 * 1024
+)
+
+// Config holds the minimum rate a response has to be sent at, averaged over
+// Window. A MinBytesPerSecond of zero disables the watchdog. The rate should
+// be set well below any bandwidth limit, since throttled responses are
+// measured like any other.
+type Config struct {
+	MinBytesPerSecond int64                 `json:"min_bytes_per_second,omitempty"`
+	Window            durationjson.Duration `json:"window,omitempty"`
+}
+
+type Watchdog struct {
+	logger lager.Logger
+	clock  clock.Clock
+
+	evictions uint64
+
+	mu     sync.RWMutex
+	limits *limits
+}
+
+// limits hold the minimum rate in place when a response started, which it is
+// watched under until it ends, whatever updates come in the meantime.
+type limits struct {
+	minBytesPerSecond int64
+	window            time.Duration
+	chunkSize         int64
+}
+
+func New(logger lager.Logger, clock clock.Clock, config Config) *Watchdog {
+	d := &Watchdog{
+		logger: logger.Session("transfer-watchdog"),
+		clock:  clock,
+	}
+	d.Update(config)
+	return d
+}
+
+// Update applies a new minimum rate to the responses that follow.
+func (d *Watchdog) Update(config Config) {
+	window := time.Duration(config.Window)
+	if window <= 0 {
+		window = DefaultWindow
+	}
+
+	chunkSize := int64(float64(config.MinBytesPerSecond)*window.Seconds()) / samplesPerWindow
+	if chunkSize > maxReadFromChunkSize {
+		chunkSize = maxReadFromChunkSize
+	}
+	if chunkSize < minReadFromChunkSize {
+		chunkSize = minReadFromChunkSize
+	}
+
+	d.mu.Lock()
+	d.limits = &limits{
+		minBytesPerSecond: config.MinBytesPerSecond,
+		window:            window,
+		chunkSize:         chunkSize,
+	}
+	d.mu.Unlock()
+}
+
+// Wrap returns a handler that aborts responses whose transfer rate drops
+// below the configured minimum. The clock only starts once the first byte
+// of the body is written, so time spent computing checksums is not held
+// against the client.
+func (d *Watchdog) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.RLock()
+		l := d.limits
+		d.mu.RUnlock()
+
+		if l.minBytesPerSecond <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &countingWriter{Writer: chunked.Writer{ResponseWriter: w}, chunkSize: l.chunkSize}
+		done := make(chan struct{})
+		defer close(done)
+
+		go d.watch(w, r, cw, l, done)
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Evictions returns the number of responses aborted since the watchdog was
+// created.
+func (d *Watchdog) Evictions() uint64 {
+	return atomic.LoadUint64(&d.evictions)
+}
+
+func (d *Watchdog) watch(w http.ResponseWriter, r *http.Request, cw *countingWriter, l *limits, done <-chan struct{}) {
+	ticker := d.clock.NewTicker(l.window / samplesPerWindow)
+	defer ticker.Stop()
+
+	minBytes := int64(float64(l.minBytesPerSecond) * l.window.Seconds())
+	samples := make([]int64, 0, samplesPerWindow+1)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+		}
+
+		sent := cw.count()
+		if sent == 0 {
+			continue
+		}
+
+		if len(samples) == cap(samples) {
+			samples = append(samples[:0], samples[1:]...)
+		}
+		samples = append(samples, sent)
+		if len(samples) < cap(samples) {
+			continue
+		}
+
+		if inWindow := sent - samples[0]; inWindow < minBytes {
+			atomic.AddUint64(&d.evictions, 1)
+			d.logger.Info("evicting-stalled-client", lager.Data{
+				"uri":            r.URL.RequestURI(),
+				"remote-addr":    r.RemoteAddr,
+				"bytes-sent":     sent,
+				"bytes-window":   inWindow,
+				"window":         l.window.String(),
+				"min-bytes-rate": l.minBytesPerSecond,
+			})
+
+			err := http.NewResponseController(w).SetWriteDeadline(time.Now())
+			if err != nil {
+				d.logger.Error("failed-to-abort-response", err)
+			}
+			return
+		}
+	}
+}
+
+type countingWriter struct {
+	chunked.Writer
+	chunkSize int64
+	written   int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	atomic.AddInt64(&w.written, int64(n))
+	return n, err
+}
+
+// ReadFrom counts the transfer one chunk at a time, keeping the sendfile path
+// of the underlying writer available.
+func (w *countingWriter) ReadFrom(src io.Reader) (int64, error) {
+	return w.Copy(w, src, w.chunkSize, func(n int64) error {
+		atomic.AddInt64(&w.written, n)
+		return nil
+	})
+}
+
+func (w *countingWriter) count() int64 {
+	return atomic.LoadInt64(&w.written)
+}
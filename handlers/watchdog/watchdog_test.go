@@ -0,0 +1,179 @@
+package watchdog_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/durationjson"
+	"code.cloudfoundry.org/fileserver/handlers/watchdog"
+	"code.cloudfoundry.org/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Watchdog", func() {
+	var (
+		logger     *lagertest.TestLogger
+		cfg        watchdog.Config
+		dog        *watchdog.Watchdog
+		handler    http.Handler
+		server     *httptest.Server
+		writeErrCh chan error
+	)
+
+	// writeEvery writes chunk every interval for the given duration and
+	// reports the first write error, if any.
+	writeEvery := func(delay, interval, duration time.Duration, chunk string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			deadline := time.Now().Add(duration)
+			for time.Now().Before(deadline) {
+				if _, err := w.Write([]byte(chunk)); err != nil {
+					writeErrCh <- err
+					return
+				}
+				w.(http.Flusher).Flush()
+				time.Sleep(interval)
+			}
+			writeErrCh <- nil
+		})
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		writeErrCh = make(chan error, 1)
+		cfg = watchdog.Config{
+			MinBytesPerSecond: 1000,
+			Window:            durationjson.Duration(200 * time.Millisecond),
+		}
+	})
+
+	JustBeforeEach(func() {
+		dog = watchdog.New(logger, clock.NewClock(), cfg)
+		server = httptest.NewServer(dog.Wrap(handler))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	get := func() {
+		resp, err := http.Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		ioutil.ReadAll(resp.Body)
+	}
+
+	Context("when the response is sent faster than the minimum rate", func() {
+		BeforeEach(func() {
+			handler = writeEvery(0, 10*time.Millisecond, 500*time.Millisecond, strings.Repeat("a", 100))
+		})
+
+		It("lets the response finish", func() {
+			get()
+			Expect(<-writeErrCh).NotTo(HaveOccurred())
+			Expect(dog.Evictions()).To(BeZero())
+		})
+	})
+
+	Context("when the response is sent slower than the minimum rate", func() {
+		BeforeEach(func() {
+			handler = writeEvery(0, 50*time.Millisecond, 2*time.Second, "a")
+		})
+
+		It("aborts the response, logs and counts the eviction", func() {
+			get()
+			Expect(<-writeErrCh).To(HaveOccurred())
+			Expect(dog.Evictions()).To(BeEquivalentTo(1))
+			Expect(logger).To(gbytes.Say("evicting-stalled-client"))
+		})
+	})
+
+	Context("when a slow client is sent a body through ReadFrom faster than the minimum rate", func() {
+		BeforeEach(func() {
+			cfg = watchdog.Config{
+				MinBytesPerSecond: 20000,
+				Window:            durationjson.Duration(time.Second),
+			}
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("a"))
+				_, err := io.Copy(w, &slowReader{chunk: 1000, interval: 20 * time.Millisecond, duration: 2 * time.Second})
+				writeErrCh <- err
+			})
+		})
+
+		It("counts the bytes sent often enough not to evict it", func() {
+			get()
+			Expect(<-writeErrCh).NotTo(HaveOccurred())
+			Expect(dog.Evictions()).To(BeZero())
+		})
+	})
+
+	Context("when the handler takes a while before writing the first byte", func() {
+		BeforeEach(func() {
+			handler = writeEvery(500*time.Millisecond, 10*time.Millisecond, 300*time.Millisecond, strings.Repeat("a", 100))
+		})
+
+		It("does not hold that time against the client", func() {
+			get()
+			Expect(<-writeErrCh).NotTo(HaveOccurred())
+			Expect(dog.Evictions()).To(BeZero())
+		})
+	})
+
+	Context("when no minimum rate is configured", func() {
+		BeforeEach(func() {
+			cfg.MinBytesPerSecond = 0
+			handler = writeEvery(0, 50*time.Millisecond, 400*time.Millisecond, "a")
+		})
+
+		It("lets slow responses finish", func() {
+			get()
+			Expect(<-writeErrCh).NotTo(HaveOccurred())
+		})
+
+		It("aborts slow responses once a minimum rate is applied", func() {
+			dog.Update(watchdog.Config{
+				MinBytesPerSecond: 1000,
+				Window:            durationjson.Duration(200 * time.Millisecond),
+			})
+
+			get()
+			Expect(<-writeErrCh).To(HaveOccurred())
+			Expect(dog.Evictions()).To(BeEquivalentTo(1))
+		})
+	})
+})
+
+// slowReader yields chunk bytes every interval until duration has passed.
+type slowReader struct {
+	chunk    int
+	interval time.Duration
+	duration time.Duration
+	deadline time.Time
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.deadline.IsZero() {
+		r.deadline = time.Now().Add(r.duration)
+	}
+	if time.Now().After(r.deadline) {
+		return 0, io.EOF
+	}
+
+	time.Sleep(r.interval)
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	for i := range p {
+		p[i] = 'a'
+	}
+	return len(p), nil
+}
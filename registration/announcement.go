@@ -0,0 +1,32 @@
+package registration
+
+import (
+	"code.cloudfoundry.org/fileserver/handlers/health"
+)
+
+const (
+	registerEvent   = "register"
+	healthEvent     = "health"
+	deregisterEvent = "deregister"
+)
+
+// announcement is what the file and HTTP registrars publish about the
+// instance. Consumers should consider it withdrawn once TTL has passed
+// without a newer one.
+type announcement struct {
+	Event    string          `json:"event"`
+	Instance Instance        `json:"instance"`
+	TTL      string          `json:"ttl"`
+	Ready    bool            `json:"ready"`
+	Checks   []health.Result `json:"checks,omitempty"`
+}
+
+func newAnnouncement(event string, instance Instance, report health.Report) announcement {
+	return announcement{
+		Event:    event,
+		Instance: instance,
+		TTL:      instance.TTL.String(),
+		Ready:    report.Ready,
+		Checks:   report.Checks,
+	}
+}
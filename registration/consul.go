@@ -0,0 +1,48 @@
+package registration
+
+import (
+	"code.cloudfoundry.org/consuladapter"
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"github.com/hashicorp/consul/api"
+)
+
+// ConsulRegistrar registers the instance with the local Consul agent, with a
+// TTL check that passes or fails with the readiness checks, so that Consul
+// stops routing to a server that cannot serve files.
+type ConsulRegistrar struct {
+	client   consuladapter.Client
+	instance Instance
+}
+
+func NewConsulRegistrar(client consuladapter.Client, instance Instance) *ConsulRegistrar {
+	return &ConsulRegistrar{client: client, instance: instance}
+}
+
+func (r *ConsulRegistrar) Register() error {
+	return r.client.Agent().ServiceRegister(&api.AgentServiceRegistration{
+		ID:   r.instance.ID,
+		Name: r.instance.Name,
+		Tags: r.instance.Tags,
+		Port: r.instance.Port,
+		Meta: r.instance.Meta,
+		Check: &api.AgentServiceCheck{
+			TTL: r.instance.TTL.String(),
+		},
+	})
+}
+
+func (r *ConsulRegistrar) UpdateHealth(report health.Report) error {
+	if report.Ready {
+		return r.client.Agent().PassTTL(r.checkID(), "")
+	}
+	return r.client.Agent().FailTTL(r.checkID(), failures(report))
+}
+
+func (r *ConsulRegistrar) Deregister() error {
+	return r.client.Agent().ServiceDeregister(r.instance.ID)
+}
+
+// checkID returns the ID Consul gives the check of the registered service.
+func (r *ConsulRegistrar) checkID() string {
+	return "service:" + r.instance.ID
+}
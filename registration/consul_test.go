@@ -0,0 +1,78 @@
+package registration_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/consuladapter/fakes"
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/fileserver/registration"
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConsulRegistrar", func() {
+	var (
+		agent     *fakes.FakeAgent
+		registrar *registration.ConsulRegistrar
+	)
+
+	BeforeEach(func() {
+		agent = &fakes.FakeAgent{}
+		client := &fakes.FakeClient{}
+		client.AgentReturns(agent)
+
+		registrar = registration.NewConsulRegistrar(client, registration.Instance{
+			ID:   "file-server-0",
+			Name: "file-server",
+			Port: 8080,
+			Tags: []string{"z1"},
+			Meta: map[string]string{"version": "1.2.3"},
+			TTL:  20 * time.Second,
+		})
+	})
+
+	It("registers the service with a TTL check", func() {
+		Expect(registrar.Register()).To(Succeed())
+		Expect(agent.ServiceRegisterCallCount()).To(Equal(1))
+		Expect(agent.ServiceRegisterArgsForCall(0)).To(Equal(&api.AgentServiceRegistration{
+			ID:    "file-server-0",
+			Name:  "file-server",
+			Port:  8080,
+			Tags:  []string{"z1"},
+			Meta:  map[string]string{"version": "1.2.3"},
+			Check: &api.AgentServiceCheck{TTL: "20s"},
+		}))
+	})
+
+	It("passes the check while ready", func() {
+		Expect(registrar.UpdateHealth(health.Report{Ready: true})).To(Succeed())
+		Expect(agent.PassTTLCallCount()).To(Equal(1))
+		checkID, _ := agent.PassTTLArgsForCall(0)
+		Expect(checkID).To(Equal("service:file-server-0"))
+	})
+
+	It("fails the check with the failed checks while not ready", func() {
+		Expect(registrar.UpdateHealth(health.Report{Checks: []health.Result{
+			{Name: "static-directory", OK: true},
+			{Name: "listeners", Error: "not done yet"},
+		}})).To(Succeed())
+
+		Expect(agent.FailTTLCallCount()).To(Equal(1))
+		checkID, note := agent.FailTTLArgsForCall(0)
+		Expect(checkID).To(Equal("service:file-server-0"))
+		Expect(note).To(Equal("listeners: not done yet"))
+	})
+
+	It("returns the agent's errors", func() {
+		agent.PassTTLReturns(errors.New("unknown check"))
+		Expect(registrar.UpdateHealth(health.Report{Ready: true})).To(MatchError("unknown check"))
+	})
+
+	It("deregisters the service", func() {
+		Expect(registrar.Deregister()).To(Succeed())
+		Expect(agent.ServiceDeregisterArgsForCall(0)).To(Equal("file-server-0"))
+	})
+})
@@ -0,0 +1,66 @@
+package registration
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/fileserver/handlers/health"
+)
+
+// FileConfig names the file the file registrar announces the instance in.
+type FileConfig struct {
+	Path string `json:"path,omitempty"`
+}
+
+// FileRegistrar announces the instance by writing it to a JSON file, for a
+// local agent to pick up, and withdraws it by removing the file. The file is
+// replaced atomically, so that readers never see a partial announcement.
+type FileRegistrar struct {
+	path     string
+	instance Instance
+}
+
+func NewFileRegistrar(config FileConfig, instance Instance) *FileRegistrar {
+	return &FileRegistrar{path: config.Path, instance: instance}
+}
+
+func (r *FileRegistrar) Register() error {
+	return r.write(newAnnouncement(registerEvent, r.instance, health.Report{}))
+}
+
+func (r *FileRegistrar) UpdateHealth(report health.Report) error {
+	return r.write(newAnnouncement(healthEvent, r.instance, report))
+}
+
+func (r *FileRegistrar) Deregister() error {
+	err := os.Remove(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (r *FileRegistrar) write(a announcement) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), filepath.Base(r.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), r.path)
+}
@@ -0,0 +1,79 @@
+package registration_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/fileserver/registration"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FileRegistrar", func() {
+	var (
+		tmpDir    string
+		path      string
+		registrar *registration.FileRegistrar
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "registration-test")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(tmpDir, "file-server.json")
+
+		registrar = registration.NewFileRegistrar(registration.FileConfig{Path: path}, registration.Instance{
+			ID:   "file-server-0",
+			Name: "file-server",
+			Port: 8080,
+			TTL:  20 * time.Second,
+		})
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("announces the instance, not ready yet, when registering", func() {
+		Expect(registrar.Register()).To(Succeed())
+		Expect(ioutil.ReadFile(path)).To(MatchJSON(`{
+			"event": "register",
+			"instance": {"id": "file-server-0", "name": "file-server", "port": 8080},
+			"ttl": "20s",
+			"ready": false
+		}`))
+	})
+
+	It("rewrites the announcement with the health", func() {
+		Expect(registrar.Register()).To(Succeed())
+		Expect(registrar.UpdateHealth(health.Report{Ready: true, Checks: []health.Result{{Name: "listeners", OK: true}}})).To(Succeed())
+		Expect(ioutil.ReadFile(path)).To(MatchJSON(`{
+			"event": "health",
+			"instance": {"id": "file-server-0", "name": "file-server", "port": 8080},
+			"ttl": "20s",
+			"ready": true,
+			"checks": [{"name": "listeners", "ok": true}]
+		}`))
+
+		files, err := ioutil.ReadDir(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+	})
+
+	It("removes the announcement when deregistering", func() {
+		Expect(registrar.Register()).To(Succeed())
+		Expect(registrar.Deregister()).To(Succeed())
+		Expect(path).NotTo(BeAnExistingFile())
+
+		Expect(registrar.Deregister()).To(Succeed())
+	})
+
+	It("fails when the directory does not exist", func() {
+		registrar = registration.NewFileRegistrar(registration.FileConfig{Path: filepath.Join(tmpDir, "missing", "file-server.json")}, registration.Instance{})
+		Expect(registrar.Register()).NotTo(Succeed())
+	})
+})
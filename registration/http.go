@@ -0,0 +1,66 @@
+package registration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/health"
+)
+
+const callbackTimeout = 10 * time.Second
+
+// HTTPConfig holds the URL the HTTP registrar posts its announcements to.
+type HTTPConfig struct {
+	URL string `json:"url,omitempty"`
+}
+
+// HTTPRegistrar posts a JSON announcement to a callback URL when the
+// instance is registered, every time its health is reported, and when it is
+// deregistered. The event field tells them apart. Any response other than
+// 2xx is an error.
+type HTTPRegistrar struct {
+	client   *http.Client
+	url      string
+	instance Instance
+}
+
+func NewHTTPRegistrar(config HTTPConfig, instance Instance) *HTTPRegistrar {
+	return &HTTPRegistrar{
+		client:   &http.Client{Timeout: callbackTimeout},
+		url:      config.URL,
+		instance: instance,
+	}
+}
+
+func (r *HTTPRegistrar) Register() error {
+	return r.post(newAnnouncement(registerEvent, r.instance, health.Report{}))
+}
+
+func (r *HTTPRegistrar) UpdateHealth(report health.Report) error {
+	return r.post(newAnnouncement(healthEvent, r.instance, report))
+}
+
+func (r *HTTPRegistrar) Deregister() error {
+	return r.post(newAnnouncement(deregisterEvent, r.instance, health.Report{}))
+}
+
+func (r *HTTPRegistrar) post(a announcement) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.client.Post(r.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("callback responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
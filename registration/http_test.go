@@ -0,0 +1,81 @@
+package registration_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/fileserver/registration"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTPRegistrar", func() {
+	var (
+		callback  *httptest.Server
+		status    int
+		bodies    chan string
+		registrar *registration.HTTPRegistrar
+	)
+
+	BeforeEach(func() {
+		status = http.StatusNoContent
+		bodies = make(chan string, 10)
+	})
+
+	JustBeforeEach(func() {
+		callback = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Method).To(Equal("POST"))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			body, _ := ioutil.ReadAll(r.Body)
+			bodies <- string(body)
+			w.WriteHeader(status)
+		}))
+
+		registrar = registration.NewHTTPRegistrar(registration.HTTPConfig{URL: callback.URL}, registration.Instance{
+			ID:   "file-server-0",
+			Name: "file-server",
+			Port: 8080,
+			TTL:  20 * time.Second,
+		})
+	})
+
+	AfterEach(func() {
+		callback.Close()
+	})
+
+	It("posts an announcement for every event", func() {
+		Expect(registrar.Register()).To(Succeed())
+		Expect(<-bodies).To(MatchJSON(`{
+			"event": "register",
+			"instance": {"id": "file-server-0", "name": "file-server", "port": 8080},
+			"ttl": "20s",
+			"ready": false
+		}`))
+
+		Expect(registrar.UpdateHealth(health.Report{Checks: []health.Result{{Name: "listeners", Error: "not done yet"}}})).To(Succeed())
+		Expect(<-bodies).To(MatchJSON(`{
+			"event": "health",
+			"instance": {"id": "file-server-0", "name": "file-server", "port": 8080},
+			"ttl": "20s",
+			"ready": false,
+			"checks": [{"name": "listeners", "ok": false, "error": "not done yet"}]
+		}`))
+
+		Expect(registrar.Deregister()).To(Succeed())
+		Expect(<-bodies).To(ContainSubstring(`"event":"deregister"`))
+	})
+
+	Context("when the callback does not respond with 2xx", func() {
+		BeforeEach(func() {
+			status = http.StatusServiceUnavailable
+		})
+
+		It("fails", func() {
+			Expect(registrar.Register()).To(MatchError("callback responded with status 503"))
+		})
+	})
+})
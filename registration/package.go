@@ -0,0 +1 @@
+package registration // import "code.cloudfoundry.org/fileserver/registration"
@@ -0,0 +1,248 @@
+package registration
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/durationjson"
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	NoneBackend   = "none"
+	ConsulBackend = "consul"
+	FileBackend   = "file"
+	HTTPBackend   = "http"
+)
+
+const (
+	DefaultServiceName = "file-server"
+	DefaultTTL         = 20 * time.Second
+
+	// PendingCallTimeout is how long a signaled Runner waits for a call to
+	// the registrar that is still in progress before deregistering.
+	PendingCallTimeout = 5 * time.Second
+)
+
+// Config selects the service discovery backend and describes the instance it
+// announces. The service ID defaults to the service name. The TTL is how long
+// an announcement stays valid; the health is reported every half TTL.
+type Config struct {
+	Backend     string                `json:"backend,omitempty"`
+	ServiceName string                `json:"service_name,omitempty"`
+	ServiceID   string                `json:"service_id,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Meta        map[string]string     `json:"meta,omitempty"`
+	TTL         durationjson.Duration `json:"ttl,omitempty"`
+
+	File FileConfig `json:"file"`
+	HTTP HTTPConfig `json:"http"`
+}
+
+// Instance is what a Registrar announces.
+type Instance struct {
+	ID   string            `json:"id"`
+	Name string            `json:"name"`
+	Port int               `json:"port"`
+	Tags []string          `json:"tags,omitempty"`
+	Meta map[string]string `json:"meta,omitempty"`
+	TTL  time.Duration     `json:"-"`
+}
+
+// Instance returns the instance listening on port. The configured meta is
+// added to the given one, overriding it.
+func (c Config) Instance(port int, meta map[string]string) Instance {
+	name := c.ServiceName
+	if name == "" {
+		name = DefaultServiceName
+	}
+
+	id := c.ServiceID
+	if id == "" {
+		id = name
+	}
+
+	ttl := time.Duration(c.TTL)
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	merged := map[string]string{}
+	for k, v := range meta {
+		merged[k] = v
+	}
+	for k, v := range c.Meta {
+		merged[k] = v
+	}
+
+	return Instance{
+		ID:   id,
+		Name: name,
+		Port: port,
+		Tags: c.Tags,
+		Meta: merged,
+		TTL:  ttl,
+	}
+}
+
+// A Registrar announces an instance to a service discovery backend, keeps
+// the backend informed of its health, and withdraws it.
+type Registrar interface {
+	Register() error
+	UpdateHealth(report health.Report) error
+	Deregister() error
+}
+
+// None is the Registrar of a server that is not announced anywhere.
+type None struct{}
+
+func (None) Register() error                  { return nil }
+func (None) UpdateHealth(health.Report) error { return nil }
+func (None) Deregister() error                { return nil }
+
+// Runner registers the instance, then runs the readiness checks every half
+// TTL and reports their result to the registrar. It becomes ready once
+// registered, registers again after the registrar failed to report, and
+// deregisters when signaled. When signaled during a call to the registrar, it
+// first waits up to PendingCallTimeout for the call to return, so that the
+// backend does not hear from the instance after it was deregistered.
+type Runner struct {
+	logger        lager.Logger
+	registrar     Registrar
+	checker       *health.Checker
+	clock         clock.Clock
+	ttl           time.Duration
+	retryInterval time.Duration
+}
+
+func NewRunner(
+	logger lager.Logger,
+	registrar Registrar,
+	checker *health.Checker,
+	clock clock.Clock,
+	ttl time.Duration,
+	retryInterval time.Duration,
+) *Runner {
+	return &Runner{
+		logger:        logger,
+		registrar:     registrar,
+		checker:       checker,
+		clock:         clock,
+		ttl:           ttl,
+		retryInterval: retryInterval,
+	}
+}
+
+func (r *Runner) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	logger := r.logger.Session("registration")
+	logger.Info("starting")
+	defer logger.Info("finished")
+
+	registered := false
+	passing := false
+
+	// The registrar is called from a goroutine, so that a backend that is
+	// slow to answer does not hold up shutdown.
+	results := make(chan tickResult, 1)
+	pending := false
+
+	timer := r.clock.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C():
+			pending = true
+			go func(registered bool) {
+				results <- r.tick(logger, registered)
+			}(registered)
+
+		case result := <-results:
+			pending = false
+			registered = result.registered
+			if registered && ready != nil {
+				close(ready)
+				ready = nil
+			}
+			if !result.updated {
+				registered = false
+				timer.Reset(r.retryInterval)
+				continue
+			}
+
+			if result.report.Ready != passing {
+				logger.Info("health-changed", lager.Data{"passing": result.report.Ready, "checks": result.report.Checks})
+				passing = result.report.Ready
+			}
+			timer.Reset(r.ttl / 2)
+
+		case <-signals:
+			if pending {
+				registered = r.awaitPending(logger, results)
+			}
+			if !registered {
+				return nil
+			}
+			logger.Info("deregistering")
+			return r.registrar.Deregister()
+		}
+	}
+}
+
+// awaitPending waits for the call to the registrar in progress, and returns
+// whether the instance may be registered once it is over. A call that takes
+// too long is given up on, and the instance deregistered in case it completes.
+func (r *Runner) awaitPending(logger lager.Logger, results <-chan tickResult) bool {
+	logger.Info("waiting-for-pending-call")
+
+	timer := r.clock.NewTimer(PendingCallTimeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-results:
+		return result.registered
+	case <-timer.C():
+		logger.Info("abandoning-pending-call")
+		return true
+	}
+}
+
+type tickResult struct {
+	registered bool
+	updated    bool
+	report     health.Report
+}
+
+// tick registers the instance unless it already is, then runs the readiness
+// checks and reports their result.
+func (r *Runner) tick(logger lager.Logger, registered bool) tickResult {
+	if !registered {
+		if err := r.registrar.Register(); err != nil {
+			logger.Error("failed-to-register", err)
+			return tickResult{}
+		}
+		logger.Info("registered")
+	}
+
+	report := r.checker.Run()
+	if err := r.registrar.UpdateHealth(report); err != nil {
+		logger.Error("failed-to-update-health", err)
+		return tickResult{registered: true}
+	}
+	return tickResult{registered: true, updated: true, report: report}
+}
+
+// failures describes the failed checks of report.
+func failures(report health.Report) string {
+	var notes []string
+	for _, result := range report.Checks {
+		if !result.OK {
+			notes = append(notes, fmt.Sprintf("%s: %s", result.Name, result.Error))
+		}
+	}
+	return strings.Join(notes, "; ")
+}
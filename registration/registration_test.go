@@ -0,0 +1,252 @@
+package registration_test
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/durationjson"
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/fileserver/registration"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeRegistrar struct {
+	block chan struct{}
+
+	mu           sync.Mutex
+	registerErrs []error
+	updateErrs   []error
+	registered   int
+	reports      []health.Report
+	deregistered int
+}
+
+func (r *fakeRegistrar) Register() error {
+	if r.block != nil {
+		<-r.block
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.registered++
+	return pop(&r.registerErrs)
+}
+
+func (r *fakeRegistrar) UpdateHealth(report health.Report) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reports = append(r.reports, report)
+	return pop(&r.updateErrs)
+}
+
+func (r *fakeRegistrar) Deregister() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deregistered++
+	return nil
+}
+
+func (r *fakeRegistrar) Registered() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.registered
+}
+
+func (r *fakeRegistrar) Reports() []health.Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]health.Report(nil), r.reports...)
+}
+
+func (r *fakeRegistrar) Deregistered() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.deregistered
+}
+
+func pop(errs *[]error) error {
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+var _ = Describe("Registration", func() {
+	Describe("Config", func() {
+		It("defaults the service name, ID and TTL", func() {
+			instance := registration.Config{}.Instance(8080, nil)
+			Expect(instance).To(Equal(registration.Instance{
+				ID:   "file-server",
+				Name: "file-server",
+				Port: 8080,
+				Meta: map[string]string{},
+				TTL:  20 * time.Second,
+			}))
+		})
+
+		It("uses the configured values, and lets the configured meta win", func() {
+			instance := registration.Config{
+				ServiceName: "blobstore",
+				ServiceID:   "blobstore-z1",
+				Tags:        []string{"z1"},
+				Meta:        map[string]string{"zone": "z1", "version": "custom"},
+				TTL:         durationjson.Duration(10 * time.Second),
+			}.Instance(8443, map[string]string{"version": "1.2.3", "tls_port": "8443"})
+
+			Expect(instance).To(Equal(registration.Instance{
+				ID:   "blobstore-z1",
+				Name: "blobstore",
+				Tags: []string{"z1"},
+				Port: 8443,
+				Meta: map[string]string{"zone": "z1", "version": "custom", "tls_port": "8443"},
+				TTL:  10 * time.Second,
+			}))
+		})
+	})
+
+	Describe("Runner", func() {
+		const (
+			ttl           = 20 * time.Second
+			retryInterval = 5 * time.Second
+		)
+
+		var (
+			registrar *fakeRegistrar
+			fakeClock *fakeclock.FakeClock
+			listeners *health.Gate
+			runner    ifrit.Runner
+			process   ifrit.Process
+		)
+
+		BeforeEach(func() {
+			registrar = &fakeRegistrar{}
+			fakeClock = fakeclock.NewFakeClock(time.Now())
+			listeners = health.NewGate("listeners")
+			listeners.Open()
+
+			checker := health.NewChecker(listeners.Check())
+			runner = registration.NewRunner(lagertest.NewTestLogger("test"), registrar, checker, fakeClock, ttl, retryInterval)
+		})
+
+		JustBeforeEach(func() {
+			process = ifrit.Background(runner)
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		It("registers, reports the health and becomes ready", func() {
+			Eventually(process.Ready()).Should(BeClosed())
+			Expect(registrar.Registered()).To(Equal(1))
+			Expect(registrar.Reports()).To(Equal([]health.Report{
+				{Ready: true, Checks: []health.Result{{Name: "listeners", OK: true}}},
+			}))
+		})
+
+		It("reports the health every half TTL", func() {
+			Eventually(process.Ready()).Should(BeClosed())
+			listeners.Close("stopped")
+
+			fakeClock.WaitForWatcherAndIncrement(ttl / 2)
+			Eventually(registrar.Reports).Should(HaveLen(2))
+			Expect(registrar.Reports()[1].Ready).To(BeFalse())
+			Expect(registrar.Registered()).To(Equal(1))
+		})
+
+		It("deregisters when signaled", func() {
+			Eventually(process.Ready()).Should(BeClosed())
+
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+			Expect(registrar.Deregistered()).To(Equal(1))
+		})
+
+		Context("when the backend cannot be reached", func() {
+			BeforeEach(func() {
+				registrar.registerErrs = []error{errors.New("connection refused")}
+			})
+
+			It("retries until it registers", func() {
+				Eventually(registrar.Registered).Should(Equal(1))
+				Consistently(process.Ready()).ShouldNot(BeClosed())
+
+				fakeClock.WaitForWatcherAndIncrement(retryInterval)
+				Eventually(process.Ready()).Should(BeClosed())
+				Expect(registrar.Registered()).To(Equal(2))
+			})
+
+			It("does not deregister when signaled", func() {
+				Eventually(registrar.Registered).Should(Equal(1))
+
+				process.Signal(os.Interrupt)
+				Eventually(process.Wait()).Should(Receive(BeNil()))
+				Expect(registrar.Deregistered()).To(Equal(0))
+			})
+		})
+
+		Context("when the backend is slow to answer", func() {
+			BeforeEach(func() {
+				registrar.block = make(chan struct{})
+			})
+
+			AfterEach(func() {
+				select {
+				case <-registrar.block:
+				default:
+					close(registrar.block)
+				}
+			})
+
+			It("waits for the pending registration before deregistering when signaled", func() {
+				Consistently(process.Ready()).ShouldNot(BeClosed())
+
+				process.Signal(os.Interrupt)
+				Consistently(process.Wait()).ShouldNot(Receive())
+				Expect(registrar.Deregistered()).To(Equal(0))
+
+				close(registrar.block)
+				Eventually(process.Wait()).Should(Receive(BeNil()))
+				Expect(registrar.Registered()).To(Equal(1))
+				Expect(registrar.Deregistered()).To(Equal(1))
+			})
+
+			It("deregisters anyway once the pending registration takes too long", func() {
+				Consistently(process.Ready()).ShouldNot(BeClosed())
+
+				process.Signal(os.Interrupt)
+				fakeClock.WaitForWatcherAndIncrement(registration.PendingCallTimeout)
+				Eventually(process.Wait()).Should(Receive(BeNil()))
+				Expect(registrar.Registered()).To(Equal(0))
+				Expect(registrar.Deregistered()).To(Equal(1))
+			})
+		})
+
+		Context("when the health cannot be reported", func() {
+			BeforeEach(func() {
+				registrar.updateErrs = []error{errors.New("unknown check")}
+			})
+
+			It("registers again", func() {
+				Eventually(process.Ready()).Should(BeClosed())
+
+				fakeClock.WaitForWatcherAndIncrement(retryInterval)
+				Eventually(registrar.Registered).Should(Equal(2))
+				Eventually(registrar.Reports).Should(HaveLen(2))
+			})
+		})
+	})
+})
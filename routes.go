@@ -3,9 +3,27 @@ package fileserver
 import "github.com/tedsuo/rata"
 
 const (
-	StaticRoute = "Static"
+	StaticRoute      = "Static"
+	HealthLiveRoute  = "HealthLive"
+	HealthReadyRoute = "HealthReady"
 )
 
 var Routes = rata.Routes{
 	{Name: StaticRoute, Method: "GET", Path: "/v1/static/"},
+	{Name: HealthLiveRoute, Method: "GET", Path: "/v1/health/live"},
+	{Name: HealthReadyRoute, Method: "GET", Path: "/v1/health/ready"},
 }
+
+// Route groups are the sets of routes a listener can be configured to serve.
+const (
+	StaticRouteGroup = "static"
+	HealthRouteGroup = "health"
+)
+
+var RouteGroups = map[string][]string{
+	StaticRouteGroup: {StaticRoute},
+	HealthRouteGroup: {HealthLiveRoute, HealthReadyRoute},
+}
+
+// AllRouteGroups are served by a listener that does not name any.
+var AllRouteGroups = []string{StaticRouteGroup, HealthRouteGroup}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Besides host:port, a listen address can name a Unix domain socket, as in
+// unix:///var/vcap/sys/run/file-server/file-server.sock, or a socket passed
+// by systemd socket activation, by the name given to it with
+// FileDescriptorName= or by its position among the sockets passed, as in
+// fd://file-server or fd://0.
+const (
+	UnixPrefix    = "unix://"
+	SystemdPrefix = "fd://"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// IsTCPAddress reports whether address is a host:port rather than a Unix
+// domain socket or a socket passed by systemd.
+func IsTCPAddress(address string) bool {
+	return !strings.HasPrefix(address, UnixPrefix) && !strings.HasPrefix(address, SystemdPrefix)
+}
+
+// ValidateAddress checks that address has one of the forms the server can
+// listen on, without listening on it.
+func ValidateAddress(address string) error {
+	switch {
+	case strings.HasPrefix(address, UnixPrefix):
+		if strings.TrimPrefix(address, UnixPrefix) == "" {
+			return errors.New("missing socket path")
+		}
+		return nil
+	case strings.HasPrefix(address, SystemdPrefix):
+		if strings.TrimPrefix(address, SystemdPrefix) == "" {
+			return errors.New("missing socket name")
+		}
+		return nil
+	}
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	_, err = net.LookupPort("tcp", port)
+	return err
+}
+
+func listen(address string, config ServerConfig) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(address, UnixPrefix):
+		return listenUnix(strings.TrimPrefix(address, UnixPrefix), config)
+	case strings.HasPrefix(address, SystemdPrefix):
+		return inheritedListener(strings.TrimPrefix(address, SystemdPrefix))
+	default:
+		return net.Listen("tcp", address)
+	}
+}
+
+// listenUnix listens on a Unix domain socket at path, replacing the one a
+// previous run may have left behind, and gives it the configured mode and
+// owner.
+func listenUnix(path string, config ServerConfig) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.UnixSocketMode != "" {
+		mode, err := strconv.ParseUint(config.UnixSocketMode, 8, 32)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("invalid unix socket mode %q: %s", config.UnixSocketMode, err)
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	if config.UnixSocketOwner != "" {
+		uid, gid, err := lookupOwner(config.UnixSocketOwner)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener, nil
+}
+
+// lookupOwner resolves an owner given as user or user:group. The group is
+// left unchanged when it is not given.
+func lookupOwner(owner string) (int, int, error) {
+	userName, groupName := owner, ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		userName, groupName = owner[:i], owner[i+1:]
+	}
+
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return 0, 0, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	gid := -1
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return 0, 0, err
+		}
+		gid, err = strconv.Atoi(g.Gid)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return uid, gid, nil
+}
+
+type inheritedFile struct {
+	name string
+	file *os.File
+}
+
+var (
+	inheritOnce sync.Once
+	inherited   []inheritedFile
+)
+
+// inheritedListener returns a listener on the socket passed by systemd under
+// name, or at the position name gives. The passed sockets are kept open so
+// that several listeners can be made from them.
+func inheritedListener(name string) (net.Listener, error) {
+	inheritOnce.Do(func() {
+		inherited = inheritedFiles()
+	})
+
+	for _, f := range inherited {
+		if f.name == name {
+			return net.FileListener(f.file)
+		}
+	}
+	if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(inherited) {
+		return net.FileListener(inherited[i].file)
+	}
+	return nil, fmt.Errorf("no socket named %q was passed by systemd (LISTEN_FDS=%d)", name, len(inherited))
+}
+
+// inheritedFiles returns the sockets passed by systemd, following
+// sd_listen_fds(3): none unless LISTEN_PID names this process.
+func inheritedFiles() []inheritedFile {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil
+	}
+
+	var names []string
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+
+	files := make([]inheritedFile, count)
+	for i := range files {
+		fd := listenFdsStart + i
+		files[i].name = "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) {
+			files[i].name = names[i]
+		}
+		files[i].file = os.NewFile(uintptr(fd), files[i].name)
+	}
+	return files
+}
@@ -0,0 +1,325 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/durationjson"
+	"github.com/tedsuo/ifrit"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// ServerConfig holds the limits applied to every listener. MaxDownloadDuration
+// bounds the time from the end of the request headers to the end of the
+// response, and DrainTimeout the time active requests are given to finish
+// once the server is signaled; zero leaves them unbounded. UnixSocketMode,
+// in octal, and UnixSocketOwner, as user or user:group, apply to the
+// listeners on Unix domain sockets.
+type ServerConfig struct {
+	ReadHeaderTimeout   durationjson.Duration `json:"read_header_timeout,omitempty"`
+	ReadTimeout         durationjson.Duration `json:"read_timeout,omitempty"`
+	IdleTimeout         durationjson.Duration `json:"idle_timeout,omitempty"`
+	MaxHeaderBytes      int                   `json:"max_header_bytes,omitempty"`
+	MaxDownloadDuration durationjson.Duration `json:"max_download_duration,omitempty"`
+	DrainTimeout        durationjson.Duration `json:"drain_timeout,omitempty"`
+
+	UnixSocketMode  string `json:"unix_socket_mode,omitempty"`
+	UnixSocketOwner string `json:"unix_socket_owner,omitempty"`
+
+	HTTP2 HTTP2Config `json:"http2"`
+}
+
+// HTTP2Config tunes HTTP/2, which TLS listeners offer through ALPN. H2C also
+// accepts HTTP/2 without TLS on plain listeners, from clients that know to
+// use it. The window sizes bound how much a client can upload before the
+// server has read it; zero leaves the limits of golang.org/x/net/http2.
+type HTTP2Config struct {
+	H2C                   bool   `json:"h2c,omitempty"`
+	MaxConcurrentStreams  uint32 `json:"max_concurrent_streams,omitempty"`
+	ConnectionWindowBytes int32  `json:"connection_window_bytes,omitempty"`
+	StreamWindowBytes     int32  `json:"stream_window_bytes,omitempty"`
+}
+
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		ReadHeaderTimeout: durationjson.Duration(10 * time.Second),
+		ReadTimeout:       durationjson.Duration(time.Minute),
+		IdleTimeout:       durationjson.Duration(2 * time.Minute),
+		MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+		DrainTimeout:      durationjson.Duration(30 * time.Second),
+	}
+}
+
+// ListenerConfig describes one listener of the file server. It serves TLS
+// when CertFile and KeyFile are set, and then requires clients to present a
+// certificate signed by ClientCAFile, when set. It serves the route groups
+// named in Routes, or redirects every request to the TLS listener named in
+// RedirectTo.
+type ListenerConfig struct {
+	Name         string   `json:"name"`
+	Address      string   `json:"address"`
+	CertFile     string   `json:"cert_file,omitempty"`
+	KeyFile      string   `json:"key_file,omitempty"`
+	ClientCAFile string   `json:"client_ca_file,omitempty"`
+	Routes       []string `json:"routes,omitempty"`
+	RedirectTo   string   `json:"redirect_to,omitempty"`
+}
+
+func (l ListenerConfig) TLSEnabled() bool {
+	return l.CertFile != "" || l.KeyFile != ""
+}
+
+// Serves reports whether the listener serves the given route group.
+func (l ListenerConfig) Serves(routeGroup string) bool {
+	for _, group := range l.Routes {
+		if group == routeGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// An Option adjusts a runner.
+type Option func(*httpServer)
+
+// WithConnState has the server call fn whenever a client connection changes
+// state.
+func WithConnState(fn func(net.Conn, http.ConnState)) Option {
+	return func(s *httpServer) {
+		s.connState = fn
+	}
+}
+
+// WithConnContext has the server derive the context of each client
+// connection's requests with fn.
+func WithConnContext(fn func(context.Context, net.Conn) context.Context) Option {
+	return func(s *httpServer) {
+		s.connContext = fn
+	}
+}
+
+// WithDrainTimeoutHook has the server call fn when the drain timeout is hit,
+// before it closes the connections of the requests still active.
+func WithDrainTimeoutHook(fn func()) Option {
+	return func(s *httpServer) {
+		s.onDrainTimeout = fn
+	}
+}
+
+type httpServer struct {
+	address   string
+	handler   http.Handler
+	tlsConfig *tls.Config
+	config    ServerConfig
+
+	connState      func(net.Conn, http.ConnState)
+	connContext    func(context.Context, net.Conn) context.Context
+	onDrainTimeout func()
+}
+
+func New(address string, handler http.Handler, config ServerConfig, options ...Option) ifrit.Runner {
+	return NewTLS(address, handler, nil, config, options...)
+}
+
+func NewTLS(address string, handler http.Handler, tlsConfig *tls.Config, config ServerConfig, options ...Option) ifrit.Runner {
+	s := &httpServer{
+		address:   address,
+		handler:   handler,
+		tlsConfig: tlsConfig,
+		config:    config,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+func (s *httpServer) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	server := &http.Server{
+		Handler:           s.handler,
+		TLSConfig:         s.tlsConfig,
+		ReadHeaderTimeout: time.Duration(s.config.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(s.config.ReadTimeout),
+		IdleTimeout:       time.Duration(s.config.IdleTimeout),
+		WriteTimeout:      time.Duration(s.config.MaxDownloadDuration),
+		MaxHeaderBytes:    s.config.MaxHeaderBytes,
+		ConnState:         s.connState,
+		ConnContext:       s.connContext,
+	}
+
+	h2cConns := newHijackedConns()
+	if s.tlsConfig != nil || s.config.HTTP2.H2C {
+		h2 := &http2.Server{
+			MaxConcurrentStreams:         s.config.HTTP2.MaxConcurrentStreams,
+			MaxUploadBufferPerConnection: s.config.HTTP2.ConnectionWindowBytes,
+			MaxUploadBufferPerStream:     s.config.HTTP2.StreamWindowBytes,
+		}
+		if s.tlsConfig != nil {
+			server.TLSConfig = s.tlsConfig.Clone()
+		} else {
+			server.Handler = h2cConns.wrap(h2c.NewHandler(server.Handler, h2))
+			server.ConnContext = h2cConns.connContext(server.ConnContext)
+		}
+		// Also registers the HTTP/2 connections, h2c ones included, to be
+		// sent GOAWAY on Shutdown.
+		if err := http2.ConfigureServer(server, h2); err != nil {
+			return err
+		}
+		offerProtocolsPerClient(server.TLSConfig)
+	}
+
+	listener, err := listen(s.address, s.config)
+	if err != nil {
+		return err
+	}
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, server.TLSConfig)
+	}
+
+	serverErrChan := make(chan error, 1)
+	go func() {
+		serverErrChan <- server.Serve(listener)
+	}()
+
+	close(ready)
+
+	select {
+	case err = <-serverErrChan:
+		return err
+	case <-signals:
+		// Like ifrit's http_server, stop accepting, close idle connections and
+		// wait for the active ones to finish, but only up to the drain timeout.
+		ctx := context.Background()
+		if timeout := time.Duration(s.config.DrainTimeout); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		err = server.Shutdown(ctx)
+		if err == nil {
+			err = h2cConns.wait(ctx)
+		}
+		if err == context.DeadlineExceeded {
+			if s.onDrainTimeout != nil {
+				s.onDrainTimeout()
+			}
+			h2cConns.closeAll()
+			return server.Close()
+		}
+		return err
+	}
+}
+
+// hijackedConns tracks the connections taken over by the h2c handler, which
+// http.Server.Shutdown neither waits for nor closes. The handler serves such
+// a connection until it is closed, which the HTTP/2 server does once the
+// GOAWAY sent on Shutdown has let its streams finish.
+type hijackedConns struct {
+	wg sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]int
+}
+
+type hijackedConnKey struct{}
+
+func newHijackedConns() *hijackedConns {
+	return &hijackedConns{conns: map[net.Conn]int{}}
+}
+
+// connContext records the connection in the context of its requests, after
+// calling next, if any.
+func (h *hijackedConns) connContext(next func(context.Context, net.Conn) context.Context) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		if next != nil {
+			ctx = next(ctx, c)
+		}
+		return context.WithValue(ctx, hijackedConnKey{}, c)
+	}
+}
+
+// wrap tracks the connections of the requests that switch to h2c, either
+// with the HTTP/2 preface or by asking to upgrade.
+func (h *hijackedConns) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, ok := r.Context().Value(hijackedConnKey{}).(net.Conn)
+		if !ok || r.Method != "PRI" && !strings.EqualFold(r.Header.Get("Upgrade"), "h2c") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.add(conn)
+		defer h.remove(conn)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *hijackedConns) add(conn net.Conn) {
+	h.wg.Add(1)
+	h.mu.Lock()
+	h.conns[conn]++
+	h.mu.Unlock()
+}
+
+func (h *hijackedConns) remove(conn net.Conn) {
+	h.mu.Lock()
+	h.conns[conn]--
+	if h.conns[conn] == 0 {
+		delete(h.conns, conn)
+	}
+	h.mu.Unlock()
+	h.wg.Done()
+}
+
+// wait waits for every tracked connection to be closed, or for ctx to be
+// done.
+func (h *hijackedConns) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *hijackedConns) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for conn := range h.conns {
+		conn.Close()
+	}
+}
+
+// offerProtocolsPerClient has the configurations that config chooses per
+// client, such as the current one of a tlsreload.Reloader, offer the same
+// application protocols as config itself.
+func offerProtocolsPerClient(config *tls.Config) {
+	getConfig := config.GetConfigForClient
+	if getConfig == nil {
+		return
+	}
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		clientConfig, err := getConfig(hello)
+		if err != nil || clientConfig == nil {
+			return clientConfig, err
+		}
+		clientConfig = clientConfig.Clone()
+		clientConfig.NextProtos = config.NextProtos
+		return clientConfig, nil
+	}
+}
@@ -0,0 +1,457 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/durationjson"
+	"code.cloudfoundry.org/fileserver/server"
+	"code.cloudfoundry.org/tlsconfig/certtest"
+	"github.com/tedsuo/ifrit"
+	"golang.org/x/net/http2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		address   string
+		cfg       server.ServerConfig
+		handler   http.Handler
+		tlsConfig *tls.Config
+		options   []server.Option
+		process   ifrit.Process
+	)
+
+	BeforeEach(func() {
+		address = fmt.Sprintf("127.0.0.1:%d", 8382+GinkgoParallelNode())
+		cfg = server.DefaultServerConfig()
+		tlsConfig = nil
+		options = nil
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		})
+	})
+
+	JustBeforeEach(func() {
+		process = ifrit.Invoke(server.NewTLS(address, handler, tlsConfig, cfg, options...))
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("serves requests", func() {
+		resp, err := http.Get("http://" + address)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(Equal("hello"))
+	})
+
+	Context("when a connection state hook is given", func() {
+		var states chan http.ConnState
+
+		BeforeEach(func() {
+			states = make(chan http.ConnState, 10)
+			options = []server.Option{server.WithConnState(func(_ net.Conn, state http.ConnState) {
+				states <- state
+			})}
+		})
+
+		It("calls it as connections change state", func() {
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+			resp, err := client.Get("http://" + address)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			Eventually(states).Should(Receive(Equal(http.StateNew)))
+			Eventually(states).Should(Receive(Equal(http.StateActive)))
+			Eventually(states).Should(Receive(Equal(http.StateClosed)))
+		})
+	})
+
+	Context("when a connection context hook is given", func() {
+		type connKey struct{}
+
+		BeforeEach(func() {
+			options = []server.Option{server.WithConnContext(func(ctx context.Context, c net.Conn) context.Context {
+				return context.WithValue(ctx, connKey{}, c.RemoteAddr().String())
+			})}
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.Context().Value(connKey{}).(string)))
+			})
+		})
+
+		It("derives the context of the connection's requests with it", func() {
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+			resp, err := client.Get("http://" + address)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(HavePrefix("127.0.0.1:"))
+		})
+	})
+
+	Context("when listening on a Unix domain socket", func() {
+		var socketDir, socketPath string
+
+		BeforeEach(func() {
+			var err error
+			socketDir, err = ioutil.TempDir("", "server-test")
+			Expect(err).NotTo(HaveOccurred())
+			socketPath = filepath.Join(socketDir, "server.sock")
+
+			address = server.UnixPrefix + socketPath
+			cfg.UnixSocketMode = "0600"
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(socketDir)
+		})
+
+		It("serves requests on it with the configured mode", func() {
+			client := &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+				},
+			}}
+			resp, err := client.Get("http://file-server/")
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("hello"))
+
+			info, err := os.Stat(socketPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		It("removes the socket when it stops", func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+			Expect(socketPath).NotTo(BeAnExistingFile())
+		})
+
+		Context("when a previous run left its socket behind", func() {
+			BeforeEach(func() {
+				listener, err := net.Listen("unix", socketPath)
+				Expect(err).NotTo(HaveOccurred())
+				listener.(*net.UnixListener).SetUnlinkOnClose(false)
+				listener.Close()
+			})
+
+			It("replaces it", func() {
+				conn, err := net.Dial("unix", socketPath)
+				Expect(err).NotTo(HaveOccurred())
+				conn.Close()
+			})
+		})
+	})
+
+	Context("when a socket passed by systemd is asked for but there is none", func() {
+		BeforeEach(func() {
+			address = server.SystemdPrefix + "file-server"
+		})
+
+		It("fails to start", func() {
+			Eventually(process.Wait()).Should(Receive(MatchError(ContainSubstring(`no socket named "file-server"`))))
+		})
+	})
+
+	Context("when h2c is enabled", func() {
+		BeforeEach(func() {
+			cfg.HTTP2 = server.HTTP2Config{H2C: true, MaxConcurrentStreams: 10}
+		})
+
+		It("serves HTTP/2 without TLS", func() {
+			transport := &http2.Transport{
+				AllowHTTP: true,
+				DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+					return net.Dial(network, addr)
+				},
+			}
+			// The connection would otherwise hold up the exit of the
+			// server for as long as it waits for the GOAWAY to be read.
+			defer transport.CloseIdleConnections()
+			client := &http.Client{Transport: transport}
+			resp, err := client.Get("http://" + address)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.ProtoMajor).To(Equal(2))
+
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("hello"))
+		})
+
+		It("still serves HTTP/1.1", func() {
+			resp, err := http.Get("http://" + address)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.ProtoMajor).To(Equal(1))
+		})
+	})
+
+	Context("when serving TLS", func() {
+		var client *http.Client
+
+		BeforeEach(func() {
+			ca, err := certtest.BuildCA("test-ca")
+			Expect(err).NotTo(HaveOccurred())
+			cert, err := ca.BuildSignedCertificate("server", certtest.WithIPs(net.ParseIP("127.0.0.1")))
+			Expect(err).NotTo(HaveOccurred())
+			tlsCert, err := cert.TLSCertificate()
+			Expect(err).NotTo(HaveOccurred())
+			pool, err := ca.CertPool()
+			Expect(err).NotTo(HaveOccurred())
+
+			// Like a tlsreload.Reloader, choose the configuration per client.
+			clientConfig := &tls.Config{Certificates: []tls.Certificate{tlsCert}}
+			tlsConfig = &tls.Config{GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return clientConfig, nil
+			}}
+			client = &http.Client{Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{RootCAs: pool},
+				ForceAttemptHTTP2: true,
+			}}
+		})
+
+		It("negotiates HTTP/2 through ALPN", func() {
+			resp, err := client.Get("https://" + address)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.ProtoMajor).To(Equal(2))
+			Expect(resp.TLS.NegotiatedProtocol).To(Equal("h2"))
+		})
+
+		It("serves HTTP/1.1 to clients that do not offer HTTP/2", func() {
+			client.Transport.(*http.Transport).ForceAttemptHTTP2 = false
+			client.Transport.(*http.Transport).TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+
+			resp, err := client.Get("https://" + address)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.ProtoMajor).To(Equal(1))
+		})
+	})
+
+	Context("when a client is slow to send its headers", func() {
+		BeforeEach(func() {
+			cfg.ReadHeaderTimeout = durationjson.Duration(100 * time.Millisecond)
+		})
+
+		It("closes the connection", func() {
+			conn, err := net.Dial("tcp", address)
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n"))
+			Expect(err).NotTo(HaveOccurred())
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, err = bufio.NewReader(conn).ReadString('\n')
+			Expect(err).To(Equal(io.EOF))
+		})
+	})
+
+	Context("when the request headers are too large", func() {
+		BeforeEach(func() {
+			cfg.MaxHeaderBytes = 1
+		})
+
+		It("responds with 431", func() {
+			req, err := http.NewRequest("GET", "http://"+address, nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("X-Padding", strings.Repeat("a", 8192))
+
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusRequestHeaderFieldsTooLarge))
+		})
+	})
+
+	Context("when a download takes longer than the maximum duration", func() {
+		BeforeEach(func() {
+			cfg.MaxDownloadDuration = durationjson.Duration(100 * time.Millisecond)
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for i := 0; i < 50; i++ {
+					w.Write([]byte(strings.Repeat("a", 1024)))
+					w.(http.Flusher).Flush()
+					time.Sleep(10 * time.Millisecond)
+				}
+			})
+		})
+
+		It("cuts the response off", func() {
+			resp, err := http.Get("http://" + address)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			_, err = ioutil.ReadAll(resp.Body)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when signalled", func() {
+		var started, release chan struct{}
+
+		BeforeEach(func() {
+			started = make(chan struct{})
+			release = make(chan struct{})
+			started, release := started, release
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				<-release
+				w.Write([]byte("done"))
+			})
+		})
+
+		It("waits for active requests to finish before exiting", func() {
+			bodyChan := make(chan string, 1)
+			go func() {
+				defer GinkgoRecover()
+				resp, err := http.Get("http://" + address)
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+				body, _ := ioutil.ReadAll(resp.Body)
+				bodyChan <- string(body)
+			}()
+
+			Eventually(started).Should(BeClosed())
+
+			process.Signal(os.Interrupt)
+			Consistently(process.Wait()).ShouldNot(Receive())
+
+			close(release)
+			Eventually(bodyChan).Should(Receive(Equal("done")))
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+		})
+
+		Context("when requests are made over h2c", func() {
+			var client *http.Client
+
+			BeforeEach(func() {
+				cfg.HTTP2 = server.HTTP2Config{H2C: true}
+				client = &http.Client{Transport: &http2.Transport{
+					AllowHTTP: true,
+					DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+						return net.Dial(network, addr)
+					},
+				}}
+			})
+
+			It("waits for them to finish before exiting", func() {
+				bodyChan := make(chan string, 1)
+				go func() {
+					defer GinkgoRecover()
+					resp, err := client.Get("http://" + address)
+					Expect(err).NotTo(HaveOccurred())
+					defer resp.Body.Close()
+					Expect(resp.ProtoMajor).To(Equal(2))
+					body, _ := ioutil.ReadAll(resp.Body)
+					bodyChan <- string(body)
+				}()
+
+				Eventually(started).Should(BeClosed())
+
+				process.Signal(os.Interrupt)
+				Consistently(process.Wait()).ShouldNot(Receive())
+
+				close(release)
+				Eventually(bodyChan).Should(Receive(Equal("done")))
+				client.Transport.(*http2.Transport).CloseIdleConnections()
+				Eventually(process.Wait()).Should(Receive(BeNil()))
+			})
+
+			Context("when they outlast the drain timeout", func() {
+				var timedOut chan struct{}
+
+				BeforeEach(func() {
+					cfg.DrainTimeout = durationjson.Duration(100 * time.Millisecond)
+					timedOut = make(chan struct{})
+					options = []server.Option{server.WithDrainTimeoutHook(func() {
+						close(timedOut)
+					})}
+				})
+
+				AfterEach(func() {
+					close(release)
+				})
+
+				It("calls the hook and cuts them off", func() {
+					errChan := make(chan error, 1)
+					go func() {
+						resp, err := client.Get("http://" + address)
+						if err == nil {
+							_, err = ioutil.ReadAll(resp.Body)
+							resp.Body.Close()
+						}
+						errChan <- err
+					}()
+
+					Eventually(started).Should(BeClosed())
+
+					process.Signal(os.Interrupt)
+					Eventually(timedOut).Should(BeClosed())
+					Eventually(process.Wait()).Should(Receive(BeNil()))
+					Eventually(errChan).Should(Receive(HaveOccurred()))
+				})
+			})
+		})
+
+		Context("when active requests outlast the drain timeout", func() {
+			var timedOut chan struct{}
+
+			BeforeEach(func() {
+				cfg.DrainTimeout = durationjson.Duration(100 * time.Millisecond)
+				timedOut = make(chan struct{})
+				options = []server.Option{server.WithDrainTimeoutHook(func() {
+					close(timedOut)
+				})}
+			})
+
+			AfterEach(func() {
+				close(release)
+			})
+
+			It("calls the hook and cuts them off", func() {
+				errChan := make(chan error, 1)
+				go func() {
+					resp, err := http.Get("http://" + address)
+					if err == nil {
+						_, err = ioutil.ReadAll(resp.Body)
+						resp.Body.Close()
+					}
+					errChan <- err
+				}()
+
+				Eventually(started).Should(BeClosed())
+
+				process.Signal(os.Interrupt)
+				Eventually(timedOut).Should(BeClosed())
+				Eventually(process.Wait()).Should(Receive(BeNil()))
+				Eventually(errChan).Should(Receive(HaveOccurred()))
+			})
+		})
+	})
+})
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Local stores files in a directory of the local file system. The files it
+// opens are *os.File, so that the HTTP server can send them with sendfile.
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+func (l *Local) Open(name string) (File, error) {
+	p, err := l.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (l *Local) Stat(name string) (os.FileInfo, error) {
+	p, err := l.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (l *Local) List(dir string) ([]os.FileInfo, error) {
+	p, err := l.path("list", dir)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadDir(p)
+}
+
+// Write writes to a temporary file next to name and renames it into place.
+func (l *Local) Write(name string, r io.Reader) error {
+	p, err := l.path("write", name)
+	if err != nil {
+		return err
+	}
+	if p == filepath.Clean(l.dir) {
+		return &os.PathError{Op: "write", Path: name, Err: ErrInvalidName}
+	}
+
+	parent := filepath.Dir(p)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(parent, "."+filepath.Base(p)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (l *Local) Delete(name string) error {
+	p, err := l.path("delete", name)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return &os.PathError{Op: "delete", Path: name, Err: errIsDir}
+	}
+	return os.Remove(p)
+}
+
+func (l *Local) path(op, name string) (string, error) {
+	clean, err := Clean(name)
+	if err != nil {
+		return "", &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,53 @@
+package storage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/fileserver/storage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Local", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "storage-local")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	itStoresFiles(func() storage.Storage {
+		return storage.NewLocal(dir)
+	})
+
+	It("keeps its files in the directory", func() {
+		store := storage.NewLocal(dir)
+		Expect(store.Write("/buildpacks/go.zip", strings.NewReader("world"))).To(Succeed())
+
+		contents, err := ioutil.ReadFile(filepath.Join(dir, "buildpacks", "go.zip"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(Equal("world"))
+
+		entries, err := ioutil.ReadDir(filepath.Join(dir, "buildpacks"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
+	It("opens files as *os.File so that they can be sent with sendfile", func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "test"), []byte("hello"), os.ModePerm)).To(Succeed())
+
+		file, err := storage.NewLocal(dir).Open("/test")
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+		Expect(file).To(BeAssignableToTypeOf(&os.File{}))
+	})
+})
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+// Memory stores files in memory, for tests. Directories are not stored: a
+// directory exists as long as there are files below it.
+type Memory struct {
+	clock clock.Clock
+
+	mu    sync.RWMutex
+	files map[string]memoryFile
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemory(clock clock.Clock) *Memory {
+	return &Memory{
+		clock: clock,
+		files: map[string]memoryFile{},
+	}
+}
+
+func (m *Memory) Open(name string) (File, error) {
+	info, data, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryReader{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+func (m *Memory) Stat(name string) (os.FileInfo, error) {
+	info, _, err := m.lookup("stat", name)
+	return info, err
+}
+
+func (m *Memory) List(dir string) ([]os.FileInfo, error) {
+	info, _, err := m.lookup("list", dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &os.PathError{Op: "list", Path: dir, Err: errNotDir}
+	}
+
+	clean, _ := Clean(dir)
+	prefix := strings.TrimSuffix(clean, "/") + "/"
+	entries := map[string]os.FileInfo{}
+
+	m.mu.RLock()
+	for name, file := range m.files {
+		rest := strings.TrimPrefix(name, prefix)
+		if rest == name {
+			continue
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			entries[rest[:i]] = dirInfo(rest[:i])
+		} else {
+			entries[rest] = newFileInfo(rest, int64(len(file.data)), file.modTime)
+		}
+	}
+	m.mu.RUnlock()
+
+	list := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
+
+func (m *Memory) Write(name string, r io.Reader) error {
+	clean, err := Clean(name)
+	if err != nil {
+		return &os.PathError{Op: "write", Path: name, Err: err}
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.isDir(clean) {
+		return &os.PathError{Op: "write", Path: name, Err: errIsDir}
+	}
+	for dir := path.Dir(clean); dir != "/"; dir = path.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return &os.PathError{Op: "write", Path: name, Err: errNotDir}
+		}
+	}
+
+	m.files[clean] = memoryFile{data: data, modTime: m.clock.Now()}
+	return nil
+}
+
+func (m *Memory) Delete(name string) error {
+	clean, err := Clean(name)
+	if err != nil {
+		return &os.PathError{Op: "delete", Path: name, Err: err}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[clean]; ok {
+		delete(m.files, clean)
+		return nil
+	}
+	if m.isDir(clean) {
+		return &os.PathError{Op: "delete", Path: name, Err: errIsDir}
+	}
+	return &os.PathError{Op: "delete", Path: name, Err: os.ErrNotExist}
+}
+
+// lookup returns the information on name and, for a file, its contents.
+func (m *Memory) lookup(op, name string) (os.FileInfo, []byte, error) {
+	clean, err := Clean(name)
+	if err != nil {
+		return nil, nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if file, ok := m.files[clean]; ok {
+		return newFileInfo(path.Base(clean), int64(len(file.data)), file.modTime), file.data, nil
+	}
+	if m.isDir(clean) {
+		return dirInfo(path.Base(clean)), nil, nil
+	}
+	return nil, nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// isDir reports whether there are files below the cleaned name. The caller
+// must hold the lock.
+func (m *Memory) isDir(clean string) bool {
+	if clean == "/" {
+		return true
+	}
+	prefix := clean + "/"
+	for name := range m.files {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type memoryReader struct {
+	*bytes.Reader
+	info os.FileInfo
+}
+
+func (r *memoryReader) Stat() (os.FileInfo, error) { return r.info, nil }
+func (r *memoryReader) Close() error               { return nil }
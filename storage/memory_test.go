@@ -0,0 +1,46 @@
+package storage_test
+
+import (
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/fileserver/storage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory", func() {
+	var fakeClock *fakeclock.FakeClock
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+	})
+
+	itStoresFiles(func() storage.Storage {
+		return storage.NewMemory(fakeClock)
+	})
+
+	It("stamps files with the time they were written", func() {
+		store := storage.NewMemory(fakeClock)
+		Expect(store.Write("/test", strings.NewReader("hello"))).To(Succeed())
+
+		fakeClock.Increment(time.Hour)
+		Expect(store.Write("/test2", strings.NewReader("world"))).To(Succeed())
+
+		info, err := store.Stat("/test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.ModTime()).To(Equal(fakeClock.Now().Add(-time.Hour)))
+	})
+
+	It("forgets a directory once its last file is deleted", func() {
+		store := storage.NewMemory(fakeClock)
+		Expect(store.Write("/buildpacks/go.zip", strings.NewReader("world"))).To(Succeed())
+		Expect(store.Delete("/buildpacks/go.zip")).To(Succeed())
+
+		entries, err := store.List("/")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+})
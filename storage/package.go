@@ -0,0 +1 @@
+package storage // import "code.cloudfoundry.org/fileserver/storage"
@@ -0,0 +1,725 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+const (
+	DefaultS3Region = "us-east-1"
+
+	// sha256MetadataHeader carries the digest stored with the objects
+	// written by Write.
+	sha256MetadataHeader = "X-Amz-Meta-Sha256"
+
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// replayBytes is how much of the start of an object a file keeps, as
+	// much as http.ServeContent sniffs before seeking back to the start.
+	replayBytes = 512
+
+	// skipBytes is how far ahead of a response a file may be seeked and
+	// still read on from it, discarding what lies in between, rather than
+	// starting another request.
+	skipBytes = 64 * 1024
+
+	// The time allowed to connect to the endpoint, for it to answer a
+	// request, and for which an unused connection to it is kept open.
+	s3DialTimeout           = 10 * time.Second
+	s3ResponseHeaderTimeout = 30 * time.Second
+	s3IdleConnTimeout       = 90 * time.Second
+)
+
+// S3Config locates the served files in an S3-compatible object store: the
+// objects of Bucket whose keys start with Prefix, addressed by path below
+// Endpoint rather than by a bucket subdomain. Requests are not signed when
+// no credentials are given.
+type S3Config struct {
+	Endpoint        string `json:"endpoint,omitempty"`
+	Region          string `json:"region,omitempty"`
+	Bucket          string `json:"bucket,omitempty"`
+	Prefix          string `json:"prefix,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+}
+
+// S3 stores files as the objects of an S3-compatible bucket. Object stores
+// have no directories: a directory exists as long as there are objects below
+// it. The files it opens know their digest, from the sha256 metadata Write
+// stores with the object or else from the object's ETag, and read from the
+// object with ranged requests that start at the offset they were seeked to.
+type S3 struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	clock    clock.Clock
+}
+
+func NewS3(config S3Config, clock clock.Clock) (*S3, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, errors.New("missing s3 bucket")
+	}
+	if (config.AccessKeyID == "") != (config.SecretAccessKey == "") {
+		return nil, errors.New("s3 access key id and secret access key must both be set")
+	}
+	if config.Region == "" {
+		config.Region = DefaultS3Region
+	}
+
+	// Objects are served as they are stored, even when they were uploaded
+	// compressed.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableCompression = true
+	transport.DialContext = (&net.Dialer{Timeout: s3DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.ResponseHeaderTimeout = s3ResponseHeaderTimeout
+	transport.IdleConnTimeout = s3IdleConnTimeout
+
+	return &S3{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Transport: transport},
+		clock:    clock,
+	}, nil
+}
+
+func (s *S3) Open(name string) (File, error) {
+	return s.OpenContext(context.Background(), name)
+}
+
+// OpenContext opens name like Open, with the requests made for the file
+// cancelled once ctx is done.
+func (s *S3) OpenContext(ctx context.Context, name string) (File, error) {
+	clean, err := Clean(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if clean == "/" {
+		if _, err := s.listObjects(ctx, s.dirPrefix(clean), "", 1); err != nil {
+			return nil, err
+		}
+		return &s3File{info: dirInfo(clean)}, nil
+	}
+
+	// The contents are only requested once they are read, from the offset
+	// the file was seeked to, so that neither conditional requests nor
+	// ranges cost a transfer of the whole object.
+	resp, err := s.do(ctx, "HEAD", s.key(clean), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		info, err := s.statDir(ctx, "open", name, clean)
+		if err != nil {
+			return nil, err
+		}
+		return &s3File{info: info}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("open", name, resp)
+	}
+
+	info, err := objectInfo(path.Base(clean), resp)
+	if err != nil {
+		return nil, err
+	}
+	return &s3File{
+		s3:     s,
+		ctx:    ctx,
+		name:   clean,
+		info:   info,
+		etag:   resp.Header.Get("ETag"),
+		digest: objectDigest(resp.Header),
+	}, nil
+}
+
+func (s *S3) Stat(name string) (os.FileInfo, error) {
+	clean, err := Clean(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if clean == "/" {
+		// The bucket is listed, so that the root is only reported once
+		// the bucket can be read.
+		if _, err := s.listObjects(context.Background(), s.dirPrefix(clean), "", 1); err != nil {
+			return nil, err
+		}
+		return dirInfo(clean), nil
+	}
+
+	info, err := s.head(context.Background(), "stat", name, clean)
+	if os.IsNotExist(err) {
+		return s.statDir(context.Background(), "stat", name, clean)
+	}
+	return info, err
+}
+
+func (s *S3) List(dir string) ([]os.FileInfo, error) {
+	clean, err := Clean(dir)
+	if err != nil {
+		return nil, &os.PathError{Op: "list", Path: dir, Err: err}
+	}
+
+	prefix := s.dirPrefix(clean)
+	result, err := s.listObjects(context.Background(), prefix, "/", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []os.FileInfo
+	for _, object := range result.Contents {
+		if name := strings.TrimPrefix(object.Key, prefix); name != "" {
+			entries = append(entries, newFileInfo(name, object.Size, object.LastModified))
+		}
+	}
+	for _, common := range result.CommonPrefixes {
+		if name := strings.TrimSuffix(strings.TrimPrefix(common.Prefix, prefix), "/"); name != "" {
+			entries = append(entries, dirInfo(name))
+		}
+	}
+
+	if len(entries) == 0 && clean != "/" {
+		if _, err := s.head(context.Background(), "list", dir, clean); err != nil {
+			return nil, err
+		}
+		return nil, &os.PathError{Op: "list", Path: dir, Err: errNotDir}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// Write spools r to a temporary file to learn its digest, which both signs
+// the upload and is stored as the sha256 metadata of the object.
+func (s *S3) Write(name string, r io.Reader) error {
+	clean, err := Clean(name)
+	if err != nil {
+		return &os.PathError{Op: "write", Path: name, Err: err}
+	}
+	if clean == "/" {
+		return &os.PathError{Op: "write", Path: name, Err: ErrInvalidName}
+	}
+
+	if isDir, err := s.isDir(context.Background(), clean); err != nil {
+		return err
+	} else if isDir {
+		return &os.PathError{Op: "write", Path: name, Err: errIsDir}
+	}
+	for dir := path.Dir(clean); dir != "/"; dir = path.Dir(dir) {
+		_, err := s.head(context.Background(), "write", name, dir)
+		if err == nil {
+			return &os.PathError{Op: "write", Path: name, Err: errNotDir}
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	tmp, err := ioutil.TempFile("", "s3-upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	resp, err := s.do(context.Background(), "PUT", s.key(clean), nil, http.Header{sha256MetadataHeader: {digest}}, &payload{
+		body: ioutil.NopCloser(tmp),
+		size: size,
+		hash: digest,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError("write", name, resp)
+	}
+	return nil
+}
+
+func (s *S3) Delete(name string) error {
+	clean, err := Clean(name)
+	if err != nil {
+		return &os.PathError{Op: "delete", Path: name, Err: err}
+	}
+	if clean == "/" {
+		return &os.PathError{Op: "delete", Path: name, Err: errIsDir}
+	}
+
+	// Deleting a missing object succeeds, so it is looked for first.
+	if _, err := s.head(context.Background(), "delete", name, clean); os.IsNotExist(err) {
+		if isDir, dirErr := s.isDir(context.Background(), clean); dirErr != nil {
+			return dirErr
+		} else if isDir {
+			return &os.PathError{Op: "delete", Path: name, Err: errIsDir}
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	resp, err := s.do(context.Background(), "DELETE", s.key(clean), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return responseError("delete", name, resp)
+	}
+	return nil
+}
+
+func (s *S3) head(ctx context.Context, op, name, clean string) (os.FileInfo, error) {
+	resp, err := s.do(ctx, "HEAD", s.key(clean), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(op, name, resp)
+	}
+	return objectInfo(path.Base(clean), resp)
+}
+
+// statDir returns the information on the directory clean when there are
+// objects below it.
+func (s *S3) statDir(ctx context.Context, op, name, clean string) (os.FileInfo, error) {
+	isDir, err := s.isDir(ctx, clean)
+	if err != nil {
+		return nil, err
+	}
+	if !isDir {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return dirInfo(path.Base(clean)), nil
+}
+
+func (s *S3) isDir(ctx context.Context, clean string) (bool, error) {
+	result, err := s.listObjects(ctx, s.dirPrefix(clean), "", 1)
+	if err != nil {
+		return false, err
+	}
+	return len(result.Contents) > 0, nil
+}
+
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+// listObjects lists the objects whose keys start with prefix, following the
+// continuation tokens unless maxKeys limits the listing to its first page.
+func (s *S3) listObjects(ctx context.Context, prefix, delimiter string, maxKeys int) (listBucketResult, error) {
+	var all listBucketResult
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if maxKeys > 0 {
+			query.Set("max-keys", strconv.Itoa(maxKeys))
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, "GET", "", query, nil, nil)
+		if err != nil {
+			return all, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return all, responseError("list", "/"+prefix, resp)
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return all, err
+		}
+
+		all.Contents = append(all.Contents, result.Contents...)
+		all.CommonPrefixes = append(all.CommonPrefixes, result.CommonPrefixes...)
+		if !result.IsTruncated || maxKeys > 0 || result.NextContinuationToken == "" {
+			return all, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// key returns the key of the object holding the cleaned name.
+func (s *S3) key(clean string) string {
+	return strings.TrimPrefix(path.Join("/", s.config.Prefix, clean), "/")
+}
+
+// dirPrefix returns the prefix of the keys of the objects below the cleaned
+// directory name.
+func (s *S3) dirPrefix(clean string) string {
+	if key := s.key(clean); key != "" {
+		return key + "/"
+	}
+	return ""
+}
+
+type payload struct {
+	body io.ReadCloser
+	size int64
+	hash string
+}
+
+// do sends a request for the object key, or for the bucket when key is
+// empty, and gives up on it once ctx is done.
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, header http.Header, body *payload) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.config.Bucket + "/" + key
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		req.Body = body.body
+		req.ContentLength = body.size
+		if body.size == 0 {
+			req.Body = http.NoBody
+		}
+		payloadHash = body.hash
+	}
+	if s.config.AccessKeyID != "" {
+		s.sign(req, payloadHash)
+	}
+
+	return s.client.Do(req)
+}
+
+// sign signs req with AWS Signature Version 4, covering the host and every
+// header set so far.
+func (s *S3) sign(req *http.Request, payloadHash string) {
+	now := s.clock.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.config.Region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.config.SecretAccessKey)
+	for _, part := range []string{now.Format("20060102"), s.config.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query the way Signature Version 4 expects, sorted by
+// name and with every reserved character escaped.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var params []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// uriEncode escapes every byte but the unreserved characters of RFC 3986,
+// and slashes unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// objectInfo describes the object from the headers of resp, a response to a
+// HEAD request.
+func objectInfo(name string, resp *http.Response) (os.FileInfo, error) {
+	if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("missing Content-Length header of %s", name)
+	}
+	modTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Last-Modified header of %s: %s", name, err)
+	}
+	return newFileInfo(name, resp.ContentLength, modTime), nil
+}
+
+func objectDigest(header http.Header) string {
+	if digest := header.Get(sha256MetadataHeader); digest != "" {
+		return digest
+	}
+	return strings.Trim(header.Get("ETag"), `"`)
+}
+
+// responseError describes a failed request from the status and the S3 error
+// document of resp, which it closes.
+func responseError(op, name string, resp *http.Response) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+
+	var s3Error struct {
+		Code    string
+		Message string
+	}
+	if xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&s3Error) == nil && s3Error.Code != "" {
+		return &os.PathError{Op: op, Path: name, Err: fmt.Errorf("s3: %s: %s", s3Error.Code, s3Error.Message)}
+	}
+	return &os.PathError{Op: op, Path: name, Err: fmt.Errorf("s3: unexpected status %s", resp.Status)}
+}
+
+// s3File reads an object through the body of a ranged GET request, started
+// by the first read at the offset the file was seeked to, and starts a new
+// one when it is seeked elsewhere. The ranged requests only succeed while
+// the object is unchanged since it was opened. The start of the object is
+// kept in replay, so that reading it again does not need one, and a seek
+// shortly ahead reads on from the current request. Once the file is told the
+// range about to be read, its requests stop at the end of that range.
+type s3File struct {
+	s3     *S3
+	ctx    context.Context
+	name   string
+	info   os.FileInfo
+	etag   string
+	digest string
+
+	// rangeStart and rangeEnd bound the part of the object expected to be
+	// read. rangeEnd is zero until ExpectRange is called.
+	rangeStart int64
+	rangeEnd   int64
+
+	body       io.ReadCloser
+	bodyOffset int64
+	bodyEnd    int64
+	offset     int64
+	replay     []byte
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.info.Name(), Err: errIsDir}
+	}
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+
+	if f.body != nil && f.offset < f.bodyOffset && f.bodyOffset == int64(len(f.replay)) {
+		n := copy(p, f.replay[f.offset:])
+		f.offset += int64(n)
+		return n, nil
+	}
+
+	if f.body != nil && f.offset > f.bodyOffset && f.offset-f.bodyOffset <= skipBytes && f.offset < f.bodyEnd {
+		skipped, _ := io.CopyN(ioutil.Discard, f.body, f.offset-f.bodyOffset)
+		f.bodyOffset += skipped
+	}
+	if f.body != nil && (f.bodyOffset != f.offset || f.bodyOffset >= f.bodyEnd) {
+		f.body.Close()
+		f.body = nil
+	}
+	if f.body == nil {
+		if err := f.openRange(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.body.Read(p)
+	if f.bodyOffset == int64(len(f.replay)) && len(f.replay) < replayBytes {
+		keep := n
+		if keep > replayBytes-len(f.replay) {
+			keep = replayBytes - len(f.replay)
+		}
+		f.replay = append(f.replay, p[:keep]...)
+	}
+	f.offset += int64(n)
+	f.bodyOffset += int64(n)
+
+	// A request that stops at the end of the expected range is not the end
+	// of the object: the next read starts another.
+	if err == io.EOF && f.bodyOffset == f.bodyEnd && f.offset < f.info.Size() {
+		err = nil
+	}
+	return n, err
+}
+
+// openRange requests the object from the current offset, up to the end of
+// the expected range when the offset is within it. The first read of a file
+// expected to be read further on is the sniff of http.ServeContent: when the
+// range starts close enough, the same request goes on to serve it, and
+// otherwise it only asks for the bytes that are sniffed.
+func (f *s3File) openRange() error {
+	end := f.info.Size()
+	if f.rangeEnd > 0 {
+		switch {
+		case f.offset >= f.rangeStart && f.offset < f.rangeEnd:
+			end = f.rangeEnd
+		case f.offset == 0 && f.bodyEnd == 0:
+			end = f.rangeEnd
+			if end < replayBytes || f.rangeStart > replayBytes+skipBytes {
+				end = replayBytes
+			}
+		}
+	}
+	if end > f.info.Size() {
+		end = f.info.Size()
+	}
+
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", f.offset)}}
+	if end < f.info.Size() {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", f.offset, end-1))
+	}
+	if f.etag != "" {
+		header.Set("If-Match", f.etag)
+	}
+
+	resp, err := f.s3.do(f.ctx, "GET", f.s3.key(f.name), nil, header, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return responseError("read", f.name, resp)
+	}
+
+	f.body = resp.Body
+	f.bodyOffset = f.offset
+	f.bodyEnd = end
+	return nil
+}
+
+// ExpectRange bounds the requests made for the reads that follow to the
+// range of the object about to be served.
+func (f *s3File) ExpectRange(offset, length int64) {
+	f.rangeStart = offset
+	f.rangeEnd = offset + length
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.info.Name(), Err: errors.New("negative position")}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+func (f *s3File) Stat() (os.FileInfo, error) { return f.info, nil }
+func (f *s3File) Digest() string             { return f.digest }
@@ -0,0 +1,607 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/fileserver/storage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	standInBucket    = "blobs"
+	standInKeyID     = "AKIDEXAMPLE"
+	standInSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+var _ = Describe("S3", func() {
+	var (
+		standIn *s3StandIn
+		cfg     storage.S3Config
+	)
+
+	newS3 := func() *storage.S3 {
+		store, err := storage.NewS3(cfg, clock.NewClock())
+		Expect(err).NotTo(HaveOccurred())
+		return store
+	}
+
+	BeforeEach(func() {
+		standIn = newS3StandIn()
+		cfg = storage.S3Config{
+			Endpoint:        standIn.server.URL,
+			Bucket:          standInBucket,
+			Prefix:          "file-server",
+			AccessKeyID:     standInKeyID,
+			SecretAccessKey: standInSecretKey,
+		}
+	})
+
+	AfterEach(func() {
+		standIn.server.Close()
+	})
+
+	itStoresFiles(func() storage.Storage {
+		return newS3()
+	})
+
+	It("keeps its files below the prefix", func() {
+		Expect(newS3().Write("/buildpacks/go.zip", strings.NewReader("world"))).To(Succeed())
+
+		info, err := standIn.objects.Stat("/file-server/buildpacks/go.zip")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Size()).To(BeEquivalentTo(5))
+	})
+
+	It("escapes the names of the objects", func() {
+		store := newS3()
+		Expect(store.Write("/build packs/go+1.zip", strings.NewReader("world"))).To(Succeed())
+
+		_, err := standIn.objects.Stat("/file-server/build packs/go+1.zip")
+		Expect(err).NotTo(HaveOccurred())
+		entries, err := store.List("/build packs")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Name()).To(Equal("go+1.zip"))
+	})
+
+	It("follows the continuation tokens of long listings", func() {
+		store := newS3()
+		for i := 0; i < 5; i++ {
+			Expect(store.Write(fmt.Sprintf("/stacks/%d.tgz", i), strings.NewReader("world"))).To(Succeed())
+		}
+
+		entries, err := store.List("/stacks")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(5))
+		Expect(entries[4].Name()).To(Equal("4.tgz"))
+		Expect(standIn.continuations()).To(Equal(2))
+	})
+
+	Describe("the files it opens", func() {
+		var store *storage.S3
+
+		BeforeEach(func() {
+			store = newS3()
+			Expect(store.Write("/test", strings.NewReader("hello world"))).To(Succeed())
+		})
+
+		It("know the sha256 digest stored with the object", func() {
+			file, err := store.Open("/test")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			sum := sha256.Sum256([]byte("hello world"))
+			Expect(file.(storage.Digester).Digest()).To(Equal(hex.EncodeToString(sum[:])))
+		})
+
+		It("know the ETag of objects stored without a digest", func() {
+			Expect(standIn.objects.Write("/file-server/uploaded", strings.NewReader("hello"))).To(Succeed())
+
+			file, err := store.Open("/uploaded")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			sum := md5.Sum([]byte("hello"))
+			Expect(file.(storage.Digester).Digest()).To(Equal(hex.EncodeToString(sum[:])))
+		})
+
+		It("do not request the contents of the object until they are read", func() {
+			file, err := store.Open("/test")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			info, err := file.Stat()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Size()).To(BeEquivalentTo(11))
+			Expect(file.(storage.Digester).Digest()).NotTo(BeEmpty())
+			Expect(standIn.objectRequests("GET")).To(BeEmpty())
+		})
+
+		It("read from the offset they are seeked to with a ranged request", func() {
+			file, err := store.Open("/test")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			size, err := file.Seek(0, io.SeekEnd)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(BeEquivalentTo(11))
+
+			_, err = file.Seek(6, io.SeekStart)
+			Expect(err).NotTo(HaveOccurred())
+			contents, err := ioutil.ReadAll(file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal("world"))
+
+			gets := standIn.objectRequests("GET")
+			Expect(gets).To(HaveLen(1))
+			Expect(gets[0].Header.Get("Range")).To(Equal("bytes=6-"))
+			sum := md5.Sum([]byte("hello world"))
+			Expect(gets[0].Header.Get("If-Match")).To(Equal(fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:]))))
+		})
+
+		It("read the start of the object again without another request", func() {
+			file, err := store.Open("/test")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(file, buf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(buf)).To(Equal("hello"))
+
+			_, err = file.Seek(0, io.SeekStart)
+			Expect(err).NotTo(HaveOccurred())
+			contents, err := ioutil.ReadAll(file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal("hello world"))
+
+			gets := standIn.objectRequests("GET")
+			Expect(gets).To(HaveLen(1))
+			Expect(gets[0].Header.Get("Range")).To(Equal("bytes=0-"))
+		})
+
+		It("stop their requests at the end of the range they are told to expect", func() {
+			file, err := store.Open("/test")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			file.(storage.Ranger).ExpectRange(6, 3)
+			_, err = file.Seek(6, io.SeekStart)
+			Expect(err).NotTo(HaveOccurred())
+			contents, err := ioutil.ReadAll(file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal("world"))
+
+			gets := standIn.objectRequests("GET")
+			Expect(gets).To(HaveLen(2))
+			Expect(gets[0].Header.Get("Range")).To(Equal("bytes=6-8"))
+			Expect(gets[1].Header.Get("Range")).To(Equal("bytes=9-"))
+		})
+
+		It("serve a range close to the start with the request made to sniff it", func() {
+			contents := bytes.Repeat([]byte("0123456789"), 10*1024)
+			Expect(store.Write("/large", bytes.NewReader(contents))).To(Succeed())
+			file, err := store.Open("/large")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			file.(storage.Ranger).ExpectRange(1000, 10)
+			req := httptest.NewRequest("GET", "/large", nil)
+			req.Header.Set("Range", "bytes=1000-1009")
+			rec := httptest.NewRecorder()
+			http.ServeContent(rec, req, "", time.Time{}, file)
+			Expect(rec.Code).To(Equal(http.StatusPartialContent))
+			Expect(rec.Body.String()).To(Equal("0123456789"))
+
+			gets := standIn.objectRequests("GET")
+			Expect(gets).To(HaveLen(1))
+			Expect(gets[0].Header.Get("Range")).To(Equal("bytes=0-1009"))
+		})
+
+		It("only request the sniffed bytes of a range far into the file", func() {
+			contents := bytes.Repeat([]byte("0123456789"), 10*1024)
+			Expect(store.Write("/large", bytes.NewReader(contents))).To(Succeed())
+			file, err := store.Open("/large")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			file.(storage.Ranger).ExpectRange(90000, 10)
+			req := httptest.NewRequest("GET", "/large", nil)
+			req.Header.Set("Range", "bytes=90000-90009")
+			rec := httptest.NewRecorder()
+			http.ServeContent(rec, req, "", time.Time{}, file)
+			Expect(rec.Code).To(Equal(http.StatusPartialContent))
+			Expect(rec.Body.String()).To(Equal("0123456789"))
+
+			gets := standIn.objectRequests("GET")
+			Expect(gets).To(HaveLen(2))
+			Expect(gets[0].Header.Get("Range")).To(Equal("bytes=0-511"))
+			Expect(gets[1].Header.Get("Range")).To(Equal("bytes=90000-90009"))
+		})
+
+		It("stop reading once the context they were opened with is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			file, err := store.OpenContext(ctx, "/test")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			cancel()
+			_, err = ioutil.ReadAll(file)
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+			Expect(standIn.objectRequests("GET")).To(BeEmpty())
+		})
+
+		It("fail to read once the object has changed", func() {
+			file, err := store.Open("/test")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			Expect(store.Write("/test", strings.NewReader("goodbye world"))).To(Succeed())
+
+			_, err = file.Seek(6, io.SeekStart)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = ioutil.ReadAll(file)
+			Expect(err).To(MatchError(ContainSubstring("PreconditionFailed")))
+		})
+
+		It("cannot be opened when the size of the object is not known", func() {
+			standIn.mu.Lock()
+			standIn.omitLength = true
+			standIn.mu.Unlock()
+
+			_, err := store.Open("/test")
+			Expect(err).To(MatchError(ContainSubstring("missing Content-Length")))
+		})
+	})
+
+	It("reports a bucket it cannot read", func() {
+		cfg.SecretAccessKey = "wrong"
+		_, err := newS3().Stat("/")
+		Expect(err).To(MatchError(ContainSubstring("SignatureDoesNotMatch")))
+
+		cfg.SecretAccessKey = standInSecretKey
+		cfg.Bucket = "missing"
+		_, err = newS3().Stat("/")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("requires an endpoint, a bucket and both credentials or neither", func() {
+		_, err := storage.NewS3(storage.S3Config{Endpoint: "blobstore:9000", Bucket: standInBucket}, clock.NewClock())
+		Expect(err).To(HaveOccurred())
+
+		_, err = storage.NewS3(storage.S3Config{Endpoint: "http://blobstore:9000"}, clock.NewClock())
+		Expect(err).To(HaveOccurred())
+
+		_, err = storage.NewS3(storage.S3Config{Endpoint: "http://blobstore:9000", Bucket: standInBucket, AccessKeyID: standInKeyID}, clock.NewClock())
+		Expect(err).To(HaveOccurred())
+
+		_, err = storage.NewS3(storage.S3Config{Endpoint: "http://blobstore:9000", Bucket: standInBucket}, clock.NewClock())
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+// s3StandIn is a local S3-compatible server holding the objects of a single
+// bucket in a Memory storage. It checks the signature of every request,
+// serves requests addressed by path and lists two keys at a time, so that
+// clients have to follow continuation tokens.
+type s3StandIn struct {
+	server  *httptest.Server
+	objects *storage.Memory
+
+	mu       sync.Mutex
+	digests  map[string]string
+	requests []*http.Request
+
+	// omitLength leaves the Content-Length header out of the responses to
+	// HEAD requests, as a server streaming its responses might.
+	omitLength bool
+}
+
+func newS3StandIn() *s3StandIn {
+	s := &s3StandIn{
+		objects: storage.NewMemory(clock.NewClock()),
+		digests: map[string]string{},
+	}
+	s.server = httptest.NewServer(s)
+	return s
+}
+
+func (s *s3StandIn) lastRequest() *http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[len(s.requests)-1]
+}
+
+// objectRequests returns the requests made with method for objects rather
+// than for the bucket.
+func (s *s3StandIn) objectRequests(method string) []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var requests []*http.Request
+	for _, req := range s.requests {
+		if req.Method == method && strings.TrimSuffix(req.URL.Path, "/") != "/"+standInBucket {
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+func (s *s3StandIn) continuations() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, req := range s.requests {
+		if req.URL.Query().Get("continuation-token") != "" {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	s.mu.Unlock()
+
+	if err := verifySignature(r); err != nil {
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if parts[0] != standInBucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	key := ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+
+	switch {
+	case key == "" && r.Method == "GET":
+		s.list(w, r)
+	case r.Method == "GET" || r.Method == "HEAD":
+		s.get(w, r, key)
+	case r.Method == "PUT":
+		s.put(w, r, key)
+	case r.Method == "DELETE":
+		s.objects.Delete("/" + key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+func (s *s3StandIn) get(w http.ResponseWriter, r *http.Request, key string) {
+	file, err := s.objects.Open("/" + key)
+	if err != nil {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", key)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", key)
+		return
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	sum := md5.Sum(data)
+	etag := fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:]))
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != etag {
+		writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		return
+	}
+	w.Header().Set("ETag", etag)
+	s.mu.Lock()
+	if digest := s.digests[key]; digest != "" {
+		w.Header().Set("X-Amz-Meta-Sha256", digest)
+	}
+	omitLength := s.omitLength
+	s.mu.Unlock()
+
+	if r.Method == "HEAD" && omitLength {
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// ServeContent honors Range the way S3 does.
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(data))
+}
+
+func (s *s3StandIn) put(w http.ResponseWriter, r *http.Request, key string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	sum := sha256.Sum256(data)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		writeS3Error(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", key)
+		return
+	}
+
+	if err := s.objects.Write("/"+key, bytes.NewReader(data)); err != nil {
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	s.mu.Lock()
+	s.digests[key] = r.Header.Get("X-Amz-Meta-Sha256")
+	s.mu.Unlock()
+}
+
+type standInListResult struct {
+	XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	IsTruncated           bool
+	NextContinuationToken string `xml:",omitempty"`
+	Contents              []standInObject
+	CommonPrefixes        []standInPrefix
+}
+
+type standInObject struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+type standInPrefix struct {
+	Prefix string
+}
+
+func (s *s3StandIn) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	maxKeys := 2
+	if n, err := strconv.Atoi(query.Get("max-keys")); err == nil && n < maxKeys {
+		maxKeys = n
+	}
+
+	objects := map[string]standInObject{}
+	walkObjects(s.objects, "/", objects)
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var result standInListResult
+	listed, last := 0, ""
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		entry := key
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			entry = key[:len(prefix)+i+len(delimiter)]
+		}
+		if entry <= query.Get("continuation-token") || entry == last {
+			continue
+		}
+		if listed == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = last
+			break
+		}
+
+		if entry == key {
+			result.Contents = append(result.Contents, objects[key])
+		} else {
+			result.CommonPrefixes = append(result.CommonPrefixes, standInPrefix{Prefix: entry})
+		}
+		listed, last = listed+1, entry
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func walkObjects(objects *storage.Memory, dir string, found map[string]standInObject) {
+	entries, _ := objects.List(dir)
+	for _, entry := range entries {
+		name := strings.TrimSuffix(dir, "/") + "/" + entry.Name()
+		if entry.IsDir() {
+			walkObjects(objects, name, found)
+			continue
+		}
+		key := strings.TrimPrefix(name, "/")
+		found[key] = standInObject{Key: key, Size: entry.Size(), LastModified: entry.ModTime()}
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
+
+// verifySignature checks the Signature Version 4 of r as S3 would, from the
+// request as it was received.
+func verifySignature(r *http.Request) error {
+	var credential, signedHeaders, signature string
+	for _, field := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 "), ", ") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return errors.New("malformed Authorization header")
+		}
+		switch kv[0] {
+		case "Credential":
+			credential = kv[1]
+		case "SignedHeaders":
+			signedHeaders = kv[1]
+		case "Signature":
+			signature = kv[1]
+		}
+	}
+
+	scope := strings.SplitN(credential, "/", 2)
+	if len(scope) != 2 || scope[0] != standInKeyID {
+		return errors.New("unknown access key")
+	}
+	scopeParts := strings.Split(scope[1], "/")
+	if len(scopeParts) != 4 {
+		return errors.New("malformed credential scope")
+	}
+
+	var headers strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := r.Host
+		if name != "host" {
+			value = strings.Join(r.Header[textproto.CanonicalMIMEHeaderKey(name)], ",")
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + r.Header.Get("X-Amz-Date") + "\n" + scope[1] + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + standInSecretKey)
+	for _, part := range scopeParts {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	if hex.EncodeToString(mac.Sum(nil)) != signature {
+		return fmt.Errorf("the signature of %s %s does not match", r.Method, r.URL.Path)
+	}
+	return nil
+}
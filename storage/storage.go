@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// The backends the served files can be stored in.
+const (
+	LocalBackend = "local"
+	S3Backend    = "s3"
+)
+
+// Config selects the backend holding the served files. The local backend
+// serves the static directory of the file server.
+type Config struct {
+	Backend string   `json:"backend,omitempty"`
+	S3      S3Config `json:"s3"`
+}
+
+// Storage holds the files served by the file server. Files are named by
+// slash-separated paths rooted at "/", as in the URL they are served at, and
+// the root is always a directory. Errors for names that do not exist satisfy
+// os.IsNotExist. Implementations are safe for concurrent use.
+type Storage interface {
+	// Open opens the file or directory name for reading.
+	Open(name string) (File, error)
+
+	Stat(name string) (os.FileInfo, error)
+
+	// List returns the entries of the directory dir, sorted by name.
+	List(dir string) ([]os.FileInfo, error)
+
+	// Write creates or replaces the file name with the contents of r,
+	// creating the directories above it. A file that has started to be read
+	// when it is replaced keeps its old contents.
+	Write(name string, r io.Reader) error
+
+	// Delete removes the file name.
+	Delete(name string) error
+}
+
+// File is a file opened for reading from a Storage.
+type File interface {
+	io.ReadSeeker
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// A Digester is a File whose storage keeps the digest of its contents, so
+// that it does not have to be read to compute one. Digest returns "" when
+// there is none.
+type Digester interface {
+	Digest() string
+}
+
+// A ContextOpener is a Storage whose files read through requests that can be
+// cancelled: the files returned by OpenContext stop reading once ctx is done.
+type ContextOpener interface {
+	OpenContext(ctx context.Context, name string) (File, error)
+}
+
+// OpenContext opens the file or directory name of store, bound to ctx when
+// store is a ContextOpener.
+func OpenContext(ctx context.Context, store Storage, name string) (File, error) {
+	if opener, ok := store.(ContextOpener); ok {
+		return opener.OpenContext(ctx, name)
+	}
+	return store.Open(name)
+}
+
+// A Ranger is a File that can be told the part of it about to be read, length
+// bytes from offset, so that its storage does not send more than that. Reads
+// outside of that part still succeed.
+type Ranger interface {
+	ExpectRange(offset, length int64)
+}
+
+// ErrInvalidName is returned for names that would reach above the root.
+var ErrInvalidName = errors.New("invalid file name")
+
+var (
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// Clean returns the canonical form of name, rooted at "/", or ErrInvalidName
+// when it has a ".." element or a backslash.
+func Clean(name string) (string, error) {
+	if strings.ContainsRune(name, '\\') {
+		return "", ErrInvalidName
+	}
+	for _, element := range strings.Split(name, "/") {
+		if element == ".." {
+			return "", ErrInvalidName
+		}
+	}
+	return path.Clean("/" + name), nil
+}
+
+// fileInfo describes the files and directories of the backends that do not
+// keep them on the local file system.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, size int64, modTime time.Time) os.FileInfo {
+	return &fileInfo{name: name, size: size, mode: 0644, modTime: modTime}
+}
+
+func dirInfo(name string) os.FileInfo {
+	return &fileInfo{name: name, mode: os.ModeDir | 0755}
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) Mode() os.FileMode  { return i.mode }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *fileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,144 @@
+package storage_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"code.cloudfoundry.org/fileserver/storage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// itStoresFiles describes the behavior every Storage shares. newStorage is
+// called before each spec and returns an empty storage.
+func itStoresFiles(newStorage func() storage.Storage) {
+	var store storage.Storage
+
+	BeforeEach(func() {
+		store = newStorage()
+		Expect(store.Write("/test", strings.NewReader("hello"))).To(Succeed())
+		Expect(store.Write("buildpacks/go.zip", strings.NewReader("world"))).To(Succeed())
+	})
+
+	readFile := func(name string) string {
+		file, err := store.Open(name)
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+
+		contents, err := ioutil.ReadAll(file)
+		Expect(err).NotTo(HaveOccurred())
+		return string(contents)
+	}
+
+	It("opens the files written to it", func() {
+		Expect(readFile("/test")).To(Equal("hello"))
+		Expect(readFile("/buildpacks/go.zip")).To(Equal("world"))
+
+		file, err := store.Open("/buildpacks/go.zip")
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+
+		info, err := file.Stat()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Name()).To(Equal("go.zip"))
+		Expect(info.Size()).To(BeEquivalentTo(5))
+		Expect(info.Mode().IsRegular()).To(BeTrue())
+	})
+
+	It("replaces files without disturbing their readers", func() {
+		file, err := store.Open("/test")
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+
+		first := make([]byte, 1)
+		_, err = io.ReadFull(file, first)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(store.Write("/test", bytes.NewReader([]byte("goodbye")))).To(Succeed())
+
+		rest, err := ioutil.ReadAll(file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(first) + string(rest)).To(Equal("hello"))
+		Expect(readFile("/test")).To(Equal("goodbye"))
+	})
+
+	It("reports the files and directories it holds", func() {
+		info, err := store.Stat("/")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.IsDir()).To(BeTrue())
+
+		info, err = store.Stat("/buildpacks")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.IsDir()).To(BeTrue())
+
+		info, err = store.Stat("/test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.IsDir()).To(BeFalse())
+		Expect(info.Size()).To(BeEquivalentTo(5))
+
+		_, err = store.Stat("/missing")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("lists the entries of a directory by name", func() {
+		entries, err := store.List("/")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Name()).To(Equal("buildpacks"))
+		Expect(entries[0].IsDir()).To(BeTrue())
+		Expect(entries[1].Name()).To(Equal("test"))
+		Expect(entries[1].IsDir()).To(BeFalse())
+
+		entries, err = store.List("/buildpacks")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Name()).To(Equal("go.zip"))
+
+		_, err = store.List("/test")
+		Expect(err).To(HaveOccurred())
+		_, err = store.List("/missing")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("deletes files", func() {
+		Expect(store.Delete("/buildpacks/go.zip")).To(Succeed())
+
+		_, err := store.Open("/buildpacks/go.zip")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		Expect(os.IsNotExist(store.Delete("/buildpacks/go.zip"))).To(BeTrue())
+		Expect(store.Delete("/")).NotTo(Succeed())
+	})
+
+	It("refuses to write over a directory or below a file", func() {
+		Expect(store.Write("/buildpacks", strings.NewReader("hello"))).NotTo(Succeed())
+		Expect(store.Write("/test/nested", strings.NewReader("hello"))).NotTo(Succeed())
+		Expect(readFile("/test")).To(Equal("hello"))
+	})
+
+	It("rejects names that reach above the root", func() {
+		_, err := store.Open("/../test")
+		Expect(err).To(MatchError(ContainSubstring(storage.ErrInvalidName.Error())))
+		Expect(store.Write("../escaped", strings.NewReader("hello"))).NotTo(Succeed())
+		Expect(store.Delete("/buildpacks/../test")).NotTo(Succeed())
+	})
+}
+
+var _ = Describe("Clean", func() {
+	It("roots and cleans names", func() {
+		Expect(storage.Clean("")).To(Equal("/"))
+		Expect(storage.Clean("buildpacks//go.zip")).To(Equal("/buildpacks/go.zip"))
+		Expect(storage.Clean("/buildpacks/./go.zip/")).To(Equal("/buildpacks/go.zip"))
+	})
+
+	It("rejects names with a .. element or a backslash", func() {
+		_, err := storage.Clean("/buildpacks/../../etc/passwd")
+		Expect(err).To(Equal(storage.ErrInvalidName))
+		_, err = storage.Clean(`\etc\passwd`)
+		Expect(err).To(Equal(storage.ErrInvalidName))
+		Expect(storage.Clean("/test..")).To(Equal("/test.."))
+	})
+})
@@ -0,0 +1,178 @@
+package tlsreload
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/tlsconfig"
+)
+
+const DefaultPollInterval = 10 * time.Second
+
+// Reloader holds the TLS configuration of a server, built from its
+// certificate, key and optional client CA files, and rebuilds it when
+// reloaded. A configuration that fails to build, for instance because the key
+// does not match the certificate or the certificate has expired, is not
+// swapped in: the server keeps presenting its previous identity.
+//
+// As an ifrit.Runner, it polls the files and reloads when one has changed.
+type Reloader struct {
+	logger   lager.Logger
+	clock    clock.Clock
+	interval time.Duration
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu     sync.RWMutex
+	config *tls.Config
+	stats  map[string]fileStat
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// New loads the files once, failing if they do not make a valid
+// configuration. A client CA file makes the server require client
+// certificates signed by one of its CAs.
+func New(logger lager.Logger, clock clock.Clock, interval time.Duration, certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{
+		logger:   logger.Session("tls-reloader"),
+		clock:    clock,
+		interval: interval,
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+
+	stats := r.statFiles()
+	config, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.config = config
+	r.stats = stats
+
+	return r, nil
+}
+
+// TLSConfig returns the configuration to serve with. Every handshake uses the
+// configuration loaded last.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.config, nil
+		},
+	}
+}
+
+// Certificate returns the leaf certificate of the configuration loaded last.
+func (r *Reloader) Certificate() *x509.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.config.Certificates[0].Leaf
+}
+
+// Reload loads the files again and swaps the configuration if they make a
+// valid one.
+func (r *Reloader) Reload() error {
+	logger := r.logger.Session("reload")
+
+	stats := r.statFiles()
+	config, err := r.load()
+
+	r.mu.Lock()
+	r.stats = stats
+	if err == nil {
+		r.config = config
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		logger.Error("failed-to-load-tls-config", err)
+		return err
+	}
+
+	leaf := config.Certificates[0].Leaf
+	logger.Info("reloaded", lager.Data{"subject": leaf.Subject.String(), "not-after": leaf.NotAfter})
+	return nil
+}
+
+func (r *Reloader) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	for {
+		select {
+		case <-ticker.C():
+			if r.changed() {
+				r.Reload()
+			}
+		case <-signals:
+			return nil
+		}
+	}
+}
+
+func (r *Reloader) load() (*tls.Config, error) {
+	var serverOptions []tlsconfig.ServerOption
+	if r.caFile != "" {
+		serverOptions = append(serverOptions, tlsconfig.WithClientAuthenticationFromFile(r.caFile))
+	}
+
+	config, err := tlsconfig.Build(
+		tlsconfig.WithInternalServiceDefaults(),
+		tlsconfig.WithIdentityFromFile(r.certFile, r.keyFile),
+	).Server(serverOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &config.Certificates[0]
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// changed reports whether any file was modified since it was last loaded.
+func (r *Reloader) changed() bool {
+	stats := r.statFiles()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for file, stat := range stats {
+		if r.stats[file] != stat {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) statFiles() map[string]fileStat {
+	stats := map[string]fileStat{}
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			stats[file] = fileStat{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return stats
+}
@@ -0,0 +1,13 @@
+package tlsreload_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestTLSReload(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "TLS Reload Suite")
+}
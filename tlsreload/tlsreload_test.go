@@ -0,0 +1,195 @@
+package tlsreload_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/fileserver/tlsreload"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/tlsconfig/certtest"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reloader", func() {
+	var (
+		tmpDir            string
+		certFile, keyFile string
+		caFile            string
+		ca                *certtest.Authority
+		fakeClock         *fakeclock.FakeClock
+	)
+
+	writeIdentity := func(name string) {
+		cert, err := ca.BuildSignedCertificate(name)
+		Expect(err).NotTo(HaveOccurred())
+		certPEM, keyPEM, err := cert.CertificatePEMAndPrivateKey()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(certFile, certPEM, 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(keyFile, keyPEM, 0600)).To(Succeed())
+	}
+
+	served := func(reloader *tlsreload.Reloader) (string, *tls.Config) {
+		config, err := reloader.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+		Expect(err).NotTo(HaveOccurred())
+		leaf, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		Expect(err).NotTo(HaveOccurred())
+		return leaf.Subject.CommonName, config
+	}
+
+	servedName := func(reloader *tlsreload.Reloader) func() string {
+		return func() string {
+			name, _ := served(reloader)
+			return name
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "tlsreload-test")
+		Expect(err).NotTo(HaveOccurred())
+		certFile = filepath.Join(tmpDir, "cert.pem")
+		keyFile = filepath.Join(tmpDir, "key.pem")
+		caFile = ""
+
+		ca, err = certtest.BuildCA("test-ca")
+		Expect(err).NotTo(HaveOccurred())
+		writeIdentity("first")
+
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	newReloader := func() *tlsreload.Reloader {
+		reloader, err := tlsreload.New(lagertest.NewTestLogger("test"), fakeClock, time.Second, certFile, keyFile, caFile)
+		Expect(err).NotTo(HaveOccurred())
+		return reloader
+	}
+
+	It("serves the identity it was created with", func() {
+		name, config := served(newReloader())
+		Expect(name).To(Equal("first"))
+		Expect(config.ClientAuth).To(Equal(tls.NoClientCert))
+	})
+
+	It("fails to create when the files are invalid", func() {
+		Expect(ioutil.WriteFile(keyFile, []byte("garbage"), 0600)).To(Succeed())
+		_, err := tlsreload.New(lagertest.NewTestLogger("test"), fakeClock, time.Second, certFile, keyFile, "")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("serves the new identity once reloaded", func() {
+		reloader := newReloader()
+		writeIdentity("second")
+
+		Expect(reloader.Reload()).To(Succeed())
+		Expect(servedName(reloader)()).To(Equal("second"))
+	})
+
+	It("returns the certificate it serves", func() {
+		reloader := newReloader()
+		Expect(reloader.Certificate().Subject.CommonName).To(Equal("first"))
+
+		writeIdentity("second")
+		Expect(reloader.Reload()).To(Succeed())
+		Expect(reloader.Certificate().Subject.CommonName).To(Equal("second"))
+	})
+
+	It("keeps the old identity when the new one is invalid", func() {
+		reloader := newReloader()
+
+		other, err := ca.BuildSignedCertificate("other")
+		Expect(err).NotTo(HaveOccurred())
+		_, otherKey, err := other.CertificatePEMAndPrivateKey()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(keyFile, otherKey, 0600)).To(Succeed())
+
+		Expect(reloader.Reload()).NotTo(Succeed())
+		Expect(servedName(reloader)()).To(Equal("first"))
+	})
+
+	It("keeps the old identity when the new one has expired", func() {
+		reloader := newReloader()
+
+		expired, err := ca.BuildSignedCertificateWithExpiry("expired", time.Now().Add(-time.Hour))
+		Expect(err).NotTo(HaveOccurred())
+		certPEM, keyPEM, err := expired.CertificatePEMAndPrivateKey()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(certFile, certPEM, 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(keyFile, keyPEM, 0600)).To(Succeed())
+
+		Expect(reloader.Reload()).To(MatchError(ContainSubstring("expired")))
+		Expect(servedName(reloader)()).To(Equal("first"))
+	})
+
+	Context("with a client CA file", func() {
+		BeforeEach(func() {
+			caPEM, err := ca.CertificatePEM()
+			Expect(err).NotTo(HaveOccurred())
+			caFile = filepath.Join(tmpDir, "ca.pem")
+			Expect(ioutil.WriteFile(caFile, caPEM, 0600)).To(Succeed())
+		})
+
+		It("requires client certificates signed by it", func() {
+			_, config := served(newReloader())
+			Expect(config.ClientAuth).To(Equal(tls.RequireAndVerifyClientCert))
+			Expect(config.ClientCAs).NotTo(BeNil())
+		})
+
+		It("reloads it along with the identity, and keeps the old one when it is invalid", func() {
+			reloader := newReloader()
+			_, before := served(reloader)
+
+			Expect(ioutil.WriteFile(caFile, []byte("garbage"), 0600)).To(Succeed())
+			Expect(reloader.Reload()).NotTo(Succeed())
+			_, after := served(reloader)
+			Expect(after).To(BeIdenticalTo(before))
+		})
+	})
+
+	Context("when running", func() {
+		var (
+			reloader *tlsreload.Reloader
+			process  ifrit.Process
+		)
+
+		BeforeEach(func() {
+			reloader = newReloader()
+			process = ifrit.Invoke(reloader)
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		It("reloads when the files change", func() {
+			writeIdentity("second")
+			future := time.Now().Add(time.Minute)
+			Expect(os.Chtimes(certFile, future, future)).To(Succeed())
+
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(servedName(reloader)).Should(Equal("second"))
+		})
+
+		It("does not reload while the files are unchanged", func() {
+			_, before := served(reloader)
+
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Consistently(func() *tls.Config {
+				_, config := served(reloader)
+				return config
+			}).Should(BeIdenticalTo(before))
+		})
+	})
+})
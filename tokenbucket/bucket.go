@@ -0,0 +1,120 @@
+package tokenbucket
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+// Bucket is a token bucket that refills continuously at a fixed rate up to
+// its capacity. It is safe for concurrent use.
+type Bucket struct {
+	clock clock.Clock
+
+	mu       sync.Mutex
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+// New returns a full bucket that refills at rate tokens per second and holds
+// at most burst tokens. A burst smaller than one is treated as one.
+func New(clock clock.Clock, rate float64, burst int) *Bucket {
+	capacity := float64(burst)
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &Bucket{
+		clock:    clock,
+		rate:     rate,
+		capacity: capacity,
+		tokens:   capacity,
+		last:     clock.Now(),
+	}
+}
+
+// TryTake removes n tokens if they are all available. Otherwise it leaves the
+// bucket untouched and returns how long it will take for n tokens to be
+// available.
+func (b *Bucket) TryTake(n float64) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	if b.tokens >= n {
+		b.tokens -= n
+		return true, 0
+	}
+
+	return false, b.waitFor(n - b.tokens)
+}
+
+// Take removes n tokens, letting the bucket go into debt if necessary, and
+// returns how long the caller has to wait before the tokens are considered
+// spent.
+func (b *Bucket) Take(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return b.waitFor(-b.tokens)
+}
+
+// SetRate changes the rate and burst of the bucket without refilling it: the
+// tokens already taken stay taken, and the bucket holds no more than the new
+// burst.
+func (b *Bucket) SetRate(rate float64, burst int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	b.rate = rate
+	b.capacity = float64(burst)
+	if b.capacity < 1 {
+		b.capacity = 1
+	}
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+// Full reports whether the bucket has refilled to capacity, in which case it
+// is indistinguishable from a newly created one.
+func (b *Bucket) Full() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	return b.tokens >= b.capacity
+}
+
+func (b *Bucket) refill() {
+	now := b.clock.Now()
+	elapsed := now.Sub(b.last)
+	b.last = now
+	if elapsed <= 0 {
+		return
+	}
+
+	b.tokens += elapsed.Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+func (b *Bucket) waitFor(deficit float64) time.Duration {
+	if b.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration(deficit / b.rate * float64(time.Second))
+}
@@ -0,0 +1,99 @@
+package tokenbucket_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/fileserver/tokenbucket"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bucket", func() {
+	var (
+		fakeClock *fakeclock.FakeClock
+		bucket    *tokenbucket.Bucket
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		bucket = tokenbucket.New(fakeClock, 2, 4)
+	})
+
+	Describe("TryTake", func() {
+		It("allows up to the burst size immediately", func() {
+			for i := 0; i < 4; i++ {
+				ok, _ := bucket.TryTake(1)
+				Expect(ok).To(BeTrue())
+			}
+
+			ok, wait := bucket.TryTake(1)
+			Expect(ok).To(BeFalse())
+			Expect(wait).To(Equal(500 * time.Millisecond))
+		})
+
+		It("refills at the configured rate", func() {
+			ok, _ := bucket.TryTake(4)
+			Expect(ok).To(BeTrue())
+
+			fakeClock.Increment(time.Second)
+
+			ok, _ = bucket.TryTake(2)
+			Expect(ok).To(BeTrue())
+			ok, _ = bucket.TryTake(1)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("never refills past the burst size", func() {
+			fakeClock.Increment(time.Hour)
+
+			ok, _ := bucket.TryTake(5)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("Take", func() {
+		It("goes into debt and reports the time needed to pay it back", func() {
+			Expect(bucket.Take(4)).To(BeZero())
+			Expect(bucket.Take(6)).To(Equal(3 * time.Second))
+
+			fakeClock.Increment(3 * time.Second)
+			Expect(bucket.Take(1)).To(Equal(500 * time.Millisecond))
+		})
+	})
+
+	Describe("SetRate", func() {
+		It("keeps the tokens already taken and refills at the new rate", func() {
+			Expect(bucket.Take(4)).To(BeZero())
+
+			bucket.SetRate(4, 8)
+			ok, wait := bucket.TryTake(1)
+			Expect(ok).To(BeFalse())
+			Expect(wait).To(Equal(250 * time.Millisecond))
+
+			fakeClock.Increment(2 * time.Second)
+			ok, _ = bucket.TryTake(8)
+			Expect(ok).To(BeTrue())
+		})
+
+		It("holds no more than the new burst", func() {
+			bucket.SetRate(2, 1)
+
+			ok, _ := bucket.TryTake(2)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("Full", func() {
+		It("reports whether the bucket has refilled to capacity", func() {
+			Expect(bucket.Full()).To(BeTrue())
+
+			bucket.Take(1)
+			Expect(bucket.Full()).To(BeFalse())
+
+			fakeClock.Increment(500 * time.Millisecond)
+			Expect(bucket.Full()).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1 @@
+package tokenbucket // import "code.cloudfoundry.org/fileserver/tokenbucket"
@@ -0,0 +1,13 @@
+package tokenbucket_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestTokenbucket(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tokenbucket Suite")
+}